@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	docs "github.com/sater-151/AuthSystem/docs"
 	"github.com/sater-151/AuthSystem/internal/config"
 	"github.com/sater-151/AuthSystem/internal/controller/rest"
 	"github.com/sater-151/AuthSystem/internal/controller/rest/middleware"
 	"github.com/sater-151/AuthSystem/internal/database/postgresql"
+	"github.com/sater-151/AuthSystem/internal/pkg/denylist"
+	"github.com/sater-151/AuthSystem/internal/pkg/jwe"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+	"github.com/sater-151/AuthSystem/internal/pkg/paseto"
 	"github.com/sater-151/AuthSystem/internal/pkg/webhooks"
 	authsystem "github.com/sater-151/AuthSystem/internal/services/authSystem"
+	"github.com/sater-151/AuthSystem/internal/utils"
 	"github.com/sirupsen/logrus"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -25,22 +31,34 @@ import (
 // @host		localhost:8080
 // @BasePath	/api
 
-// @securitydefinitions.apikey AccessToken
-// @in header
-// @name at
-
 // @securitydefinitions.apikey RefreshToken
 // @in header
 // @name rt
+
+// @securitydefinitions.apikey Bearer
+// @in header
+// @name Authorization
+
+// @securitydefinitions.basic ClientBasic
 func main() {
-	if err := godotenv.Load(); err != nil {
+	if err := config.LoadEnv(); err != nil {
 		logrus.Error(err)
 		return
 	}
 
 	config.InitLoggerConfig()
 
-	psqlConfig := config.GetPostresqlConfig()
+	secretConfig := config.GetSecretConfig()
+	psqlConfig, err := config.GetPostresqlConfig(secretConfig)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	tokenConfig, err := config.GetTokenConfig(secretConfig)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
 	db, close, err := postgresql.Open(psqlConfig)
 	if err != nil {
 		logrus.Error(err)
@@ -51,10 +69,115 @@ func main() {
 		logrus.Error(err)
 		return
 	}
+	// собственный ключ тенанта поддерживается только для HS512, иначе тенант подписывал бы токены общим ключом
+	if tokenConfig.Algorithm != keys.AlgHS512 {
+		tenantSecrets, err := db.HasTenantSecrets()
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		if tenantSecrets {
+			logrus.Errorf("tenants.jwt_secret requires %s, current algorithm is %s", keys.AlgHS512, tokenConfig.Algorithm)
+			return
+		}
+	}
 	serverConfig := config.GetServerConfig()
+	transportConfig, err := config.GetTransportConfig()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	signingKey := keys.NewHMAC(tokenConfig.Secret)
+	if tokenConfig.PKCS11Module != "" {
+		var closeToken func() error
+		signingKey, closeToken, err = keys.LoadPKCS11(tokenConfig.Algorithm, keys.PKCS11Config{
+			Module:     tokenConfig.PKCS11Module,
+			TokenLabel: tokenConfig.PKCS11TokenLabel,
+			PIN:        tokenConfig.PKCS11PIN,
+			KeyLabel:   tokenConfig.PKCS11KeyLabel,
+		})
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		defer closeToken()
+	} else if tokenConfig.Algorithm != keys.AlgHS512 {
+		signingKey, err = keys.Load(tokenConfig.Algorithm, tokenConfig.PrivateKeyFile, tokenConfig.AllowTemporaryKeys)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+
+	if !utils.ValidFormat(tokenConfig.Format) {
+		logrus.Errorf("unsupported token format %s", tokenConfig.Format)
+		return
+	}
+	// ключи PASETO загружаются и при выпуске JWT, если заданы, чтобы после возврата
+	// на JWT ранее выпущенные токены PASETO проверялись до истечения
+	var pasetoKeys *paseto.Keys
+	if tokenConfig.Format != utils.FormatJWT || tokenConfig.PasetoLocalKey != "" || tokenConfig.PasetoPublicKeyFile != "" {
+		pasetoKeys, err = paseto.LoadKeys(tokenConfig.PasetoLocalKey, tokenConfig.PasetoPublicKeyFile, tokenConfig.AllowTemporaryKeys)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		if (tokenConfig.Format == utils.FormatPasetoLocal && pasetoKeys.Local == nil) || (tokenConfig.Format == utils.FormatPasetoPublic && pasetoKeys.Secret == nil) {
+			logrus.Errorf("%s: %v", tokenConfig.Format, paseto.ErrKeyNotSet)
+			return
+		}
+	}
+
+	// ключи JWE, как и ключи PASETO, остаются в конфигурации после смены формата, пока не истекут зашифрованные токены
+	var encryption *jwe.Ring
+	if tokenConfig.Format == utils.FormatJWE || tokenConfig.EncryptionKeys != "" {
+		encryption, err = jwe.LoadRing(tokenConfig.EncryptionKeys, tokenConfig.AllowTemporaryKeys)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+
+	keyConfig := config.GetKeyConfig(tokenConfig)
+	ring := keys.NewRing(signingKey)
+	if keyConfig.Store != "" {
+		// ротация создаёт ключи в памяти процесса, что противоречит хранению ключа на токене
+		if tokenConfig.PKCS11Module != "" {
+			logrus.Error("key store can't be used with pkcs11 signing key")
+			return
+		}
+		var store keys.Store = db
+		if keyConfig.Store == "file" {
+			store = keys.FileStore{Dir: keyConfig.StoreDir}
+		}
+		keyManager := keys.NewManager(store, signingKey, keys.ManagerConfig{
+			Alg:         tokenConfig.Algorithm,
+			Interval:    keyConfig.Rotation,
+			Retention:   keyConfig.Retention,
+			Propagation: 2 * time.Minute,
+			Reload:      time.Minute,
+		})
+		if err := keyManager.Reload(); err != nil {
+			logrus.Error(err)
+			return
+		}
+		ring = keyManager.Ring()
+		go keyManager.Run(context.Background())
+	}
+
+	revoked := denylist.New(db)
+	if err := revoked.Sync(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	go revoked.Run(context.Background(), tokenConfig.DenylistSync)
 
 	wh := webhooks.NewClient()
-	authsystem := authsystem.New(db, wh)
+	authsystem, err := authsystem.New(db, wh, tokenConfig, ring, revoked, pasetoKeys, encryption)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
 
 	router := gin.Default()
 
@@ -67,12 +190,42 @@ func main() {
 		c.Redirect(http.StatusFound, location.RequestURI())
 	})
 
-	router.POST("/api/login", rest.Login(authsystem))
-	router.POST("/api/refresh", rest.Refresh(authsystem))
-	{
-		authGroup := router.Group("/api/auth", middleware.CheckAuthorization(authsystem))
-		authGroup.POST("/logout", rest.Deauthorization(authsystem))
+	router.GET("/.well-known/jwks.json", rest.JWKS(authsystem))
+	router.GET("/.well-known/openid-configuration", rest.OpenIDConfiguration(authsystem))
+
+	// Тенант определяется по заголовку Host для /api или явно по пути /api/t/:tenant
+	for _, api := range []*gin.RouterGroup{
+		router.Group("/api", middleware.ResolveTenant(authsystem), middleware.TokenTransport(transportConfig)),
+		router.Group("/api/t/:tenant", middleware.ResolveTenant(authsystem), middleware.TokenTransport(transportConfig)),
+	} {
+		api.POST("/login", rest.Login(authsystem))
+		api.POST("/refresh", rest.Refresh(authsystem))
+
+		oauthGroup := api.Group("/oauth", middleware.AuthenticateClient(authsystem))
+		oauthGroup.POST("/introspect", rest.Introspect(authsystem))
+		oauthGroup.POST("/revoke", rest.Revoke(authsystem))
+		oauthGroup.POST("/token", rest.Token(authsystem))
+		oauthGroup.POST("/device_authorization", rest.DeviceAuthorization(authsystem))
+		api.GET("/oauth/userinfo", rest.UserInfo(authsystem))
+		api.POST("/oauth/userinfo", rest.UserInfo(authsystem))
+
+		// запрос авторизации выполняет пользователь, вошедший через /login, а не клиент
+		authorizeGroup := api.Group("/oauth/authorize", middleware.CheckAuthorization(authsystem), middleware.RequireUser(), middleware.DenyImpersonation())
+		authorizeGroup.GET("", rest.Authorize(authsystem))
+		authorizeGroup.POST("", rest.AuthorizeConsent(authsystem))
+
+		deviceGroup := api.Group("/oauth/device", middleware.CheckAuthorization(authsystem), middleware.RequireUser(), middleware.DenyImpersonation())
+		deviceGroup.GET("", rest.GetDeviceRequest(authsystem))
+		deviceGroup.POST("", rest.ApproveDevice(authsystem))
+
+		authGroup := api.Group("/auth", middleware.CheckAuthorization(authsystem), middleware.RequireUser())
+		authGroup.POST("/logout", middleware.DenyImpersonation(), rest.Deauthorization(authsystem))
 		authGroup.GET("/guid", rest.GetGUID(authsystem))
+		authGroup.GET("/me", middleware.RequireScopes("profile"), rest.GetProfile(authsystem))
+		authGroup.PATCH("/me", middleware.DenyImpersonation(), middleware.RequireScopes("profile"), rest.UpdateProfile(authsystem))
+		authGroup.POST("/impersonate", middleware.DenyImpersonation(), rest.Impersonate(authsystem))
+		authGroup.POST("/admin/revoke", middleware.DenyImpersonation(), rest.RevokeUser(authsystem))
+		authGroup.POST("/admin/clients", middleware.DenyImpersonation(), rest.RegisterClient(authsystem))
 	}
 
 	if err := router.Run(":" + serverConfig.Port); err != nil {
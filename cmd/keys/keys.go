@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/database/postgresql"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	if err := config.LoadEnv(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if len(os.Args) < 2 {
+		logrus.Fatal("Usage: keys <command>\nAvailable commands: rotate, list")
+	}
+	secretConfig := config.GetSecretConfig()
+	tokenConfig, err := config.GetTokenConfig(secretConfig)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	keyConfig := config.GetKeyConfig(tokenConfig)
+
+	var store keys.Store
+	switch keyConfig.Store {
+	case "file":
+		store = keys.FileStore{Dir: keyConfig.StoreDir}
+	case "db":
+		psqlConfig, err := config.GetPostresqlConfig(secretConfig)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		db, close, err := postgresql.Open(psqlConfig)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		defer close()
+		store = db
+	default:
+		logrus.Fatal("KEY_STORE must be db or file")
+	}
+
+	command := os.Args[1]
+	switch command {
+	case "rotate":
+		manager := keys.NewManager(store, keys.Key{}, keys.ManagerConfig{
+			Alg:         tokenConfig.Algorithm,
+			Retention:   keyConfig.Retention,
+			Propagation: 2 * time.Minute,
+		})
+		if err := manager.Rotate(); err != nil {
+			logrus.Fatalf("Failed to rotate signing key: %v", err)
+		}
+		logrus.Printf("Signing key created!")
+	case "list":
+		storedKeys, err := store.GetSigningKeys()
+		if err != nil {
+			logrus.Fatalf("Failed to get signing keys: %v", err)
+		}
+		for _, key := range storedKeys {
+			logrus.Printf("%s %s created %s activates %s", key.ID, key.Alg, key.CreatedAt.Format(time.RFC3339), key.ActivatesAt.Format(time.RFC3339))
+		}
+	default:
+		logrus.Fatalf("Unknown command: %s\nAvailable commands: rotate, list", command)
+	}
+}
@@ -6,18 +6,21 @@ import (
 	"os"
 
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/joho/godotenv"
 	"github.com/sater-151/AuthSystem/internal/config"
 	"github.com/sater-151/AuthSystem/internal/database/postgresql"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	if err := config.LoadEnv(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	psqlConfig, err := config.GetPostresqlConfig(config.GetSecretConfig())
+	if err != nil {
 		logrus.Error(err)
 		return
 	}
-	psqlConfig := config.GetPostresqlConfig()
 	db, close, err := postgresql.Open(psqlConfig)
 	if err != nil {
 		logrus.Error(err)
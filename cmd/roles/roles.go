@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+
+	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/database/postgresql"
+	"github.com/sirupsen/logrus"
+)
+
+// roles назначает и снимает роли пользователей. Роли не назначаются через API и миграции,
+// команду запускает оператор с доступом к базе
+func main() {
+	if err := config.LoadEnv(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if len(os.Args) < 5 {
+		logrus.Fatal("Usage: roles <command> <tenant> <guid> <role>\nAvailable commands: grant, revoke")
+	}
+	psqlConfig, err := config.GetPostresqlConfig(config.GetSecretConfig())
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	db, close, err := postgresql.Open(psqlConfig)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer close()
+
+	command, tenantID, guid, role := os.Args[1], os.Args[2], os.Args[3], os.Args[4]
+	switch command {
+	case "grant":
+		err = db.GrantRole(tenantID, guid, role)
+	case "revoke":
+		err = db.RevokeRole(tenantID, guid, role)
+	default:
+		logrus.Fatalf("Unknown command: %s\nAvailable commands: grant, revoke", command)
+	}
+	if err == sql.ErrNoRows {
+		logrus.Fatalf("User %s isn't found in tenant %s", guid, tenantID)
+	}
+	if err != nil {
+		logrus.Fatalf("Failed to %s role: %v", command, err)
+	}
+	logrus.Printf("Roles of %s updated: %s %s", guid, command, role)
+}
@@ -0,0 +1,8 @@
+-- Тестовые данные с известными секретами только для локальной разработки.
+-- Не выполняйте этот файл в общих окружениях: секреты клиентов опубликованы в README
+INSERT INTO oauth_clients (client_id, name, secret_hash, introspection) VALUES ('resource-server', 'Test resource server', crypt('resource-server-secret', gen_salt('bf')), true) ON CONFLICT DO NOTHING;
+INSERT INTO oauth_clients (client_id, name, secret_hash, client_credentials, scopes) VALUES ('orders-job', 'Test orders background job', crypt('orders-job-secret', gen_salt('bf')), true, '{orders:read,orders:write}') ON CONFLICT DO NOTHING;
+INSERT INTO oauth_clients (client_id, name, secret_hash, scopes, exchange_audiences) VALUES ('orders-api', 'Test orders service', crypt('orders-api-secret', gen_salt('bf')), '{payments:read,payments:write}', '{payments-api}') ON CONFLICT DO NOTHING;
+INSERT INTO oauth_clients (client_id, name, redirect_uris, first_party) VALUES ('mobile-app', 'Test public mobile client', '{http://localhost:3000/callback,com.example.app:/oauth2redirect}', true) ON CONFLICT DO NOTHING;
+-- Администратор для проверки имперсонации. Вход по /api/login не проверяет учётные данные
+UPDATE users_auth SET roles = array_append(roles, 'admin') WHERE tenant_id='default' AND user_id='2df8716b-d385-4b7e-aae9-4618996c438a' AND NOT 'admin' = ANY(roles);
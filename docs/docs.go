@@ -15,26 +15,89 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/auth/guid": {
+        "/.well-known/jwks.json": {
             "get": {
-                "security": [
-                    {
-                        "AccessToken": []
-                    },
-                    {
-                        "RefreshToken": []
+                "description": "Открытые ключи для проверки подписи access токенов (RS256, ES256, EdDSA). При подписи HS512 список ключей пуст",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Keys"
+                ],
+                "summary": "JSON Web Key Set",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.JWKS"
+                        }
                     }
+                }
+            }
+        },
+        "/.well-known/openid-configuration": {
+            "get": {
+                "description": "Метаданные провайдера OpenID Connect. Адреса эндпоинтов строятся от JWT_ISSUER, если он не задан, от адреса запроса",
+                "produces": [
+                    "application/json"
                 ],
-                "description": "получение guid пользователя из полученного access токена",
                 "tags": [
-                    "Get"
+                    "OIDC"
                 ],
-                "summary": "Get user's guid",
+                "summary": "OpenID Connect discovery",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/dto.GUID"
+                            "$ref": "#/definitions/dto.OpenIDConfiguration"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/auth/admin/clients": {
+            "post": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Регистрация OAuth клиента администратором. Секрет создаётся для конфиденциального клиента и возвращается только в этом ответе.\nДоверенному клиенту (first_party) код авторизации выдаётся без запроса согласия пользователя",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Register OAuth client",
+                "parameters": [
+                    {
+                        "description": "client",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.RegisterClient"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/dto.Client"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
                     "401": {
@@ -46,6 +109,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -58,25 +130,45 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/auth/logout": {
+        "/api/auth/admin/revoke": {
             "post": {
                 "security": [
                     {
-                        "AccessToken": []
-                    },
-                    {
-                        "RefreshToken": []
+                        "Bearer": []
                     }
                 ],
-                "description": "Деавторизация пользователя на основе guid из access токена. ВНИМАНИЕ! Guid пользователя будет удалено из БД",
+                "description": "Завершение сессии пользователя администратором. Refresh токен удаляется, последний выпущенный access токен\nотзывается и перестаёт приниматься в течение интервала синхронизации denylist",
+                "consumes": [
+                    "application/json"
+                ],
                 "tags": [
                     "Auth"
                 ],
-                "summary": "User deauthorization",
+                "summary": "Revoke user session",
+                "parameters": [
+                    {
+                        "description": "target user",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.RevokeUserRequest"
+                        }
+                    }
+                ],
                 "responses": {
                     "204": {
                         "description": "No Content"
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -86,6 +178,24 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -98,35 +208,87 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/login": {
+        "/api/auth/guid": {
+            "get": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "получение guid пользователя из полученного access токена. Устарело, используйте /api/auth/me",
+                "tags": [
+                    "Get"
+                ],
+                "summary": "Get user's guid",
+                "deprecated": true,
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.GUID"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/auth/impersonate": {
             "post": {
-                "description": "Генерация access и refresh токенов для пользователя с указанным guid",
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Выпуск короткоживущего access токена пользователя для сотрудника поддержки. Доступно только администраторам.\nRefresh токен не выдаётся, сотрудник указывается в claim act, выпуск записывается в журнал аудита",
+                "consumes": [
+                    "application/json"
+                ],
                 "tags": [
                     "Auth"
                 ],
-                "summary": "User authorization",
+                "summary": "Issue impersonation token",
                 "parameters": [
                     {
-                        "type": "string",
-                        "default": "090bb747-d6d3-4067-a1da-2b83726eb24d",
-                        "description": "user guid/id",
-                        "name": "guid",
-                        "in": "query",
-                        "required": true
+                        "description": "target user and reason",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.ImpersonateRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "201": {
                         "description": "Created",
-                        "headers": {
-                            "at": {
-                                "type": "Cookie",
-                                "description": "access token. Время жизни токена 60 секунд. Время жизни Cookie 30 дней."
-                            },
-                            "rt": {
-                                "type": "Cookie",
-                                "description": "refresh token. Время жизни Cookie 30 дней."
-                            }
+                        "schema": {
+                            "$ref": "#/definitions/dto.ImpersonationToken"
                         }
                     },
                     "400": {
@@ -147,6 +309,24 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -159,35 +339,70 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/refresh": {
+        "/api/auth/logout": {
             "post": {
-                "description": "Генерация новых access и refresh токенов на основе guid в access токене",
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Деавторизация пользователя на основе guid из access токена. Завершается сессия, в которой выпущен токен, профиль, роли и статус пользователя сохраняются",
                 "tags": [
                     "Auth"
                 ],
-                "summary": "Refresh tokens",
+                "summary": "User deauthorization",
                 "responses": {
-                    "201": {
-                        "description": "Created",
-                        "headers": {
-                            "at": {
-                                "type": "Cookie",
-                                "description": "access token. Время жизни токена 60 секунд. Время жизни Cookie 30 дней."
-                            },
-                            "rt": {
-                                "type": "Cookie",
-                                "description": "refresh token. Время жизни Cookie 30 дней."
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
                             }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
                                 "type": "string"
                             }
                         }
+                    }
+                }
+            }
+        },
+        "/api/auth/me": {
+            "get": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "получение профиля пользователя и информации о текущей сессии по access токену, требуется scope profile",
+                "tags": [
+                    "Get"
+                ],
+                "summary": "Get current user's profile",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.Profile"
+                        }
                     },
                     "401": {
                         "description": "Unauthorized",
@@ -198,6 +413,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -208,25 +432,1638 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "dto.GUID": {
-            "type": "object",
-            "properties": {
-                "guid": {
-                    "type": "string",
-                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
-                }
-            }
-        }
-    },
-    "securityDefinitions": {
-        "AccessToken": {
-            "type": "apiKey",
-            "name": "at",
-            "in": "header"
+            },
+            "patch": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "изменение email и отображаемого имени пользователя. Не переданные поля не изменяются, требуется scope profile",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Update current user's profile",
+                "parameters": [
+                    {
+                        "description": "profile attributes",
+                        "name": "profile",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.UpdateProfile"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.Profile"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/login": {
+            "post": {
+                "description": "Генерация access и refresh токенов для пользователя с указанным guid",
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "User authorization",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "090bb747-d6d3-4067-a1da-2b83726eb24d",
+                        "description": "user guid/id",
+                        "name": "guid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "scope токенов, должен входить в DEFAULT_SCOPE. По умолчанию DEFAULT_SCOPE",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "DPoP proof (RFC 9449), привязывает токены сессии к ключу",
+                        "name": "DPoP",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "browser",
+                            "native"
+                        ],
+                        "type": "string",
+                        "description": "тип клиента при TOKEN_TRANSPORT both: browser получает токены только в cookie, native только в теле ответа",
+                        "name": "X-Client-Type",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "application/json возвращает сроки действия токенов и сессию и при выдаче токенов в cookie",
+                        "name": "Accept",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "токены и сроки их действия в теле ответа при TOKEN_TRANSPORT header или both, без токенов при выдаче в cookie",
+                        "schema": {
+                            "$ref": "#/definitions/dto.TokenResponse"
+                        },
+                        "headers": {
+                            "at": {
+                                "type": "Cookie",
+                                "description": "access token при TOKEN_TRANSPORT cookie или both, __Host-at при COOKIE_HOST_PREFIX. Время жизни токена 60 секунд, Cookie по умолчанию 30 дней."
+                            },
+                            "rt": {
+                                "type": "Cookie",
+                                "description": "refresh token при TOKEN_TRANSPORT cookie или both, __Secure-rt при COOKIE_HOST_PREFIX. Передаётся только в /api/refresh, время жизни Cookie по умолчанию 30 дней."
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/authorize": {
+            "get": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Запрос кода авторизации (RFC 6749 4.1) с обязательным PKCE S256 (RFC 7636) от имени вошедшего пользователя.\nДоверенный клиент сразу перенаправляется на redirect_uri с кодом, для остальных клиентов без согласия пользователя\nвозвращается 200 с описанием запроса, согласие отправляется на POST /api/oauth/authorize",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Authorization request",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "client id",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "зарегистрированный redirect uri",
+                        "name": "redirect_uri",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "scope",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "state",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "BASE64URL(SHA256(code_verifier))",
+                        "name": "code_challenge",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256",
+                        "name": "code_challenge_method",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.Consent"
+                        }
+                    },
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Согласие пользователя на запрос авторизации. Передаются те же параметры, что и в GET /api/oauth/authorize,\nи consent=approve или consent=deny. Пользователь перенаправляется на redirect_uri с кодом или с ошибкой access_denied.\nЕсли access токен передан в cookie, форма передаёт csrf_token из ответа GET /api/oauth/authorize",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Authorization consent",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "code",
+                        "name": "response_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "client id",
+                        "name": "client_id",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "зарегистрированный redirect uri",
+                        "name": "redirect_uri",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "scope",
+                        "name": "scope",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "state",
+                        "name": "state",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "BASE64URL(SHA256(code_verifier))",
+                        "name": "code_challenge",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256",
+                        "name": "code_challenge_method",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "approve или deny",
+                        "name": "consent",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "токен из ответа GET /api/oauth/authorize, обязателен при access токене в cookie",
+                        "name": "csrf_token",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/device": {
+            "get": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Страница подтверждения устройства: описание ожидающего запроса по user_code для вошедшего пользователя",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Device verification",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "код с экрана устройства",
+                        "name": "user_code",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.DeviceRequest"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Подтверждение (consent=approve) или отклонение (consent=deny) запроса устройства вошедшим пользователем.\nЕсли access токен передан в cookie, форма передаёт csrf_token из ответа GET /api/oauth/device",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Device approval",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "код с экрана устройства",
+                        "name": "user_code",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "approve или deny",
+                        "name": "consent",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "токен из ответа GET /api/oauth/device, обязателен при access токене в cookie",
+                        "name": "csrf_token",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/device_authorization": {
+            "post": {
+                "security": [
+                    {
+                        "ClientBasic": []
+                    }
+                ],
+                "description": "Начало авторизации устройства без браузера (RFC 8628). Устройство показывает пользователю user_code и адрес подтверждения,\nзатем опрашивает /api/oauth/token с grant_type=urn:ietf:params:oauth:grant-type:device_code не чаще interval секунд",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Device authorization request",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "scope",
+                        "name": "scope",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.DeviceAuthorization"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/introspect": {
+            "post": {
+                "security": [
+                    {
+                        "ClientBasic": []
+                    }
+                ],
+                "description": "Проверка access или refresh токена по RFC 7662. Доступно клиентам с разрешением на интроспекцию.\nТокен активен, если он корректен, пользователь не заблокирован и сессия не завершена",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Token introspection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "access или refresh токен",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "access_token или refresh_token",
+                        "name": "token_type_hint",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.Introspection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/revoke": {
+            "post": {
+                "security": [
+                    {
+                        "ClientBasic": []
+                    }
+                ],
+                "description": "Отзыв access или refresh токена по RFC 7009: сессия, к которой относится токен, завершается.\nПубличные клиенты передают только client_id. Для неизвестного или уже отозванного токена также возвращается 200",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Token revocation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "access или refresh токен",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "access_token или refresh_token",
+                        "name": "token_type_hint",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/token": {
+            "post": {
+                "security": [
+                    {
+                        "ClientBasic": []
+                    }
+                ],
+                "description": "Обмен кода авторизации на токены (grant_type=authorization_code, требуется code_verifier),\nобновление токенов по refresh токену (grant_type=refresh_token) и выпуск токена клиента от его имени\n(grant_type=client_credentials, только конфиденциальные клиенты), а также опрос устройством после подтверждения\nпользователем (grant_type=urn:ietf:params:oauth:grant-type:device_code). Публичные клиенты передают только client_id.\nСервис, действующий от имени пользователя, обменивает его access токен на токен для другого сервиса\n(grant_type=urn:ietf:params:oauth:grant-type:token-exchange, RFC 8693)",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Token endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorization_code, refresh_token, client_credentials, urn:ietf:params:oauth:grant-type:device_code или urn:ietf:params:oauth:grant-type:token-exchange",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "код авторизации",
+                        "name": "code",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "redirect uri из запроса авторизации",
+                        "name": "redirect_uri",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE code verifier",
+                        "name": "code_verifier",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "refresh токен",
+                        "name": "refresh_token",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "scope токена клиента, токена, полученного обменом, или суженный scope при обновлении",
+                        "name": "scope",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "device code",
+                        "name": "device_code",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "access токен пользователя для обмена",
+                        "name": "subject_token",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "urn:ietf:params:oauth:token-type:access_token",
+                        "name": "subject_token_type",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "сервис, для которого выпускается токен",
+                        "name": "audience",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "DPoP proof (RFC 9449), привязывает выданные токены к ключу. Не применяется к обмену токенов",
+                        "name": "DPoP",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/dto.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/oauth/userinfo": {
+            "get": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Профиль владельца access токена. Токен передаётся в заголовке Authorization: Bearer или DPoP или в cookie at.\nДля токена, привязанного к ключу DPoP, нужен DPoP proof с ath\nТребуется scope openid, name и roles возвращаются со scope profile, email со scope email",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "OpenID Connect userinfo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "DPoP proof для токена, привязанного к ключу",
+                        "name": "DPoP",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.UserInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized"
+                    },
+                    "403": {
+                        "description": "Forbidden"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "Bearer": []
+                    }
+                ],
+                "description": "Профиль владельца access токена. Токен передаётся в заголовке Authorization: Bearer или DPoP или в cookie at.\nДля токена, привязанного к ключу DPoP, нужен DPoP proof с ath\nТребуется scope openid, name и roles возвращаются со scope profile, email со scope email",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "OpenID Connect userinfo",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "DPoP proof для токена, привязанного к ключу",
+                        "name": "DPoP",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.UserInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized"
+                    },
+                    "403": {
+                        "description": "Forbidden"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/api/refresh": {
+            "post": {
+                "security": [
+                    {
+                        "Bearer \u0026\u0026 RefreshToken": []
+                    }
+                ],
+                "description": "Генерация новых access и refresh токенов на основе guid в access токене. Scope можно только сузить.\nТокены принимаются из cookie at и rt или, при TOKEN_TRANSPORT header или both, из заголовка Authorization и заголовка rt или тела запроса.\nНовые токены выдаются тем же способом, которым переданы токены запроса",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Refresh tokens",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "scope новых токенов, должен входить в scope сессии",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "DPoP proof, обязателен для сессии, привязанной к ключу DPoP",
+                        "name": "DPoP",
+                        "in": "header"
+                    },
+                    {
+                        "description": "refresh токен, если он не передан в заголовке rt",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/dto.RefreshRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "application/json возвращает сроки действия токенов и сессию и при выдаче токенов в cookie",
+                        "name": "Accept",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "токены и сроки их действия в теле ответа, если токены запроса переданы в заголовках, без токенов при выдаче в cookie",
+                        "schema": {
+                            "$ref": "#/definitions/dto.TokenResponse"
+                        },
+                        "headers": {
+                            "at": {
+                                "type": "Cookie",
+                                "description": "access token, если токены запроса переданы в cookie. Время жизни токена 60 секунд, Cookie по умолчанию 30 дней."
+                            },
+                            "rt": {
+                                "type": "Cookie",
+                                "description": "refresh token, если токены запроса переданы в cookie. Передаётся только в /api/refresh, время жизни Cookie по умолчанию 30 дней."
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "dto.Actor": {
+            "type": "object",
+            "properties": {
+                "sub": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.Client": {
+            "type": "object",
+            "properties": {
+                "client_credentials": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "client_id": {
+                    "type": "string",
+                    "example": "lW1c0bZ3kqI7Vf9T"
+                },
+                "client_secret": {
+                    "type": "string"
+                },
+                "confidential": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "exchange_audiences": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "payments-api"
+                    ]
+                },
+                "first_party": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Orders web app"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://orders.example.com/callback"
+                    ]
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "orders:read"
+                    ]
+                }
+            }
+        },
+        "dto.Confirmation": {
+            "type": "object",
+            "properties": {
+                "jkt": {
+                    "type": "string",
+                    "example": "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"
+                }
+            }
+        },
+        "dto.Consent": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string",
+                    "example": "lW1c0bZ3kqI7Vf9T"
+                },
+                "client_name": {
+                    "type": "string",
+                    "example": "Orders web app"
+                },
+                "csrf_token": {
+                    "type": "string",
+                    "example": "4Qz0bX8m7w1nD2Jk9sLrT5vYcE3hA6uF0pGiKoM"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "profile"
+                }
+            }
+        },
+        "dto.DeviceAuthorization": {
+            "type": "object",
+            "properties": {
+                "device_code": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 600
+                },
+                "interval": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "user_code": {
+                    "type": "string",
+                    "example": "BCDF-GHJK"
+                },
+                "verification_uri": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/device"
+                },
+                "verification_uri_complete": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/device?user_code=BCDF-GHJK"
+                }
+            }
+        },
+        "dto.DeviceRequest": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string",
+                    "example": "cli"
+                },
+                "client_name": {
+                    "type": "string",
+                    "example": "Internal CLI"
+                },
+                "csrf_token": {
+                    "type": "string",
+                    "example": "4Qz0bX8m7w1nD2Jk9sLrT5vYcE3hA6uF0pGiKoM"
+                },
+                "scope": {
+                    "type": "string",
+                    "example": "openid"
+                },
+                "user_code": {
+                    "type": "string",
+                    "example": "BCDF-GHJK"
+                }
+            }
+        },
+        "dto.GUID": {
+            "type": "object",
+            "properties": {
+                "guid": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                }
+            }
+        },
+        "dto.ImpersonateRequest": {
+            "type": "object",
+            "required": [
+                "guid",
+                "reason"
+            ],
+            "properties": {
+                "guid": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "ticket #123: user can't see orders"
+                }
+            }
+        },
+        "dto.ImpersonationToken": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 300
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "Bearer"
+                }
+            }
+        },
+        "dto.Introspection": {
+            "type": "object",
+            "properties": {
+                "act": {
+                    "$ref": "#/definitions/dto.Actor"
+                },
+                "active": {
+                    "type": "boolean"
+                },
+                "aud": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "cnf": {
+                    "$ref": "#/definitions/dto.Confirmation"
+                },
+                "exp": {
+                    "type": "integer"
+                },
+                "iat": {
+                    "type": "integer"
+                },
+                "iss": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "sid": {
+                    "type": "string"
+                },
+                "sub": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "access_token"
+                }
+            }
+        },
+        "dto.JWKS": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/keys.JWK"
+                    }
+                }
+            }
+        },
+        "dto.OAuthError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "invalid_request"
+                },
+                "error_description": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.OpenIDConfiguration": {
+            "type": "object",
+            "properties": {
+                "authorization_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/authorize"
+                },
+                "claims_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "sub"
+                    ]
+                },
+                "code_challenge_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "S256"
+                    ]
+                },
+                "device_authorization_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/device_authorization"
+                },
+                "dpop_signing_alg_values_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "ES256"
+                    ]
+                },
+                "grant_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "authorization_code"
+                    ]
+                },
+                "id_token_signing_alg_values_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "RS256"
+                    ]
+                },
+                "introspection_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/introspect"
+                },
+                "issuer": {
+                    "type": "string",
+                    "example": "http://localhost:8080"
+                },
+                "jwks_uri": {
+                    "type": "string",
+                    "example": "http://localhost:8080/.well-known/jwks.json"
+                },
+                "response_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "code"
+                    ]
+                },
+                "revocation_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/revoke"
+                },
+                "scopes_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "openid"
+                    ]
+                },
+                "subject_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "public"
+                    ]
+                },
+                "token_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/token"
+                },
+                "token_endpoint_auth_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "client_secret_basic"
+                    ]
+                },
+                "userinfo_endpoint": {
+                    "type": "string",
+                    "example": "http://localhost:8080/api/oauth/userinfo"
+                }
+            }
+        },
+        "dto.Profile": {
+            "type": "object",
+            "properties": {
+                "display_name": {
+                    "type": "string",
+                    "example": "Ivan Ivanov"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "user@example.com"
+                },
+                "guid": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                },
+                "mfa_enabled": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user"
+                    ]
+                },
+                "session": {
+                    "$ref": "#/definitions/dto.Session"
+                }
+            }
+        },
+        "dto.RefreshRequest": {
+            "type": "object",
+            "properties": {
+                "refresh_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.RegisterClient": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "client_credentials": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "confidential": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "exchange_audiences": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "payments-api"
+                    ]
+                },
+                "first_party": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "Orders web app"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://orders.example.com/callback"
+                    ]
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "orders:read"
+                    ]
+                }
+            }
+        },
+        "dto.RevokeUserRequest": {
+            "type": "object",
+            "required": [
+                "guid"
+            ],
+            "properties": {
+                "guid": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                }
+            }
+        },
+        "dto.Session": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "type": "string",
+                    "example": "127.0.0.1"
+                },
+                "refreshed_at": {
+                    "type": "string",
+                    "example": "2025-01-01T00:00:00Z"
+                },
+                "user_agent": {
+                    "type": "string",
+                    "example": "Mozilla/5.0"
+                }
+            }
+        },
+        "dto.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer",
+                    "example": 60
+                },
+                "id_token": {
+                    "type": "string"
+                },
+                "issued_token_type": {
+                    "type": "string",
+                    "example": "urn:ietf:params:oauth:token-type:access_token"
+                },
+                "refresh_expires_in": {
+                    "type": "integer",
+                    "example": 2592000
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "session_id": {
+                    "type": "string",
+                    "example": "5b0c9a3e-6f1d-4c2a-9a57-0c1f2e3d4b5a"
+                },
+                "token_type": {
+                    "type": "string",
+                    "enum": [
+                        "Bearer",
+                        "DPoP"
+                    ],
+                    "example": "Bearer"
+                }
+            }
+        },
+        "dto.UpdateProfile": {
+            "type": "object",
+            "properties": {
+                "display_name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "Ivan Ivanov"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "user@example.com"
+                }
+            }
+        },
+        "dto.UserInfo": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "user@example.com"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Ivan Ivanov"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "user"
+                    ]
+                },
+                "sub": {
+                    "type": "string",
+                    "example": "090bb747-d6d3-4067-a1da-2b83726eb24d"
+                }
+            }
+        },
+        "keys.JWK": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "type": "string"
+                },
+                "crv": {
+                    "type": "string"
+                },
+                "e": {
+                    "type": "string"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                },
+                "x": {
+                    "type": "string"
+                },
+                "y": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "Bearer": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        },
+        "ClientBasic": {
+            "type": "basic"
         },
         "RefreshToken": {
             "type": "apiKey",
@@ -7,3 +7,30 @@ var ErrUnauthorized = errors.New("unauthorized user")
 var ErrGUIDRequired = errors.New("guid required")
 var ErrTypecastJWT = errors.New("failed to typecast jwt claims")
 var ErrIncorrectRefreshToken = errors.New("invalid refresh token format")
+var ErrUserDisabled = errors.New("user account is disabled")
+var ErrUserSuspended = errors.New("user account is suspended")
+var ErrIncorrectProfile = errors.New("invalid profile attributes")
+var ErrTenantNotFound = errors.New("tenant not found")
+var ErrTenantMismatch = errors.New("token was issued for another tenant")
+var ErrTenantKeyUnsupported = errors.New("tenant signing key requires HS512")
+var ErrForbidden = errors.New("insufficient permissions")
+var ErrReasonRequired = errors.New("reason required")
+var ErrUnknownKey = errors.New("unknown signing key")
+var ErrInvalidClient = errors.New("invalid client")
+var ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+var ErrInvalidRequest = errors.New("invalid request")
+var ErrConsentRequired = errors.New("consent required")
+var ErrAccessDenied = errors.New("access denied")
+var ErrInvalidGrant = errors.New("invalid grant")
+var ErrUnsupportedResponseType = errors.New("unsupported response type")
+var ErrUnauthorizedClient = errors.New("grant type isn't allowed for client")
+var ErrInvalidScope = errors.New("invalid scope")
+var ErrAuthorizationPending = errors.New("authorization pending")
+var ErrSlowDown = errors.New("polling too frequently")
+var ErrExpiredToken = errors.New("device code expired")
+var ErrInvalidUserCode = errors.New("invalid user code")
+var ErrInvalidTarget = errors.New("invalid target audience")
+var ErrInsufficientScope = errors.New("insufficient scope")
+var ErrInvalidDPoPProof = errors.New("invalid dpop proof")
+var ErrUseDPoPNonce = errors.New("dpop nonce required")
+var ErrInvalidCSRFToken = errors.New("invalid csrf token")
@@ -1,11 +1,27 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/joho/godotenv"
+	"github.com/sater-151/AuthSystem/internal/pkg/secrets"
 	"github.com/sirupsen/logrus"
 )
 
+// bcryptSalt формат BCRYPYHASH: параметры bcrypt и соль из 22 символов
+var bcryptSalt = regexp.MustCompile(`^\$2[abxy]?\$\d{2}\$([./A-Za-z0-9]{22})$`)
+
+// bcryptSaltMinEntropy минимальная оценка энтропии соли bcrypt. Оценка строки из 22 символов
+// не превышает 98 бит, поэтому общий минимум SECRET_MIN_ENTROPY к соли не применяется
+const bcryptSaltMinEntropy = 64
+
 type ServerConfig struct {
 	Port string
 }
@@ -17,6 +33,126 @@ type PostgresqlConfig struct {
 	Sslmode string
 	Port    string
 	Host    string
+	// BcryptHash соль bcrypt для refresh токенов первой версии
+	BcryptHash string
+}
+
+// SecretConfig источник секретов и минимальная энтропия секретов. Через него читаются JWT_SECRET, BCRYPYHASH,
+// ключи PASETO_LOCAL_KEY, JWE_KEYS и DPOP_NONCE_KEY и параметры доступа к PKCS#11
+type SecretConfig struct {
+	Provider       string
+	Dir            string
+	VaultAddr      string
+	VaultToken     string
+	VaultNamespace string
+	VaultMount     string
+	VaultPath      string
+	MinEntropy     float64
+}
+
+type TokenConfig struct {
+	Secret         []byte
+	Algorithm      string
+	PrivateKeyFile string
+	// PKCS11Module библиотека PKCS#11, при заданном модуле токены подписываются ключом на токене
+	PKCS11Module     string
+	PKCS11TokenLabel string
+	PKCS11KeyLabel   string
+	PKCS11PIN        string
+	AccessTTL        time.Duration
+	RefreshTTL       time.Duration
+	ImpersonationTTL time.Duration
+	ClientTTL        time.Duration
+	DeviceCodeTTL    time.Duration
+	Issuer           string
+	Audience         string
+	Leeway           time.Duration
+	DenylistSync     time.Duration
+	// DefaultScope scope токенов, выданных при входе без запроса scope, и граница scope при входе
+	DefaultScope string
+	// Format формат access токенов: jwt, jwe, v4.local или v4.public (PASETO)
+	Format              string
+	PasetoLocalKey      string
+	PasetoPublicKeyFile string
+	// EncryptionKeys ключи шифрования JWE: kid:hex через запятую, первым ключом шифруются новые токены
+	EncryptionKeys string
+	// DPoPNonceTTL время действия nonce DPoP, ноль отключает nonce
+	DPoPNonceTTL time.Duration
+	DPoPNonceKey []byte
+	// AllowTemporaryKeys разрешает создавать при запуске временные ключи вместо не заданных, только для разработки
+	AllowTemporaryKeys bool
+}
+
+const (
+	TransportCookie = "cookie"
+	TransportHeader = "header"
+	TransportBoth   = "both"
+)
+
+// TransportConfig способ передачи токенов между клиентом и сервисом (TOKEN_TRANSPORT):
+// cookie, header (Authorization и тело ответа) или both, и политика cookie
+type TransportConfig struct {
+	Mode    string
+	Cookies CookieConfig
+}
+
+// CookieConfig политика cookie с токенами. Пустой Domain выдаёт cookie только хосту запроса, пустой RefreshPath
+// ограничивает cookie refresh токена эндпоинтом /refresh группы запроса (/api/refresh или /api/t/<tenant>/refresh).
+// Нулевое время жизни cookie равно времени жизни refresh токена тенанта
+type CookieConfig struct {
+	Domain        string
+	AccessPath    string
+	RefreshPath   string
+	Secure        bool
+	SameSite      http.SameSite
+	HostPrefix    bool
+	AccessMaxAge  time.Duration
+	RefreshMaxAge time.Duration
+}
+
+// AccessName имя cookie access токена, с префиксом __Host- браузер принимает cookie только от своего хоста по HTTPS
+func (cookieConfig CookieConfig) AccessName() string {
+	if cookieConfig.HostPrefix {
+		return "__Host-at"
+	}
+	return "at"
+}
+
+// RefreshName имя cookie refresh токена. Префикс __Host- требует путь /, поэтому для cookie,
+// ограниченной эндпоинтом обновления, используется __Secure-
+func (cookieConfig CookieConfig) RefreshName() string {
+	if !cookieConfig.HostPrefix {
+		return "rt"
+	}
+	if cookieConfig.RefreshPath == "/" {
+		return "__Host-rt"
+	}
+	return "__Secure-rt"
+}
+
+// Cookie сообщает, что токены принимаются и выдаются в cookie
+func (transportConfig TransportConfig) Cookie() bool {
+	return transportConfig.Mode != TransportHeader
+}
+
+// Header сообщает, что токены принимаются в заголовках и выдаются в теле ответа
+func (transportConfig TransportConfig) Header() bool {
+	return transportConfig.Mode == TransportHeader || transportConfig.Mode == TransportBoth
+}
+
+type KeyConfig struct {
+	Store     string
+	StoreDir  string
+	Rotation  time.Duration
+	Retention time.Duration
+}
+
+// LoadEnv загружает .env, если он есть. Файл нужен только для локального запуска, в контейнере переменные задаются окружением
+func LoadEnv() error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 func GetServerConfig() ServerConfig {
@@ -29,7 +165,7 @@ func GetServerConfig() ServerConfig {
 	return serverConfig
 }
 
-func GetPostresqlConfig() PostgresqlConfig {
+func GetPostresqlConfig(secretConfig SecretConfig) (PostgresqlConfig, error) {
 	var psqlConfig PostgresqlConfig
 	var ok bool
 	psqlConfig.User, ok = os.LookupEnv("POSTGRES_USER")
@@ -56,7 +192,376 @@ func GetPostresqlConfig() PostgresqlConfig {
 	if !ok {
 		logrus.Warn("postgres host is empty")
 	}
-	return psqlConfig
+	hash, err := secretConfig.secret("BCRYPYHASH")
+	if err != nil {
+		return psqlConfig, err
+	}
+	salt := bcryptSalt.FindStringSubmatch(hash)
+	if salt == nil {
+		return psqlConfig, errors.New("bcrypt hash is incorrect")
+	}
+	if err = secrets.CheckEntropy("BCRYPYHASH", salt[1], bcryptSaltMinEntropy); err != nil {
+		return psqlConfig, err
+	}
+	psqlConfig.BcryptHash = hash
+	return psqlConfig, nil
+}
+
+func GetSecretConfig() SecretConfig {
+	var secretConfig SecretConfig
+	var ok bool
+	secretConfig.Provider, ok = os.LookupEnv("SECRET_PROVIDER")
+	if !ok || secretConfig.Provider == "" {
+		secretConfig.Provider = secrets.ProviderEnv
+	}
+	secretConfig.Dir, ok = os.LookupEnv("SECRETS_DIR")
+	if !ok || secretConfig.Dir == "" {
+		secretConfig.Dir = "/run/secrets"
+	}
+	secretConfig.VaultAddr = os.Getenv("VAULT_ADDR")
+	// токен Vault сам является секретом, поэтому допускается VAULT_TOKEN_FILE
+	secretConfig.VaultToken, _ = secrets.Env{}.Secret("VAULT_TOKEN")
+	secretConfig.VaultNamespace = os.Getenv("VAULT_NAMESPACE")
+	secretConfig.VaultMount, ok = os.LookupEnv("VAULT_MOUNT")
+	if !ok || secretConfig.VaultMount == "" {
+		secretConfig.VaultMount = "secret"
+	}
+	secretConfig.VaultPath, ok = os.LookupEnv("VAULT_SECRET_PATH")
+	if !ok || secretConfig.VaultPath == "" {
+		secretConfig.VaultPath = "authsystem"
+	}
+	minEntropy, ok := os.LookupEnv("SECRET_MIN_ENTROPY")
+	if !ok {
+		minEntropy = "128"
+	}
+	minEntropyBits, err := strconv.Atoi(minEntropy)
+	if err != nil || minEntropyBits < 0 {
+		logrus.Warn("secret min entropy is incorrect")
+		minEntropyBits = 128
+	}
+	secretConfig.MinEntropy = float64(minEntropyBits)
+	return secretConfig
+}
+
+// SecretProvider возвращает источник секретов, заданный SECRET_PROVIDER: env (по умолчанию), file или vault
+func (secretConfig SecretConfig) SecretProvider() (secrets.Provider, error) {
+	switch secretConfig.Provider {
+	case secrets.ProviderEnv:
+		return secrets.Env{}, nil
+	case secrets.ProviderFile:
+		return secrets.Files{Dir: secretConfig.Dir}, nil
+	case secrets.ProviderVault:
+		if secretConfig.VaultAddr == "" {
+			return nil, errors.New("vault address is empty")
+		}
+		return secrets.Vault{
+			Addr:      secretConfig.VaultAddr,
+			Token:     secretConfig.VaultToken,
+			Namespace: secretConfig.VaultNamespace,
+			Mount:     secretConfig.VaultMount,
+			Path:      secretConfig.VaultPath,
+			Client:    &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+	return nil, secrets.ErrUnsupportedProvider
+}
+
+func (secretConfig SecretConfig) secret(name string) (string, error) {
+	provider, err := secretConfig.SecretProvider()
+	if err != nil {
+		return "", err
+	}
+	value, err := provider.Secret(name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return value, nil
+}
+
+// optionalSecret возвращает секрет или пустую строку, если он не задан. Заданный секрет проверяется
+// на энтропию не меньше minBits, при нулевом minBits проверка не выполняется (PIN, метки ключей)
+func (secretConfig SecretConfig) optionalSecret(name string, minBits float64) (string, error) {
+	value, err := secretConfig.secret(name)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil || value == "" || minBits == 0 {
+		return value, err
+	}
+	if err = secrets.CheckEntropy(name, value, minBits); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// GetTokenConfig возвращает параметры токенов по умолчанию, которые используются для тенантов без собственных настроек
+func GetTokenConfig(secretConfig SecretConfig) (TokenConfig, error) {
+	var tokenConfig TokenConfig
+	var ok bool
+	tokenConfig.Algorithm, ok = os.LookupEnv("JWT_ALG")
+	if !ok {
+		tokenConfig.Algorithm = "HS512"
+	}
+	// общий секрет нужен только для HS512, с асимметричным алгоритмом токены подписываются ключом из файла
+	if tokenConfig.Algorithm == "HS512" {
+		secret, err := secretConfig.secret("JWT_SECRET")
+		if err != nil {
+			return tokenConfig, err
+		}
+		if err = secrets.CheckEntropy("JWT_SECRET", secret, secretConfig.MinEntropy); err != nil {
+			return tokenConfig, err
+		}
+		tokenConfig.Secret = []byte(secret)
+	}
+	tokenConfig.PrivateKeyFile = os.Getenv("JWT_PRIVATE_KEY_FILE")
+	if allowTemporary := os.Getenv("ALLOW_TEMPORARY_KEYS"); allowTemporary != "" {
+		var err error
+		tokenConfig.AllowTemporaryKeys, err = strconv.ParseBool(allowTemporary)
+		if err != nil {
+			logrus.Warn("allow temporary keys flag is incorrect")
+		}
+	}
+	tokenConfig.PKCS11Module = os.Getenv("JWT_PKCS11_MODULE")
+	if tokenConfig.PKCS11Module != "" {
+		// метки не секретны, но читаются из того же источника, что и PIN. PIN ограничен токеном, его энтропия не проверяется
+		var err error
+		if tokenConfig.PKCS11TokenLabel, err = secretConfig.optionalSecret("JWT_PKCS11_TOKEN_LABEL", 0); err != nil {
+			return tokenConfig, err
+		}
+		if tokenConfig.PKCS11KeyLabel, err = secretConfig.optionalSecret("JWT_PKCS11_KEY_LABEL", 0); err != nil {
+			return tokenConfig, err
+		}
+		if tokenConfig.PKCS11PIN, err = secretConfig.secret("JWT_PKCS11_PIN"); err != nil {
+			return tokenConfig, err
+		}
+	}
+	// без времени жизни токены выпускались бы уже истёкшими, поэтому сервис не запускается
+	var err error
+	if tokenConfig.AccessTTL, err = lifetime("ATEXPIRES"); err != nil {
+		return tokenConfig, err
+	}
+	if tokenConfig.RefreshTTL, err = lifetime("COOKIEEXPIRES"); err != nil {
+		return tokenConfig, err
+	}
+	impExpires, ok := os.LookupEnv("IMPERSONATIONEXPIRES")
+	if !ok {
+		impExpires = "300"
+	}
+	impTimeExp, err := strconv.Atoi(impExpires)
+	if err != nil {
+		logrus.Warn("impersonation token lifetime is incorrect")
+	}
+	tokenConfig.ImpersonationTTL = time.Second * time.Duration(impTimeExp)
+	clientExpires, ok := os.LookupEnv("CLIENTTOKENEXPIRES")
+	if !ok {
+		clientExpires = "300"
+	}
+	clientTimeExp, err := strconv.Atoi(clientExpires)
+	if err != nil {
+		logrus.Warn("client token lifetime is incorrect")
+	}
+	tokenConfig.ClientTTL = time.Second * time.Duration(clientTimeExp)
+	deviceExpires, ok := os.LookupEnv("DEVICECODEEXPIRES")
+	if !ok {
+		deviceExpires = "600"
+	}
+	deviceTimeExp, err := strconv.Atoi(deviceExpires)
+	if err != nil {
+		logrus.Warn("device code lifetime is incorrect")
+	}
+	tokenConfig.DeviceCodeTTL = time.Second * time.Duration(deviceTimeExp)
+	tokenConfig.Issuer = os.Getenv("JWT_ISSUER")
+	tokenConfig.Audience = os.Getenv("JWT_AUDIENCE")
+	leeway, ok := os.LookupEnv("JWT_LEEWAY")
+	if !ok {
+		leeway = "30"
+	}
+	leewaySec, err := strconv.Atoi(leeway)
+	if err != nil {
+		logrus.Warn("jwt leeway is incorrect")
+	}
+	tokenConfig.Leeway = time.Second * time.Duration(leewaySec)
+	denylistSync, ok := os.LookupEnv("DENYLIST_SYNC_INTERVAL")
+	if !ok {
+		denylistSync = "5"
+	}
+	denylistSyncSec, err := strconv.Atoi(denylistSync)
+	if err != nil || denylistSyncSec <= 0 {
+		logrus.Warn("denylist sync interval is incorrect")
+		denylistSyncSec = 5
+	}
+	tokenConfig.DenylistSync = time.Second * time.Duration(denylistSyncSec)
+	tokenConfig.DefaultScope, ok = os.LookupEnv("DEFAULT_SCOPE")
+	if !ok {
+		tokenConfig.DefaultScope = "openid profile email"
+	}
+	tokenConfig.Format, ok = os.LookupEnv("TOKEN_FORMAT")
+	if !ok || tokenConfig.Format == "" {
+		tokenConfig.Format = "jwt"
+	}
+	tokenConfig.PasetoLocalKey, err = secretConfig.optionalSecret("PASETO_LOCAL_KEY", secretConfig.MinEntropy)
+	if err != nil {
+		return tokenConfig, err
+	}
+	tokenConfig.PasetoPublicKeyFile = os.Getenv("PASETO_PUBLIC_KEY_FILE")
+	tokenConfig.EncryptionKeys, err = secretConfig.encryptionKeys()
+	if err != nil {
+		return tokenConfig, err
+	}
+	dpopNonce, ok := os.LookupEnv("DPOP_NONCE_TTL")
+	if !ok {
+		dpopNonce = "300"
+	}
+	dpopNonceSec, err := strconv.Atoi(dpopNonce)
+	if err != nil || dpopNonceSec < 0 {
+		logrus.Warn("dpop nonce lifetime is incorrect")
+		dpopNonceSec = 0
+	}
+	tokenConfig.DPoPNonceTTL = time.Second * time.Duration(dpopNonceSec)
+	dpopNonceKey, err := secretConfig.optionalSecret("DPOP_NONCE_KEY", secretConfig.MinEntropy)
+	if err != nil {
+		return tokenConfig, err
+	}
+	tokenConfig.DPoPNonceKey = []byte(dpopNonceKey)
+	return tokenConfig, nil
+}
+
+// encryptionKeys возвращает JWE_KEYS (kid:hex через запятую) и проверяет энтропию каждого ключа.
+// Формат ключей проверяется при загрузке
+// lifetime читает обязательное время жизни в секундах
+func lifetime(name string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return time.Second * time.Duration(seconds), nil
+}
+
+func (secretConfig SecretConfig) encryptionKeys() (string, error) {
+	encryptionKeys, err := secretConfig.optionalSecret("JWE_KEYS", 0)
+	if err != nil || strings.TrimSpace(encryptionKeys) == "" {
+		return encryptionKeys, err
+	}
+	for _, item := range strings.Split(encryptionKeys, ",") {
+		id, key, _ := strings.Cut(strings.TrimSpace(item), ":")
+		if err = secrets.CheckEntropy("JWE_KEYS "+id, key, secretConfig.MinEntropy); err != nil {
+			return "", err
+		}
+	}
+	return encryptionKeys, nil
+}
+
+// GetKeyConfig возвращает настройки хранения и ротации ключей подписи.
+// KEY_STORE: пусто (ключ только из конфигурации), db или file
+func GetKeyConfig(tokenConfig TokenConfig) KeyConfig {
+	var keyConfig KeyConfig
+	keyConfig.Store = os.Getenv("KEY_STORE")
+	keyConfig.StoreDir = os.Getenv("KEY_STORE_DIR")
+	if keyConfig.Store == "file" && keyConfig.StoreDir == "" {
+		logrus.Warn("key store dir is empty")
+	}
+	var err error
+	if rotation, ok := os.LookupEnv("KEY_ROTATION_INTERVAL"); ok && rotation != "" {
+		keyConfig.Rotation, err = time.ParseDuration(rotation)
+		if err != nil {
+			logrus.Warn("key rotation interval is incorrect")
+		}
+	}
+	// истёкший access токен проверяется при обновлении, поэтому ключ хранится не меньше времени жизни refresh токена
+	keyConfig.Retention = tokenConfig.RefreshTTL + tokenConfig.AccessTTL
+	if retention, ok := os.LookupEnv("KEY_RETENTION"); ok && retention != "" {
+		keyConfig.Retention, err = time.ParseDuration(retention)
+		if err != nil {
+			logrus.Warn("key retention is incorrect")
+		}
+	}
+	return keyConfig
+}
+
+func GetTransportConfig() (TransportConfig, error) {
+	var transportConfig TransportConfig
+	var ok bool
+	transportConfig.Mode, ok = os.LookupEnv("TOKEN_TRANSPORT")
+	if !ok || transportConfig.Mode == "" {
+		transportConfig.Mode = TransportCookie
+	}
+	switch transportConfig.Mode {
+	case TransportCookie, TransportHeader, TransportBoth:
+	default:
+		logrus.Warn("token transport is incorrect")
+		transportConfig.Mode = TransportCookie
+	}
+	var err error
+	transportConfig.Cookies, err = getCookieConfig()
+	return transportConfig, err
+}
+
+// getCookieConfig читает политику cookie: COOKIE_DOMAIN, COOKIE_ACCESS_PATH, COOKIE_REFRESH_PATH, COOKIE_SECURE,
+// COOKIE_SAMESITE (lax, strict или none), COOKIE_HOST_PREFIX и время жизни cookie в секундах
+// COOKIE_ACCESS_MAX_AGE и COOKIE_REFRESH_MAX_AGE
+func getCookieConfig() (CookieConfig, error) {
+	var cookieConfig CookieConfig
+	var ok bool
+	cookieConfig.Domain = os.Getenv("COOKIE_DOMAIN")
+	cookieConfig.AccessPath, ok = os.LookupEnv("COOKIE_ACCESS_PATH")
+	if !ok || cookieConfig.AccessPath == "" {
+		cookieConfig.AccessPath = "/"
+	}
+	cookieConfig.RefreshPath = os.Getenv("COOKIE_REFRESH_PATH")
+	secure, ok := os.LookupEnv("COOKIE_SECURE")
+	if !ok || secure == "" {
+		secure = "true"
+	}
+	var err error
+	cookieConfig.Secure, err = strconv.ParseBool(secure)
+	if err != nil {
+		logrus.Warn("cookie secure flag is incorrect")
+		cookieConfig.Secure = true
+	}
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "", "lax":
+		cookieConfig.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookieConfig.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookieConfig.SameSite = http.SameSiteNoneMode
+	default:
+		logrus.Warn("cookie samesite is incorrect")
+		cookieConfig.SameSite = http.SameSiteLaxMode
+	}
+	if hostPrefix := os.Getenv("COOKIE_HOST_PREFIX"); hostPrefix != "" {
+		cookieConfig.HostPrefix, err = strconv.ParseBool(hostPrefix)
+		if err != nil {
+			logrus.Warn("cookie host prefix flag is incorrect")
+		}
+	}
+	if accessMaxAge := os.Getenv("COOKIE_ACCESS_MAX_AGE"); accessMaxAge != "" {
+		sec, err := strconv.Atoi(accessMaxAge)
+		if err != nil || sec < 0 {
+			logrus.Warn("access cookie lifetime is incorrect")
+			sec = 0
+		}
+		cookieConfig.AccessMaxAge = time.Second * time.Duration(sec)
+	}
+	if refreshMaxAge := os.Getenv("COOKIE_REFRESH_MAX_AGE"); refreshMaxAge != "" {
+		sec, err := strconv.Atoi(refreshMaxAge)
+		if err != nil || sec < 0 {
+			logrus.Warn("refresh cookie lifetime is incorrect")
+			sec = 0
+		}
+		cookieConfig.RefreshMaxAge = time.Second * time.Duration(sec)
+	}
+	// браузеры отклоняют такие cookie, поэтому ошибка конфигурации обнаруживается при запуске
+	if cookieConfig.SameSite == http.SameSiteNoneMode && !cookieConfig.Secure {
+		return cookieConfig, errors.New("cookie with samesite none must be secure")
+	}
+	if cookieConfig.HostPrefix && (!cookieConfig.Secure || cookieConfig.Domain != "" || cookieConfig.AccessPath != "/") {
+		return cookieConfig, errors.New("__Host- cookie must be secure, without domain and with path /")
+	}
+	return cookieConfig, nil
 }
 
 func InitLoggerConfig() {
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/pkg/secrets"
+)
+
+const (
+	testSecret = "f3d1c0a5b7e94826a1c3e5f70b2d4968e0a1b3c5d7f92846b0c2e4f6a8d1c3e5"
+	weakSecret = "00000000000000000000000000000000"
+)
+
+// setTokenEnv задаёт окружение, с которым GetTokenConfig проходит без ошибок
+func setTokenEnv(t *testing.T) {
+	t.Helper()
+	for name, value := range map[string]string{
+		"JWT_ALG":              "HS512",
+		"JWT_SECRET":           testSecret,
+		"JWT_PKCS11_MODULE":    "",
+		"PASETO_LOCAL_KEY":     "",
+		"JWE_KEYS":             "",
+		"DPOP_NONCE_KEY":       "",
+		"ALLOW_TEMPORARY_KEYS": "",
+		"ATEXPIRES":            "60",
+		"COOKIEEXPIRES":        "3600",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestGetTokenConfigRejectsWeakKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"JWT_SECRET", weakSecret},
+		{"PASETO_LOCAL_KEY", weakSecret + weakSecret},
+		{"DPOP_NONCE_KEY", weakSecret},
+		{"JWE_KEYS", "k1:" + testSecret + ",k2:" + weakSecret + weakSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTokenEnv(t)
+			t.Setenv(tt.name, tt.value)
+			_, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderEnv, MinEntropy: 128})
+			if !errors.Is(err, secrets.ErrWeakSecret) {
+				t.Fatalf("err = %v, want %v", err, secrets.ErrWeakSecret)
+			}
+		})
+	}
+}
+
+func TestGetTokenConfigRequiresLifetimes(t *testing.T) {
+	for _, name := range []string{"ATEXPIRES", "COOKIEEXPIRES"} {
+		for _, value := range []string{"", "minute", "0"} {
+			t.Run(name+"="+value, func(t *testing.T) {
+				setTokenEnv(t)
+				t.Setenv(name, value)
+				if _, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderEnv, MinEntropy: 128}); err == nil {
+					t.Fatal("token config without lifetime is accepted")
+				}
+			})
+		}
+	}
+	setTokenEnv(t)
+	tokenConfig, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderEnv, MinEntropy: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenConfig.AccessTTL != time.Minute || tokenConfig.RefreshTTL != time.Hour {
+		t.Fatalf("lifetimes = %v, %v", tokenConfig.AccessTTL, tokenConfig.RefreshTTL)
+	}
+}
+
+func TestGetTokenConfigReadsKeysFromProvider(t *testing.T) {
+	setTokenEnv(t)
+	for _, name := range []string{"JWT_SECRET", "PASETO_LOCAL_KEY", "JWE_KEYS", "DPOP_NONCE_KEY"} {
+		os.Unsetenv(name)
+	}
+	dir := t.TempDir()
+	for name, value := range map[string]string{
+		"JWT_SECRET":       testSecret,
+		"PASETO_LOCAL_KEY": testSecret,
+		"JWE_KEYS":         "k1:" + testSecret,
+		"dpop_nonce_key":   testSecret + "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokenConfig, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderFile, Dir: dir, MinEntropy: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenConfig.PasetoLocalKey != testSecret || tokenConfig.EncryptionKeys != "k1:"+testSecret || string(tokenConfig.DPoPNonceKey) != testSecret {
+		t.Fatalf("keys aren't read from files: %+v", tokenConfig)
+	}
+}
+
+func TestGetTokenConfigPKCS11(t *testing.T) {
+	setTokenEnv(t)
+	t.Setenv("JWT_PKCS11_MODULE", "/usr/lib/softhsm/libsofthsm2.so")
+	t.Setenv("JWT_PKCS11_TOKEN_LABEL", "auth")
+	t.Setenv("JWT_PKCS11_KEY_LABEL", "jwt")
+	os.Unsetenv("JWT_PKCS11_PIN")
+	if _, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderEnv, MinEntropy: 128}); !errors.Is(err, secrets.ErrNotFound) {
+		t.Fatalf("without pin: err = %v, want %v", err, secrets.ErrNotFound)
+	}
+	// PIN задаётся токеном, короткий PIN не отклоняется
+	t.Setenv("JWT_PKCS11_PIN", "1234")
+	tokenConfig, err := GetTokenConfig(SecretConfig{Provider: secrets.ProviderEnv, MinEntropy: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenConfig.PKCS11TokenLabel != "auth" || tokenConfig.PKCS11KeyLabel != "jwt" || tokenConfig.PKCS11PIN != "1234" {
+		t.Fatalf("pkcs11 config = %q %q %q", tokenConfig.PKCS11TokenLabel, tokenConfig.PKCS11KeyLabel, tokenConfig.PKCS11PIN)
+	}
+}
+
+// setCookieEnv задаёт политику cookie, остальные переменные COOKIE_ пустые
+func setCookieEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range []string{"COOKIE_DOMAIN", "COOKIE_ACCESS_PATH", "COOKIE_REFRESH_PATH", "COOKIE_SECURE", "COOKIE_SAMESITE", "COOKIE_HOST_PREFIX", "COOKIE_ACCESS_MAX_AGE", "COOKIE_REFRESH_MAX_AGE"} {
+		t.Setenv(name, env[name])
+	}
+}
+
+func TestCookieConfigDefaults(t *testing.T) {
+	setCookieEnv(t, nil)
+	cookieConfig, err := getCookieConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookieConfig.AccessPath != "/" || cookieConfig.RefreshPath != "" || !cookieConfig.Secure || cookieConfig.SameSite != http.SameSiteLaxMode || cookieConfig.HostPrefix {
+		t.Fatalf("default cookie config = %+v", cookieConfig)
+	}
+	if cookieConfig.AccessName() != "at" || cookieConfig.RefreshName() != "rt" {
+		t.Fatalf("cookie names = %s, %s", cookieConfig.AccessName(), cookieConfig.RefreshName())
+	}
+}
+
+func TestCookieConfigHostPrefix(t *testing.T) {
+	setCookieEnv(t, map[string]string{"COOKIE_HOST_PREFIX": "true", "COOKIE_SAMESITE": "strict", "COOKIE_ACCESS_MAX_AGE": "60", "COOKIE_REFRESH_MAX_AGE": "3600"})
+	cookieConfig, err := getCookieConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookieConfig.SameSite != http.SameSiteStrictMode || cookieConfig.AccessMaxAge != time.Minute || cookieConfig.RefreshMaxAge != time.Hour {
+		t.Fatalf("cookie config = %+v", cookieConfig)
+	}
+	// cookie, ограниченная эндпоинтом обновления, не может иметь префикс __Host-
+	if cookieConfig.AccessName() != "__Host-at" || cookieConfig.RefreshName() != "__Secure-rt" {
+		t.Fatalf("cookie names = %s, %s", cookieConfig.AccessName(), cookieConfig.RefreshName())
+	}
+	cookieConfig.RefreshPath = "/"
+	if cookieConfig.RefreshName() != "__Host-rt" {
+		t.Fatalf("refresh cookie name with path / = %s", cookieConfig.RefreshName())
+	}
+}
+
+func TestCookieConfigRejectsInsecurePolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"samesite none without secure", map[string]string{"COOKIE_SAMESITE": "none", "COOKIE_SECURE": "false"}},
+		{"host prefix without secure", map[string]string{"COOKIE_HOST_PREFIX": "true", "COOKIE_SECURE": "false"}},
+		{"host prefix with domain", map[string]string{"COOKIE_HOST_PREFIX": "true", "COOKIE_DOMAIN": "example.com"}},
+		{"host prefix with path", map[string]string{"COOKIE_HOST_PREFIX": "true", "COOKIE_ACCESS_PATH": "/api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCookieEnv(t, tt.env)
+			if _, err := getCookieConfig(); err == nil {
+				t.Fatal("insecure cookie policy is accepted")
+			}
+		})
+	}
+}
@@ -1,5 +1,182 @@
 package dto
 
+import (
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+)
+
 type GUID struct {
 	Guid string `json:"guid" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
 }
+
+type Profile struct {
+	Guid        string   `json:"guid" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
+	Email       string   `json:"email" example:"user@example.com"`
+	DisplayName string   `json:"display_name" example:"Ivan Ivanov"`
+	Roles       []string `json:"roles" example:"user"`
+	MFAEnabled  bool     `json:"mfa_enabled" example:"false"`
+	Session     Session  `json:"session"`
+}
+
+type Session struct {
+	UserAgent   string    `json:"user_agent" example:"Mozilla/5.0"`
+	IP          string    `json:"ip" example:"127.0.0.1"`
+	RefreshedAt time.Time `json:"refreshed_at" example:"2025-01-01T00:00:00Z"`
+}
+
+type UpdateProfile struct {
+	Email       *string `json:"email" binding:"omitempty,email" example:"user@example.com"`
+	DisplayName *string `json:"display_name" binding:"omitempty,max=128" example:"Ivan Ivanov"`
+}
+
+type ImpersonateRequest struct {
+	Guid   string `json:"guid" binding:"required,uuid" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
+	Reason string `json:"reason" binding:"required" example:"ticket #123: user can't see orders"`
+}
+
+type RevokeUserRequest struct {
+	Guid string `json:"guid" binding:"required,uuid" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
+}
+
+type ImpersonationToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int    `json:"expires_in" example:"300"`
+}
+
+type JWKS struct {
+	Keys []keys.JWK `json:"keys"`
+}
+
+// Introspection ответ RFC 7662. Для неактивного токена заполняется только active
+type Introspection struct {
+	Active    bool          `json:"active"`
+	TokenType string        `json:"token_type,omitempty" example:"access_token"`
+	Sub       string        `json:"sub,omitempty" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
+	Tenant    string        `json:"tenant,omitempty" example:"default"`
+	Sid       string        `json:"sid,omitempty"`
+	Iss       string        `json:"iss,omitempty"`
+	Aud       []string      `json:"aud,omitempty"`
+	Act       *Actor        `json:"act,omitempty"`
+	Scope     string        `json:"scope,omitempty"`
+	ClientID  string        `json:"client_id,omitempty"`
+	Cnf       *Confirmation `json:"cnf,omitempty"`
+	Iat       int64         `json:"iat,omitempty"`
+	Exp       int64         `json:"exp,omitempty"`
+}
+
+type Actor struct {
+	Sub string `json:"sub"`
+}
+
+// Confirmation отпечаток ключа DPoP, к которому привязан токен (RFC 9449 6.2)
+type Confirmation struct {
+	JKT string `json:"jkt" example:"0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"`
+}
+
+// RegisterClient регистрация клиента. redirect_uris не обязательны только для клиента с client_credentials.
+// exchange_audiences сервисы, для которых конфиденциальный клиент может обменивать токены пользователей
+type RegisterClient struct {
+	Name              string   `json:"name" binding:"required,max=128" example:"Orders web app"`
+	RedirectURIs      []string `json:"redirect_uris" example:"https://orders.example.com/callback"`
+	Confidential      bool     `json:"confidential" example:"true"`
+	FirstParty        bool     `json:"first_party" example:"false"`
+	ClientCredentials bool     `json:"client_credentials" example:"false"`
+	Scopes            []string `json:"scopes" example:"orders:read"`
+	ExchangeAudiences []string `json:"exchange_audiences" example:"payments-api"`
+}
+
+// Client зарегистрированный OAuth клиент. client_secret возвращается только при регистрации
+type Client struct {
+	ClientID          string   `json:"client_id" example:"lW1c0bZ3kqI7Vf9T"`
+	ClientSecret      string   `json:"client_secret,omitempty"`
+	Name              string   `json:"name" example:"Orders web app"`
+	RedirectURIs      []string `json:"redirect_uris" example:"https://orders.example.com/callback"`
+	Confidential      bool     `json:"confidential" example:"true"`
+	FirstParty        bool     `json:"first_party" example:"false"`
+	ClientCredentials bool     `json:"client_credentials" example:"false"`
+	Scopes            []string `json:"scopes" example:"orders:read"`
+	ExchangeAudiences []string `json:"exchange_audiences" example:"payments-api"`
+}
+
+// Consent запрос согласия пользователя на доступ клиента
+type Consent struct {
+	ClientID   string `json:"client_id" example:"lW1c0bZ3kqI7Vf9T"`
+	ClientName string `json:"client_name" example:"Orders web app"`
+	Scope      string `json:"scope" example:"profile"`
+	CSRFToken  string `json:"csrf_token" example:"4Qz0bX8m7w1nD2Jk9sLrT5vYcE3hA6uF0pGiKoM"`
+}
+
+// RefreshRequest refresh токен в теле запроса обновления, если токены передаются в заголовках
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse ответ эндпоинта /oauth/token (RFC 6749 5.1), а также /login и /refresh.
+// При выдаче токенов в cookie access_token и refresh_token не заполняются, refresh_expires_in и session_id
+// заполняются для токенов пользователя, выпущенных с refresh токеном
+type TokenResponse struct {
+	AccessToken      string `json:"access_token,omitempty"`
+	TokenType        string `json:"token_type" example:"Bearer" enums:"Bearer,DPoP"`
+	ExpiresIn        int    `json:"expires_in" example:"60"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty" example:"2592000"`
+	SessionID        string `json:"session_id,omitempty" example:"5b0c9a3e-6f1d-4c2a-9a57-0c1f2e3d4b5a"`
+	IDToken          string `json:"id_token,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+	IssuedTokenType  string `json:"issued_token_type,omitempty" example:"urn:ietf:params:oauth:token-type:access_token"`
+}
+
+// DeviceAuthorization ответ на запрос авторизации устройства (RFC 8628 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code" example:"BCDF-GHJK"`
+	VerificationURI         string `json:"verification_uri" example:"http://localhost:8080/api/oauth/device"`
+	VerificationURIComplete string `json:"verification_uri_complete" example:"http://localhost:8080/api/oauth/device?user_code=BCDF-GHJK"`
+	ExpiresIn               int    `json:"expires_in" example:"600"`
+	Interval                int    `json:"interval" example:"5"`
+}
+
+// DeviceRequest запрос устройства, который пользователь подтверждает или отклоняет
+type DeviceRequest struct {
+	UserCode   string `json:"user_code" example:"BCDF-GHJK"`
+	ClientID   string `json:"client_id" example:"cli"`
+	ClientName string `json:"client_name" example:"Internal CLI"`
+	Scope      string `json:"scope" example:"openid"`
+	CSRFToken  string `json:"csrf_token" example:"4Qz0bX8m7w1nD2Jk9sLrT5vYcE3hA6uF0pGiKoM"`
+}
+
+// OpenIDConfiguration метаданные провайдера OpenID Connect (OpenID Connect Discovery 1.0)
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer" example:"http://localhost:8080"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint" example:"http://localhost:8080/api/oauth/authorize"`
+	TokenEndpoint                     string   `json:"token_endpoint" example:"http://localhost:8080/api/oauth/token"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint" example:"http://localhost:8080/api/oauth/userinfo"`
+	JwksURI                           string   `json:"jwks_uri" example:"http://localhost:8080/.well-known/jwks.json"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint" example:"http://localhost:8080/api/oauth/introspect"`
+	RevocationEndpoint                string   `json:"revocation_endpoint" example:"http://localhost:8080/api/oauth/revoke"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint" example:"http://localhost:8080/api/oauth/device_authorization"`
+	ScopesSupported                   []string `json:"scopes_supported" example:"openid"`
+	ResponseTypesSupported            []string `json:"response_types_supported" example:"code"`
+	GrantTypesSupported               []string `json:"grant_types_supported" example:"authorization_code"`
+	SubjectTypesSupported             []string `json:"subject_types_supported" example:"public"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported" example:"RS256"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported" example:"client_secret_basic"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported" example:"S256"`
+	ClaimsSupported                   []string `json:"claims_supported" example:"sub"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported" example:"ES256"`
+}
+
+// UserInfo ответ эндпоинта userinfo (OpenID Connect Core 5.3.2)
+type UserInfo struct {
+	Sub   string   `json:"sub" example:"090bb747-d6d3-4067-a1da-2b83726eb24d"`
+	Name  string   `json:"name,omitempty" example:"Ivan Ivanov"`
+	Email string   `json:"email,omitempty" example:"user@example.com"`
+	Roles []string `json:"roles,omitempty" example:"user"`
+}
+
+type OAuthError struct {
+	Error            string `json:"error" example:"invalid_request"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
@@ -1,69 +1,280 @@
 package middleware
 
 import (
-	"encoding/base64"
+	"fmt"
+	"net"
 	"net/http"
-	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/config"
 	"github.com/sater-151/AuthSystem/internal/controller/rest/restutils"
 	authsystem "github.com/sater-151/AuthSystem/internal/services/authSystem"
 	"github.com/sirupsen/logrus"
 )
 
+// ResolveTenant определяет тенант запроса по параметру пути tenant или по заголовку Host
+func ResolveTenant(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			host = c.Request.Host
+		}
+		tenant, err := as.ResolveTenant(c.Param("tenant"), host)
+		if err != nil {
+			if err == apperror.ErrTenantNotFound {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusNotFound)
+				return
+			}
+			logrus.Error(err)
+			restutils.Error(c, "", http.StatusInternalServerError)
+			return
+		}
+		restutils.SetTenant(c, tenant)
+		c.Next()
+	}
+}
+
+// TokenTransport задаёт способ передачи токенов для обработчиков и CheckAuthorization
+func TokenTransport(transport config.TransportConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		restutils.SetTransport(c, transport)
+		c.Next()
+	}
+}
+
+// CheckAuthorization проверяет access токен из cookie или заголовка Authorization. Истёкший токен из cookie
+// обновляется по refresh токену из cookie, если она передаётся во все запросы (COOKIE_REFRESH_PATH=/),
+// иначе клиент обновляет токен сам через /refresh
 func CheckAuthorization(as authsystem.AuthSystem) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logrus.Info("checking authorization")
-		rtCookie, err := c.Request.Cookie("rt")
+		aToken, transport := restutils.AccessToken(c)
+		if aToken == "" {
+			logrus.Warn("access token required")
+			abortUnauthorized(c)
+			return
+		}
+		// токен, привязанный к ключу DPoP, принимается только вместе с proof этого ключа
+		proof, ok := restutils.DPoPProof(c)
+		if !ok {
+			abortDPoPError(c, as, apperror.ErrInvalidDPoPProof)
+			return
+		}
+		jkt, err := as.CheckDPoP(restutils.GetTenant(c), aToken, proof, c.Request.Method, restutils.RequestURL(c, as.OpenIDConfiguration().Issuer))
 		if err != nil {
-			logrus.Error(err)
-			c.AbortWithStatus(http.StatusUnauthorized)
+			abortDPoPError(c, as, err)
 			return
 		}
-		rTokenCookie, err := url.QueryUnescape(rtCookie.Value)
+
+		actor, err := as.GetActor(restutils.GetTenant(c), aToken)
 		if err != nil {
-			logrus.Error(err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			logrus.Warn(err)
+			abortUnauthorized(c)
 			return
 		}
-		gettingRTBase64, err := base64.StdEncoding.DecodeString(rTokenCookie)
+		scope, err := as.TokenScope(restutils.GetTenant(c), aToken)
 		if err != nil {
-			logrus.Error(err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			abortStatusError(c, err)
+			return
+		}
+		// при обновлении токенов в middleware scope не меняется
+		restutils.SetScope(c, scope)
+		restutils.SetAccessToken(c, aToken)
+		if actor != "" {
+			// токен имперсонации не имеет refresh токена и не обновляется
+			if err = as.CheckStatus(restutils.GetTenant(c), aToken); err != nil {
+				abortStatusError(c, err)
+				return
+			}
+			logrus.WithField("actor", actor).Info("impersonated request")
+			restutils.SetActor(c, actor)
+			c.Next()
+			return
+		}
+
+		client, err := as.CheckClientToken(restutils.GetTenant(c), aToken)
+		if err != nil {
+			abortStatusError(c, err)
+			return
+		}
+		if client.ID != "" {
+			// токен клиента (client credentials) выдаётся без refresh токена и не обновляется
+			logrus.WithField("client", client.ID).Info("client request")
+			restutils.SetClient(c, client)
+			c.Next()
 			return
 		}
 
-		accessTCokie, err := c.Request.Cookie("at")
+		// без cookie refresh токена (она ограничена эндпоинтом обновления) access токен из cookie проверяется
+		// так же, как из заголовка, и истёкший токен клиент обновляет через /refresh
+		if transport == config.TransportHeader || !restutils.HasRefreshCookie(c) {
+			if err = as.CheckAccessToken(restutils.GetTenant(c), aToken); err != nil {
+				if err == apperror.ErrUnauthorized {
+					logrus.Warn(err)
+					c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+					c.AbortWithStatus(http.StatusUnauthorized)
+					return
+				}
+				abortStatusError(c, err)
+				return
+			}
+			if err = as.CheckStatus(restutils.GetTenant(c), aToken); err != nil {
+				abortStatusError(c, err)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		rToken, _, err := restutils.RefreshToken(c)
 		if err != nil {
 			logrus.Error(err)
+			if err == apperror.ErrIncorrectRefreshToken {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		if err = as.CheckTokens(accessTCokie.Value, string(gettingRTBase64)); err != nil {
+		if err = as.CheckTokens(restutils.GetTenant(c), aToken, rToken); err != nil {
 			if err != jwt.ErrTokenExpired {
 				logrus.Error(err)
 				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
 			logrus.Info("access token expired")
-			aToken, rToken, err := as.RefreshTokens(accessTCokie.Value, string(gettingRTBase64), c.Request.Header.Get("User-Agent"), c.ClientIP())
+			tokens, err := as.RefreshTokens(restutils.GetTenant(c), aToken, rToken, c.Request.Header.Get("User-Agent"), c.ClientIP(), "", jkt)
 			if err != nil {
+				if err == apperror.ErrInvalidDPoPProof {
+					abortDPoPError(c, as, err)
+					return
+				}
 				logrus.Warn(err)
 				if err == apperror.ErrUnauthorized {
 					c.AbortWithStatus(http.StatusUnauthorized)
 					return
 				}
+				if err == apperror.ErrUserDisabled || err == apperror.ErrUserSuspended {
+					restutils.Error(c, err.Error(), http.StatusForbidden)
+					return
+				}
 				c.AbortWithStatus(http.StatusInternalServerError)
 				return
 			}
-			restutils.SetCookieTokens(c, aToken, rToken)
+			restutils.SetCookieTokens(c, restutils.GetTenant(c), tokens.AccessToken, tokens.RefreshToken)
+			restutils.SetAccessToken(c, tokens.AccessToken)
 			c.Next()
 			return
 		}
+		if err = as.CheckStatus(restutils.GetTenant(c), aToken); err != nil {
+			abortStatusError(c, err)
+			return
+		}
+		c.Next()
+		return
+	}
+}
+
+// AuthenticateClient проверяет учётные данные OAuth клиента (client_secret_basic или client_secret_post)
+func AuthenticateClient(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret := restutils.ClientCredentials(c)
+		client, err := as.AuthenticateClient(restutils.GetTenant(c), clientID, clientSecret)
+		if err != nil {
+			if err == apperror.ErrInvalidClient {
+				logrus.Warn(err)
+				restutils.OAuthError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+				return
+			}
+			logrus.Error(err)
+			restutils.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		restutils.SetClient(c, client)
+		c.Next()
+	}
+}
+
+// DenyImpersonation запрещает опасные действия в сессиях, открытых через имперсонацию
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := restutils.GetActor(c); actor != "" {
+			logrus.WithField("actor", actor).Warn("action is not allowed for impersonated session")
+			restutils.Error(c, apperror.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
 		c.Next()
+	}
+}
+
+// RequireUser запрещает доступ по токену клиента к эндпоинтам, которые работают от имени пользователя
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if client := restutils.GetClient(c); client.ID != "" {
+			logrus.WithField("client", client.ID).Warn("user token required")
+			restutils.Error(c, apperror.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopes пропускает запрос, только если scope access токена содержит все scopes.
+// Используется после CheckAuthorization, при отказе возвращается 403 insufficient_scope (RFC 6750 3.1)
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(c *gin.Context) {
+		granted := strings.Fields(restutils.GetScope(c))
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				logrus.WithField("scope", required).Warn("insufficient scope")
+				c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+				restutils.Error(c, apperror.ErrInsufficientScope.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// abortDPoPError отклоняет запрос с неверным DPoP proof или без nonce сервера (RFC 9449 7.1, 9)
+func abortDPoPError(c *gin.Context, as authsystem.AuthSystem, err error) {
+	switch err {
+	case apperror.ErrInvalidDPoPProof:
+		logrus.Warn(err)
+		restutils.DPoPError(c, "invalid_dpop_proof", as.DPoPNonce(), as.OpenIDConfiguration().DPoPAlgorithms)
+	case apperror.ErrUseDPoPNonce:
+		logrus.Warn(err)
+		restutils.DPoPError(c, "use_dpop_nonce", as.DPoPNonce(), as.OpenIDConfiguration().DPoPAlgorithms)
+	default:
+		abortStatusError(c, err)
+	}
+}
+
+// abortUnauthorized отклоняет запрос без access токена. При передаче токенов в заголовках клиенту
+// сообщается схема Bearer (RFC 6750 3)
+func abortUnauthorized(c *gin.Context) {
+	if restutils.GetTransport(c).Header() {
+		c.Header("WWW-Authenticate", "Bearer")
+	}
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func abortStatusError(c *gin.Context, err error) {
+	logrus.Warn(err)
+	if err == apperror.ErrUnauthorized {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if err == apperror.ErrUserDisabled || err == apperror.ErrUserSuspended {
+		restutils.Error(c, err.Error(), http.StatusForbidden)
 		return
 	}
+	c.AbortWithStatus(http.StatusInternalServerError)
 }
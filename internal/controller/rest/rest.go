@@ -1,7 +1,6 @@
 package rest
 
 import (
-	"encoding/base64"
 	"net/http"
 	"net/url"
 
@@ -10,6 +9,7 @@ import (
 	"github.com/sater-151/AuthSystem/internal/apperror"
 	"github.com/sater-151/AuthSystem/internal/controller/rest/dto"
 	"github.com/sater-151/AuthSystem/internal/controller/rest/restutils"
+	"github.com/sater-151/AuthSystem/internal/models"
 	authsystem "github.com/sater-151/AuthSystem/internal/services/authSystem"
 	"github.com/sirupsen/logrus"
 )
@@ -20,11 +20,16 @@ import (
 // @Description	Генерация access и refresh токенов для пользователя с указанным guid
 // @Tags		Auth
 // @Param       guid   query      string  true  "user guid/id" default(090bb747-d6d3-4067-a1da-2b83726eb24d)
-// @Success		201
-// @Header      201 {Cookie}  at  "access token. Время жизни токена 60 секунд. Время жизни Cookie 30 дней."
-// @Header      201 {Cookie}  rt  "refresh token. Время жизни Cookie 30 дней."
+// @Param       scope  query      string  false "scope токенов, должен входить в DEFAULT_SCOPE. По умолчанию DEFAULT_SCOPE"
+// @Param       DPoP   header     string  false "DPoP proof (RFC 9449), привязывает токены сессии к ключу"
+// @Param       X-Client-Type  header  string  false "тип клиента при TOKEN_TRANSPORT both: browser получает токены только в cookie, native только в теле ответа" Enums(browser, native)
+// @Param       Accept header     string  false "application/json возвращает сроки действия токенов и сессию и при выдаче токенов в cookie"
+// @Success		201	{object}	dto.TokenResponse	"токены и сроки их действия в теле ответа при TOKEN_TRANSPORT header или both, без токенов при выдаче в cookie"
+// @Header      201 {Cookie}  at  "access token при TOKEN_TRANSPORT cookie или both, __Host-at при COOKIE_HOST_PREFIX. Время жизни токена 60 секунд, Cookie по умолчанию 30 дней."
+// @Header      201 {Cookie}  rt  "refresh token при TOKEN_TRANSPORT cookie или both, __Secure-rt при COOKIE_HOST_PREFIX. Передаётся только в /api/refresh, время жизни Cookie по умолчанию 30 дней."
 // @Failure		400	{object}	map[string]string
 // @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Router		/api/login [post]
 func Login(as authsystem.AuthSystem) gin.HandlerFunc {
@@ -36,21 +41,36 @@ func Login(as authsystem.AuthSystem) gin.HandlerFunc {
 			restutils.Error(c, apperror.ErrGUIDRequired.Error(), http.StatusBadRequest)
 			return
 		}
+		restutils.SetDPoPNonce(c, as.DPoPNonce())
+		jkt, err := dpopKey(c, as)
+		if err != nil {
+			dpopError(c, err)
+			return
+		}
 
-		aToken, rToken, err := as.Login(guid, c.Request.Header.Get("User-Agent"), c.ClientIP())
+		tokens, err := as.Login(restutils.GetTenant(c), guid, c.Request.Header.Get("User-Agent"), c.ClientIP(), c.Query("scope"), jkt)
 		if err != nil {
+			if err == apperror.ErrInvalidScope {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusBadRequest)
+				return
+			}
 			if err == apperror.ErrUnauthorized {
 				logrus.Warn(err)
 				restutils.Error(c, err.Error(), http.StatusUnauthorized)
 				return
 			}
+			if err == apperror.ErrUserDisabled || err == apperror.ErrUserSuspended {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusForbidden)
+				return
+			}
 			logrus.Error(err)
 			restutils.Error(c, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		restutils.SetCookieTokens(c, aToken, rToken)
-		c.Status(http.StatusCreated)
+		restutils.SendTokens(c, tokens, "")
 		logrus.Info("tokens have been sent")
 	}
 }
@@ -58,63 +78,83 @@ func Login(as authsystem.AuthSystem) gin.HandlerFunc {
 // RefreshTokens godoc
 //
 // @Summary		Refresh tokens
-// @Description	Генерация новых access и refresh токенов на основе guid в access токене
+// @Description	Генерация новых access и refresh токенов на основе guid в access токене. Scope можно только сузить.
+// @Description	Токены принимаются из cookie at и rt или, при TOKEN_TRANSPORT header или both, из заголовка Authorization и заголовка rt или тела запроса.
+// @Description	Новые токены выдаются тем же способом, которым переданы токены запроса
+// @Security 	Bearer && RefreshToken
 // @Tags		Auth
-// @Success		201
-// @Header      201 {Cookie}  at  "access token. Время жизни токена 60 секунд. Время жизни Cookie 30 дней."
-// @Header      201 {Cookie}  rt  "refresh token. Время жизни Cookie 30 дней."
+// @Accept		json
+// @Param       scope    query      string              false "scope новых токенов, должен входить в scope сессии"
+// @Param       DPoP     header     string              false "DPoP proof, обязателен для сессии, привязанной к ключу DPoP"
+// @Param       request  body       dto.RefreshRequest  false "refresh токен, если он не передан в заголовке rt"
+// @Param       Accept   header     string              false "application/json возвращает сроки действия токенов и сессию и при выдаче токенов в cookie"
+// @Success		201	{object}	dto.TokenResponse	"токены и сроки их действия в теле ответа, если токены запроса переданы в заголовках, без токенов при выдаче в cookie"
+// @Header      201 {Cookie}  at  "access token, если токены запроса переданы в cookie. Время жизни токена 60 секунд, Cookie по умолчанию 30 дней."
+// @Header      201 {Cookie}  rt  "refresh token, если токены запроса переданы в cookie. Передаётся только в /api/refresh, время жизни Cookie по умолчанию 30 дней."
 // @Failure		400	{object}	map[string]string
 // @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Router		/api/refresh [post]
 func Refresh(as authsystem.AuthSystem) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logrus.Info("starting refreshing tokens")
-		rtCookie, err := c.Request.Cookie("rt")
+		rToken, transport, err := restutils.RefreshTokenWithBody(c)
 		if err != nil {
 			logrus.Error(err)
+			if err == apperror.ErrIncorrectRefreshToken {
+				restutils.Error(c, err.Error(), http.StatusBadRequest)
+				return
+			}
 			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
 			return
 		}
-		rTokenCookie, err := url.QueryUnescape(rtCookie.Value)
-		if err != nil {
-			logrus.Error(err)
-			restutils.Error(c, apperror.ErrIncorrectRefreshToken.Error(), http.StatusBadRequest)
-			return
-		}
-		gettingRTBase64, err := base64.StdEncoding.DecodeString(rTokenCookie)
-		if err != nil {
-			logrus.Error(err)
-			restutils.Error(c, apperror.ErrIncorrectRefreshToken.Error(), http.StatusBadRequest)
-			return
-		}
 
-		atCookie, err := c.Request.Cookie("at")
-		if err != nil {
-			logrus.Error(err)
+		aToken, _ := restutils.AccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
 			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
 			return
 		}
-		if err = as.CheckTokens(atCookie.Value, string(gettingRTBase64)); err != nil {
+		if err = as.CheckTokens(restutils.GetTenant(c), aToken, rToken); err != nil {
 			if err != jwt.ErrTokenExpired {
 				logrus.Error(err)
 				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
 		}
-		aToken, rToken, err := as.RefreshTokens(atCookie.Value, string(gettingRTBase64), c.Request.Header.Get("User-Agent"), c.ClientIP())
+		restutils.SetDPoPNonce(c, as.DPoPNonce())
+		jkt, err := dpopKey(c, as)
 		if err != nil {
+			dpopError(c, err)
+			return
+		}
+		tokens, err := as.RefreshTokens(restutils.GetTenant(c), aToken, rToken, c.Request.Header.Get("User-Agent"), c.ClientIP(), c.Query("scope"), jkt)
+		if err != nil {
+			if err == apperror.ErrInvalidDPoPProof {
+				dpopError(c, err)
+				return
+			}
+			if err == apperror.ErrInvalidScope {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusBadRequest)
+				return
+			}
 			if err == apperror.ErrUnauthorized {
 				logrus.Warn(err)
 				restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
 				return
 			}
+			if err == apperror.ErrUserDisabled || err == apperror.ErrUserSuspended {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusForbidden)
+				return
+			}
 			logrus.Error(err)
 			restutils.Error(c, "", http.StatusInternalServerError)
 			return
 		}
-		restutils.SetCookieTokens(c, aToken, rToken)
-		c.Status(http.StatusCreated)
+		restutils.SendTokens(c, tokens, transport)
 
 		logrus.Info("tokens refreshed")
 	}
@@ -123,25 +163,25 @@ func Refresh(as authsystem.AuthSystem) gin.HandlerFunc {
 // Deauthorization godoc
 //
 // @Summary		User deauthorization
-// @Security 	AccessToken
-// @Security 	RefreshToken
-// @Description	Деавторизация пользователя на основе guid из access токена. ВНИМАНИЕ! Guid пользователя будет удалено из БД
+// @Security 	Bearer
+// @Description	Деавторизация пользователя на основе guid из access токена. Завершается сессия, в которой выпущен токен, профиль, роли и статус пользователя сохраняются
 // @Tags		Auth
 // @Success		204
 // @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Router		/api/auth/logout [post]
 func Deauthorization(as authsystem.AuthSystem) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logrus.Info("starting logout")
-		atCookie, err := c.Request.Cookie("at")
-		if err != nil {
-			logrus.Error(err)
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
 			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		err = as.Logout(atCookie.Value)
+		err := as.Logout(restutils.GetTenant(c), aToken)
 		if err != nil {
 			logrus.Warn(err)
 			if err != apperror.ErrUnauthorized {
@@ -157,31 +197,949 @@ func Deauthorization(as authsystem.AuthSystem) gin.HandlerFunc {
 // GetGUID godoc
 //
 // @Summary		Get user's guid
-// @Security 	AccessToken
-// @Security 	RefreshToken
-// @Description	получение guid пользователя из полученного access токена
+// @Security 	Bearer
+// @Description	получение guid пользователя из полученного access токена. Устарело, используйте /api/auth/me
+// @Deprecated
 // @Tags		Get
 // @Success		200	{object} 	dto.GUID
 // @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Router		/api/auth/guid [get]
 func GetGUID(as authsystem.AuthSystem) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logrus.Info("getting guid")
-		atCookie, err := c.Request.Cookie("at")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		guid, err := as.GetGUID(restutils.GetTenant(c), aToken)
+		if err != nil {
+			if err == apperror.ErrUnauthorized {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			logrus.Error(err)
+			restutils.Error(c, "", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, dto.GUID{Guid: guid})
+	}
+}
+
+// GetProfile godoc
+//
+// @Summary		Get current user's profile
+// @Security 	Bearer
+// @Description	получение профиля пользователя и информации о текущей сессии по access токену, требуется scope profile
+// @Tags		Get
+// @Success		200	{object} 	dto.Profile
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/auth/me [get]
+func GetProfile(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("getting profile")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		profile, err := as.GetProfile(restutils.GetTenant(c), aToken)
+		if err != nil {
+			if err == apperror.ErrUnauthorized {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			logrus.Error(err)
+			restutils.Error(c, "", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, restutils.ProfileToDTO(profile))
+	}
+}
+
+// UpdateProfile godoc
+//
+// @Summary		Update current user's profile
+// @Security 	Bearer
+// @Description	изменение email и отображаемого имени пользователя. Не переданные поля не изменяются, требуется scope profile
+// @Tags		Auth
+// @Accept		json
+// @Param		profile	body	dto.UpdateProfile	true	"profile attributes"
+// @Success		200	{object} 	dto.Profile
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/auth/me [patch]
+func UpdateProfile(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("updating profile")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.UpdateProfile
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, apperror.ErrIncorrectProfile.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := as.UpdateProfile(restutils.GetTenant(c), aToken, req.Email, req.DisplayName); err != nil {
+			if err == apperror.ErrUnauthorized {
+				logrus.Warn(err)
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			logrus.Error(err)
+			restutils.Error(c, "", http.StatusInternalServerError)
+			return
+		}
+
+		profile, err := as.GetProfile(restutils.GetTenant(c), aToken)
 		if err != nil {
 			logrus.Error(err)
+			restutils.Error(c, "", http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, restutils.ProfileToDTO(profile))
+		logrus.Info("profile updated")
+	}
+}
+
+// Impersonate godoc
+//
+// @Summary		Issue impersonation token
+// @Security 	Bearer
+// @Description	Выпуск короткоживущего access токена пользователя для сотрудника поддержки. Доступно только администраторам.
+// @Description	Refresh токен не выдаётся, сотрудник указывается в claim act, выпуск записывается в журнал аудита
+// @Tags		Auth
+// @Accept		json
+// @Param		request	body	dto.ImpersonateRequest	true	"target user and reason"
+// @Success		201	{object} 	dto.ImpersonationToken
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/auth/impersonate [post]
+func Impersonate(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting impersonation")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.ImpersonateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, apperror.ErrReasonRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		impToken, expiresIn, err := as.Impersonate(restutils.GetTenant(c), aToken, req.Guid, req.Reason, c.Request.Header.Get("User-Agent"), c.ClientIP())
+		if err != nil {
+			logrus.Warn(err)
+			switch err {
+			case apperror.ErrReasonRequired:
+				restutils.Error(c, err.Error(), http.StatusBadRequest)
+			case apperror.ErrUnauthorized:
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+			case apperror.ErrForbidden, apperror.ErrUserDisabled, apperror.ErrUserSuspended:
+				restutils.Error(c, err.Error(), http.StatusForbidden)
+			case apperror.ErrUserNotFound:
+				restutils.Error(c, err.Error(), http.StatusNotFound)
+			default:
+				logrus.Error(err)
+				restutils.Error(c, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		c.JSON(http.StatusCreated, dto.ImpersonationToken{
+			AccessToken: impToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int(expiresIn.Seconds()),
+		})
+		logrus.Info("impersonation token issued")
+	}
+}
+
+// RevokeUser godoc
+//
+// @Summary		Revoke user session
+// @Security 	Bearer
+// @Description	Завершение сессии пользователя администратором. Refresh токен удаляется, последний выпущенный access токен
+// @Description	отзывается и перестаёт приниматься в течение интервала синхронизации denylist
+// @Tags		Auth
+// @Accept		json
+// @Param		request	body	dto.RevokeUserRequest	true	"target user"
+// @Success		204
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/auth/admin/revoke [post]
+func RevokeUser(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting user revocation")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.RevokeUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, apperror.ErrGUIDRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := as.RevokeUser(restutils.GetTenant(c), aToken, req.Guid, c.ClientIP()); err != nil {
+			logrus.Warn(err)
+			switch err {
+			case apperror.ErrUnauthorized:
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+			case apperror.ErrForbidden:
+				restutils.Error(c, err.Error(), http.StatusForbidden)
+			case apperror.ErrUserNotFound:
+				restutils.Error(c, err.Error(), http.StatusNotFound)
+			default:
+				logrus.Error(err)
+				restutils.Error(c, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		c.Status(http.StatusNoContent)
+		logrus.Info("user revoked")
+	}
+}
+
+// JWKS godoc
+//
+// @Summary		JSON Web Key Set
+// @Description	Открытые ключи для проверки подписи access токенов (RS256, ES256, EdDSA). При подписи HS512 список ключей пуст
+// @Tags		Keys
+// @Produce		json
+// @Success		200	{object} 	dto.JWKS
+// @Router		/.well-known/jwks.json [get]
+func JWKS(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, dto.JWKS{Keys: as.JWKS()})
+	}
+}
+
+// Introspect godoc
+//
+// @Summary		Token introspection
+// @Security 	ClientBasic
+// @Description	Проверка access или refresh токена по RFC 7662. Доступно клиентам с разрешением на интроспекцию.
+// @Description	Токен активен, если он корректен, пользователь не заблокирован и сессия не завершена
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Produce		json
+// @Param		token			formData	string	true	"access или refresh токен"
+// @Param		token_type_hint	formData	string	false	"access_token или refresh_token"
+// @Success		200	{object} 	dto.Introspection
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	dto.OAuthError
+// @Failure		403	{object}	dto.OAuthError
+// @Failure		500	{object}	dto.OAuthError
+// @Router		/api/oauth/introspect [post]
+func Introspect(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("introspecting token")
+		token := c.PostForm("token")
+		if token == "" {
+			logrus.Warn("token required")
+			restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "token required")
+			return
+		}
+		client := restutils.GetClient(c)
+
+		introspection, err := as.Introspect(restutils.GetTenant(c), client, token, c.PostForm("token_type_hint"))
+		if err != nil {
+			if err == apperror.ErrForbidden {
+				logrus.Warn(err)
+				restutils.OAuthError(c, http.StatusForbidden, "unauthorized_client", "introspection isn't allowed for client")
+				return
+			}
+			logrus.Error(err)
+			restutils.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, restutils.IntrospectionToDTO(introspection, client))
+	}
+}
+
+// Revoke godoc
+//
+// @Summary		Token revocation
+// @Security 	ClientBasic
+// @Description	Отзыв access или refresh токена по RFC 7009: сессия, к которой относится токен, завершается.
+// @Description	Публичные клиенты передают только client_id. Для неизвестного или уже отозванного токена также возвращается 200
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Param		token			formData	string	true	"access или refresh токен"
+// @Param		token_type_hint	formData	string	false	"access_token или refresh_token"
+// @Success		200
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	dto.OAuthError
+// @Failure		503	{object}	dto.OAuthError
+// @Router		/api/oauth/revoke [post]
+func Revoke(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("revoking token")
+		token := c.PostForm("token")
+		if token == "" {
+			logrus.Warn("token required")
+			restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "token required")
+			return
+		}
+
+		if err := as.Revoke(restutils.GetTenant(c), restutils.GetClient(c), token, c.PostForm("token_type_hint")); err != nil {
+			logrus.Error(err)
+			restutils.OAuthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+			return
+		}
+		c.Status(http.StatusOK)
+		logrus.Info("token revoked")
+	}
+}
+
+// RegisterClient godoc
+//
+// @Summary		Register OAuth client
+// @Security 	Bearer
+// @Description	Регистрация OAuth клиента администратором. Секрет создаётся для конфиденциального клиента и возвращается только в этом ответе.
+// @Description	Доверенному клиенту (first_party) код авторизации выдаётся без запроса согласия пользователя
+// @Tags		OAuth
+// @Accept		json
+// @Produce		json
+// @Param		request	body	dto.RegisterClient	true	"client"
+// @Success		201	{object} 	dto.Client
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/auth/admin/clients [post]
+func RegisterClient(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("registering oauth client")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
 			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		var guid dto.GUID
-		guid.Guid, err = as.GetGUID(atCookie.Value)
+		var req dto.RegisterClient
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, apperror.ErrInvalidRequest.Error(), http.StatusBadRequest)
+			return
+		}
+
+		client, secret, err := as.RegisterClient(restutils.GetTenant(c), aToken, models.Client{
+			Name:              req.Name,
+			RedirectURIs:      req.RedirectURIs,
+			Confidential:      req.Confidential,
+			FirstParty:        req.FirstParty,
+			ClientCredentials: req.ClientCredentials,
+			Scopes:            req.Scopes,
+			ExchangeAudiences: req.ExchangeAudiences,
+		})
+		if err != nil {
+			logrus.Warn(err)
+			switch err {
+			case apperror.ErrInvalidRedirectURI, apperror.ErrUnauthorizedClient, apperror.ErrInvalidScope, apperror.ErrInvalidTarget:
+				restutils.Error(c, err.Error(), http.StatusBadRequest)
+			case apperror.ErrUnauthorized:
+				restutils.Error(c, err.Error(), http.StatusUnauthorized)
+			case apperror.ErrForbidden:
+				restutils.Error(c, err.Error(), http.StatusForbidden)
+			default:
+				logrus.Error(err)
+				restutils.Error(c, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, restutils.ClientToDTO(client, secret))
+		logrus.Info("oauth client registered")
+	}
+}
+
+// Authorize godoc
+//
+// @Summary		Authorization request
+// @Security 	Bearer
+// @Description	Запрос кода авторизации (RFC 6749 4.1) с обязательным PKCE S256 (RFC 7636) от имени вошедшего пользователя.
+// @Description	Доверенный клиент сразу перенаправляется на redirect_uri с кодом, для остальных клиентов без согласия пользователя
+// @Description	возвращается 200 с описанием запроса, согласие отправляется на POST /api/oauth/authorize
+// @Tags		OAuth
+// @Produce		json
+// @Param		response_type			query	string	true	"code"
+// @Param		client_id				query	string	true	"client id"
+// @Param		redirect_uri			query	string	false	"зарегистрированный redirect uri"
+// @Param		scope					query	string	false	"scope"
+// @Param		state					query	string	false	"state"
+// @Param		code_challenge			query	string	true	"BASE64URL(SHA256(code_verifier))"
+// @Param		code_challenge_method	query	string	true	"S256"
+// @Success		200	{object} 	dto.Consent
+// @Success		302
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/oauth/authorize [get]
+func Authorize(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting authorization request")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		request := restutils.AuthorizationRequest(c)
+		client, scope, code, err := as.Authorize(restutils.GetTenant(c), aToken, request)
+		authorizationResponse(c, client, request, scope, code, err)
+	}
+}
+
+// AuthorizeConsent godoc
+//
+// @Summary		Authorization consent
+// @Security 	Bearer
+// @Description	Согласие пользователя на запрос авторизации. Передаются те же параметры, что и в GET /api/oauth/authorize,
+// @Description	и consent=approve или consent=deny. Пользователь перенаправляется на redirect_uri с кодом или с ошибкой access_denied.
+// @Description	Если access токен передан в cookie, форма передаёт csrf_token из ответа GET /api/oauth/authorize
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Param		response_type			formData	string	true	"code"
+// @Param		client_id				formData	string	true	"client id"
+// @Param		redirect_uri			formData	string	false	"зарегистрированный redirect uri"
+// @Param		scope					formData	string	false	"scope"
+// @Param		state					formData	string	false	"state"
+// @Param		code_challenge			formData	string	true	"BASE64URL(SHA256(code_verifier))"
+// @Param		code_challenge_method	formData	string	true	"S256"
+// @Param		consent					formData	string	true	"approve или deny"
+// @Param		csrf_token				formData	string	false	"токен из ответа GET /api/oauth/authorize, обязателен при access токене в cookie"
+// @Success		302
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/oauth/authorize [post]
+func AuthorizeConsent(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting authorization consent")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := restutils.CheckCSRFToken(c); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, err.Error(), http.StatusForbidden)
+			return
+		}
+		request := restutils.AuthorizationRequest(c)
+		client, scope, code, err := as.Consent(restutils.GetTenant(c), aToken, request, c.PostForm("consent") == "approve")
+		authorizationResponse(c, client, request, scope, code, err)
+	}
+}
+
+// authorizationResponse отправляет код или ошибку на redirect_uri. Пока клиент и redirect_uri не проверены,
+// ошибка возвращается пользователю без перенаправления (RFC 6749 4.1.2.1). scope выдаваемый кодом scope, он показывается при запросе согласия
+func authorizationResponse(c *gin.Context, client models.Client, request models.AuthorizationRequest, scope string, code string, err error) {
+	redirectURI := authsystem.RedirectURI(client, request)
+	switch err {
+	case nil:
+		restutils.AuthorizationRedirect(c, redirectURI, request.State, url.Values{"code": {code}})
+		logrus.Info("authorization code issued")
+	case apperror.ErrConsentRequired:
+		csrfToken, err := restutils.SetCSRFToken(c)
 		if err != nil {
 			logrus.Error(err)
 			restutils.Error(c, "", http.StatusInternalServerError)
 			return
 		}
-		c.JSON(http.StatusOK, guid)
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, dto.Consent{ClientID: client.ID, ClientName: client.Name, Scope: scope, CSRFToken: csrfToken})
+		logrus.Info("consent required")
+	case apperror.ErrInvalidClient, apperror.ErrInvalidRedirectURI:
+		logrus.Warn(err)
+		restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	case apperror.ErrUnauthorized:
+		logrus.Warn(err)
+		restutils.Error(c, err.Error(), http.StatusUnauthorized)
+	case apperror.ErrUserDisabled, apperror.ErrUserSuspended:
+		logrus.Warn(err)
+		restutils.Error(c, err.Error(), http.StatusForbidden)
+	case apperror.ErrInvalidRequest:
+		logrus.Warn(err)
+		restutils.AuthorizationRedirect(c, redirectURI, request.State, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"code_challenge with code_challenge_method S256 required"},
+		})
+	case apperror.ErrUnsupportedResponseType:
+		logrus.Warn(err)
+		restutils.AuthorizationRedirect(c, redirectURI, request.State, url.Values{"error": {"unsupported_response_type"}})
+	case apperror.ErrInvalidScope:
+		logrus.Warn(err)
+		restutils.AuthorizationRedirect(c, redirectURI, request.State, url.Values{"error": {"invalid_scope"}})
+	case apperror.ErrAccessDenied:
+		logrus.Warn(err)
+		restutils.AuthorizationRedirect(c, redirectURI, request.State, url.Values{"error": {"access_denied"}})
+	default:
+		logrus.Error(err)
+		restutils.Error(c, "", http.StatusInternalServerError)
+	}
+}
+
+// Token godoc
+//
+// @Summary		Token endpoint
+// @Security 	ClientBasic
+// @Description	Обмен кода авторизации на токены (grant_type=authorization_code, требуется code_verifier),
+// @Description	обновление токенов по refresh токену (grant_type=refresh_token) и выпуск токена клиента от его имени
+// @Description	(grant_type=client_credentials, только конфиденциальные клиенты), а также опрос устройством после подтверждения
+// @Description	пользователем (grant_type=urn:ietf:params:oauth:grant-type:device_code). Публичные клиенты передают только client_id.
+// @Description	Сервис, действующий от имени пользователя, обменивает его access токен на токен для другого сервиса
+// @Description	(grant_type=urn:ietf:params:oauth:grant-type:token-exchange, RFC 8693)
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Produce		json
+// @Param		grant_type		formData	string	true	"authorization_code, refresh_token, client_credentials, urn:ietf:params:oauth:grant-type:device_code или urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param		code			formData	string	false	"код авторизации"
+// @Param		redirect_uri	formData	string	false	"redirect uri из запроса авторизации"
+// @Param		code_verifier	formData	string	false	"PKCE code verifier"
+// @Param		refresh_token	formData	string	false	"refresh токен"
+// @Param		scope			formData	string	false	"scope токена клиента, токена, полученного обменом, или суженный scope при обновлении"
+// @Param		device_code		formData	string	false	"device code"
+// @Param		subject_token		formData	string	false	"access токен пользователя для обмена"
+// @Param		subject_token_type	formData	string	false	"urn:ietf:params:oauth:token-type:access_token"
+// @Param		audience			formData	string	false	"сервис, для которого выпускается токен"
+// @Param		DPoP				header		string	false	"DPoP proof (RFC 9449), привязывает выданные токены к ключу. Не применяется к обмену токенов"
+// @Success		200	{object} 	dto.TokenResponse
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	dto.OAuthError
+// @Failure		500	{object}	dto.OAuthError
+// @Router		/api/oauth/token [post]
+func Token(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grantType := c.PostForm("grant_type")
+		logrus.WithField("grant_type", grantType).Info("starting token request")
+		tenant := restutils.GetTenant(c)
+		client := restutils.GetClient(c)
+		userAgent := c.Request.Header.Get("User-Agent")
+
+		restutils.SetDPoPNonce(c, as.DPoPNonce())
+		jkt, err := dpopKey(c, as)
+		if err != nil {
+			dpopOAuthError(c, err)
+			return
+		}
+		var tokens models.Tokens
+		switch grantType {
+		case authsystem.GrantAuthorizationCode:
+			code, codeVerifier := c.PostForm("code"), c.PostForm("code_verifier")
+			if code == "" || codeVerifier == "" {
+				logrus.Warn("code and code_verifier required")
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "code and code_verifier required")
+				return
+			}
+			tokens, err = as.ExchangeCode(tenant, client, code, c.PostForm("redirect_uri"), codeVerifier, userAgent, c.ClientIP(), jkt)
+		case authsystem.GrantRefreshToken:
+			rToken := c.PostForm("refresh_token")
+			if rToken == "" {
+				logrus.Warn("refresh_token required")
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "refresh_token required")
+				return
+			}
+			tokens, err = as.RefreshGrant(tenant, client, rToken, userAgent, c.ClientIP(), c.PostForm("scope"), jkt)
+		case authsystem.GrantClientCredentials:
+			tokens, err = as.ClientCredentials(tenant, client, c.PostForm("scope"), jkt)
+		case authsystem.GrantDeviceCode:
+			deviceCode := c.PostForm("device_code")
+			if deviceCode == "" {
+				logrus.Warn("device_code required")
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "device_code required")
+				return
+			}
+			tokens, err = as.DeviceCodeGrant(tenant, client, deviceCode, userAgent, c.ClientIP(), jkt)
+		case authsystem.GrantTokenExchange:
+			subjectToken, audience := c.PostForm("subject_token"), c.PostForm("audience")
+			if subjectToken == "" || audience == "" {
+				logrus.Warn("subject_token and audience required")
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "subject_token and audience required")
+				return
+			}
+			if requested := c.PostForm("requested_token_type"); requested != "" && requested != authsystem.TokenTypeAccessURN {
+				logrus.Warn("unsupported requested_token_type")
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "only access tokens can be requested")
+				return
+			}
+			tokens, err = as.ExchangeToken(tenant, client, subjectToken, c.PostForm("subject_token_type"), audience, c.PostForm("scope"))
+		default:
+			logrus.Warn("unsupported grant type")
+			restutils.OAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+			return
+		}
+		if err != nil {
+			logrus.Warn(err)
+			switch err {
+			case apperror.ErrInvalidGrant:
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_grant", "")
+			case apperror.ErrUnauthorizedClient:
+				restutils.OAuthError(c, http.StatusBadRequest, "unauthorized_client", err.Error())
+			case apperror.ErrInvalidScope:
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_scope", "")
+			case apperror.ErrAuthorizationPending:
+				restutils.OAuthError(c, http.StatusBadRequest, "authorization_pending", "")
+			case apperror.ErrSlowDown:
+				restutils.OAuthError(c, http.StatusBadRequest, "slow_down", "")
+			case apperror.ErrAccessDenied:
+				restutils.OAuthError(c, http.StatusBadRequest, "access_denied", "")
+			case apperror.ErrExpiredToken:
+				restutils.OAuthError(c, http.StatusBadRequest, "expired_token", "")
+			case apperror.ErrInvalidRequest:
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_request", "")
+			case apperror.ErrInvalidTarget:
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_target", "")
+			case apperror.ErrInvalidDPoPProof:
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_dpop_proof", "")
+			default:
+				logrus.Error(err)
+				restutils.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+			}
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+		c.JSON(http.StatusOK, restutils.TokensToDTO(tokens))
+		logrus.Info("tokens issued")
+	}
+}
+
+// dpopKey проверяет DPoP proof запроса на выпуск токенов и возвращает отпечаток его ключа.
+// Без proof выдаются bearer токены
+func dpopKey(c *gin.Context, as authsystem.AuthSystem) (string, error) {
+	proof, ok := restutils.DPoPProof(c)
+	if !ok {
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	return as.DPoPProof(restutils.GetTenant(c), proof, c.Request.Method, restutils.RequestURL(c, as.OpenIDConfiguration().Issuer), "")
+}
+
+func dpopError(c *gin.Context, err error) {
+	if err == apperror.ErrInvalidDPoPProof || err == apperror.ErrUseDPoPNonce {
+		logrus.Warn(err)
+		restutils.Error(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logrus.Error(err)
+	restutils.Error(c, "", http.StatusInternalServerError)
+}
+
+// dpopOAuthError ошибка DPoP proof на эндпоинте токенов (RFC 9449 5, 8)
+func dpopOAuthError(c *gin.Context, err error) {
+	switch err {
+	case apperror.ErrInvalidDPoPProof:
+		logrus.Warn(err)
+		restutils.OAuthError(c, http.StatusBadRequest, "invalid_dpop_proof", "")
+	case apperror.ErrUseDPoPNonce:
+		logrus.Warn(err)
+		restutils.OAuthError(c, http.StatusBadRequest, "use_dpop_nonce", "")
+	default:
+		logrus.Error(err)
+		restutils.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+	}
+}
+
+// OpenIDConfiguration godoc
+//
+// @Summary		OpenID Connect discovery
+// @Description	Метаданные провайдера OpenID Connect. Адреса эндпоинтов строятся от JWT_ISSUER, если он не задан, от адреса запроса
+// @Tags		OIDC
+// @Produce		json
+// @Success		200	{object} 	dto.OpenIDConfiguration
+// @Router		/.well-known/openid-configuration [get]
+func OpenIDConfiguration(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		configuration := as.OpenIDConfiguration()
+		issuer := restutils.BaseURL(c, configuration.Issuer)
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, dto.OpenIDConfiguration{
+			Issuer:                            issuer,
+			AuthorizationEndpoint:             issuer + "/api/oauth/authorize",
+			TokenEndpoint:                     issuer + "/api/oauth/token",
+			UserinfoEndpoint:                  issuer + "/api/oauth/userinfo",
+			JwksURI:                           issuer + "/.well-known/jwks.json",
+			IntrospectionEndpoint:             issuer + "/api/oauth/introspect",
+			RevocationEndpoint:                issuer + "/api/oauth/revoke",
+			DeviceAuthorizationEndpoint:       issuer + "/api/oauth/device_authorization",
+			ScopesSupported:                   []string{authsystem.ScopeOpenID, authsystem.ScopeProfile, authsystem.ScopeEmail},
+			ResponseTypesSupported:            []string{authsystem.ResponseTypeCode},
+			GrantTypesSupported:               []string{authsystem.GrantAuthorizationCode, authsystem.GrantRefreshToken, authsystem.GrantClientCredentials, authsystem.GrantDeviceCode, authsystem.GrantTokenExchange},
+			SubjectTypesSupported:             []string{"public"},
+			IDTokenSigningAlgValuesSupported:  configuration.SigningAlgorithms,
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			CodeChallengeMethodsSupported:     []string{authsystem.CodeChallengeS256},
+			ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "name", "email"},
+			DPoPSigningAlgValuesSupported:     configuration.DPoPAlgorithms,
+		})
+	}
+}
+
+// UserInfo godoc
+//
+// @Summary		OpenID Connect userinfo
+// @Security 	Bearer
+// @Description	Профиль владельца access токена. Токен передаётся в заголовке Authorization: Bearer или DPoP или в cookie at.
+// @Description	Для токена, привязанного к ключу DPoP, нужен DPoP proof с ath
+// @Description	Требуется scope openid, name и roles возвращаются со scope profile, email со scope email
+// @Tags		OIDC
+// @Produce		json
+// @Param		DPoP	header	string	false	"DPoP proof для токена, привязанного к ключу"
+// @Success		200	{object} 	dto.UserInfo
+// @Failure		401
+// @Failure		403
+// @Failure		500
+// @Router		/api/oauth/userinfo [get]
+// @Router		/api/oauth/userinfo [post]
+func UserInfo(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("getting userinfo")
+		aToken := restutils.BearerToken(c)
+		if aToken == "" {
+			aToken = restutils.DPoPToken(c)
+		}
+		if aToken == "" {
+			aToken = restutils.AccessCookie(c)
+		}
+		if aToken == "" {
+			logrus.Warn("access token required")
+			c.Header("WWW-Authenticate", `Bearer realm="userinfo"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		configuration := as.OpenIDConfiguration()
+		proof, ok := restutils.DPoPProof(c)
+		if !ok {
+			logrus.Warn(apperror.ErrInvalidDPoPProof)
+			restutils.DPoPError(c, "invalid_dpop_proof", "", configuration.DPoPAlgorithms)
+			return
+		}
+		_, err := as.CheckDPoP(restutils.GetTenant(c), aToken, proof, c.Request.Method, restutils.RequestURL(c, configuration.Issuer))
+		switch err {
+		case nil, apperror.ErrUnauthorized:
+			// недействительный токен отклоняется при проверке ниже
+		case apperror.ErrInvalidDPoPProof:
+			logrus.Warn(err)
+			restutils.DPoPError(c, "invalid_dpop_proof", as.DPoPNonce(), configuration.DPoPAlgorithms)
+			return
+		case apperror.ErrUseDPoPNonce:
+			logrus.Warn(err)
+			restutils.DPoPError(c, "use_dpop_nonce", as.DPoPNonce(), configuration.DPoPAlgorithms)
+			return
+		default:
+			logrus.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		profile, err := as.UserInfo(restutils.GetTenant(c), aToken)
+		if err != nil {
+			if err == apperror.ErrUnauthorized {
+				logrus.Warn(err)
+				c.Header("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			if err == apperror.ErrInsufficientScope {
+				logrus.Warn(err)
+				c.Header("WWW-Authenticate", `Bearer realm="userinfo", error="insufficient_scope", scope="openid"`)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			logrus.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, restutils.UserInfoToDTO(profile))
+	}
+}
+
+// DeviceAuthorization godoc
+//
+// @Summary		Device authorization request
+// @Security 	ClientBasic
+// @Description	Начало авторизации устройства без браузера (RFC 8628). Устройство показывает пользователю user_code и адрес подтверждения,
+// @Description	затем опрашивает /api/oauth/token с grant_type=urn:ietf:params:oauth:grant-type:device_code не чаще interval секунд
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Produce		json
+// @Param		scope	formData	string	false	"scope"
+// @Success		200	{object} 	dto.DeviceAuthorization
+// @Failure		400	{object}	dto.OAuthError
+// @Failure		401	{object}	dto.OAuthError
+// @Failure		500	{object}	dto.OAuthError
+// @Router		/api/oauth/device_authorization [post]
+func DeviceAuthorization(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting device authorization")
+		authorization, err := as.DeviceAuthorization(restutils.GetTenant(c), restutils.GetClient(c), c.PostForm("scope"))
+		if err != nil {
+			if err == apperror.ErrInvalidScope {
+				logrus.Warn(err)
+				restutils.OAuthError(c, http.StatusBadRequest, "invalid_scope", "")
+				return
+			}
+			logrus.Error(err)
+			restutils.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+		issuer := restutils.BaseURL(c, as.OpenIDConfiguration().Issuer)
+		verificationURI := issuer + "/api/oauth/device"
+		if tenant := c.Param("tenant"); tenant != "" {
+			verificationURI = issuer + "/api/t/" + url.PathEscape(tenant) + "/oauth/device"
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, dto.DeviceAuthorization{
+			DeviceCode:              authorization.DeviceCode,
+			UserCode:                authorization.UserCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(authorization.UserCode),
+			ExpiresIn:               int(authorization.ExpiresIn.Seconds()),
+			Interval:                int(authorization.Interval.Seconds()),
+		})
+		logrus.Info("device code issued")
+	}
+}
+
+// GetDeviceRequest godoc
+//
+// @Summary		Device verification
+// @Security 	Bearer
+// @Description	Страница подтверждения устройства: описание ожидающего запроса по user_code для вошедшего пользователя
+// @Tags		OAuth
+// @Produce		json
+// @Param		user_code	query	string	true	"код с экрана устройства"
+// @Success		200	{object} 	dto.DeviceRequest
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/oauth/device [get]
+func GetDeviceRequest(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("getting device request")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		client, code, err := as.GetDeviceRequest(restutils.GetTenant(c), aToken, c.Query("user_code"))
+		if err != nil {
+			deviceError(c, err)
+			return
+		}
+		csrfToken, err := restutils.SetCSRFToken(c)
+		if err != nil {
+			deviceError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, dto.DeviceRequest{
+			UserCode:   authsystem.FormatUserCode(code.UserCode),
+			ClientID:   client.ID,
+			ClientName: client.Name,
+			Scope:      code.Scope,
+			CSRFToken:  csrfToken,
+		})
+	}
+}
+
+// ApproveDevice godoc
+//
+// @Summary		Device approval
+// @Security 	Bearer
+// @Description	Подтверждение (consent=approve) или отклонение (consent=deny) запроса устройства вошедшим пользователем.
+// @Description	Если access токен передан в cookie, форма передаёт csrf_token из ответа GET /api/oauth/device
+// @Tags		OAuth
+// @Accept		x-www-form-urlencoded
+// @Param		user_code	formData	string	true	"код с экрана устройства"
+// @Param		consent		formData	string	true	"approve или deny"
+// @Param		csrf_token	formData	string	false	"токен из ответа GET /api/oauth/device, обязателен при access токене в cookie"
+// @Success		204
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Router		/api/oauth/device [post]
+func ApproveDevice(as authsystem.AuthSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logrus.Info("starting device approval")
+		aToken := restutils.GetAccessToken(c)
+		if aToken == "" {
+			logrus.Error(apperror.ErrUnauthorized)
+			restutils.Error(c, apperror.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if err := restutils.CheckCSRFToken(c); err != nil {
+			logrus.Warn(err)
+			restutils.Error(c, err.Error(), http.StatusForbidden)
+			return
+		}
+		err := as.ApproveDevice(restutils.GetTenant(c), aToken, c.PostForm("user_code"), c.PostForm("consent") == "approve")
+		if err != nil {
+			deviceError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		logrus.Info("device approval saved")
+	}
+}
+
+func deviceError(c *gin.Context, err error) {
+	logrus.Warn(err)
+	switch err {
+	case apperror.ErrInvalidUserCode:
+		restutils.Error(c, err.Error(), http.StatusBadRequest)
+	case apperror.ErrUnauthorized:
+		restutils.Error(c, err.Error(), http.StatusUnauthorized)
+	case apperror.ErrUserDisabled, apperror.ErrUserSuspended:
+		restutils.Error(c, err.Error(), http.StatusForbidden)
+	default:
+		logrus.Error(err)
+		restutils.Error(c, "", http.StatusInternalServerError)
 	}
 }
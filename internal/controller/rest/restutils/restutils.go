@@ -1,13 +1,20 @@
 package restutils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
 	"net/http"
-	"os"
-	"strconv"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/controller/rest/dto"
+	"github.com/sater-151/AuthSystem/internal/models"
 )
 
 func Error(c *gin.Context, err string, code int) {
@@ -15,14 +22,478 @@ func Error(c *gin.Context, err string, code int) {
 	return
 }
 
-func SetCookieTokens(c *gin.Context, accessT string, refreshT string) {
-	rtB64 := base64.StdEncoding.EncodeToString([]byte(refreshT))
-	timeExp, err := strconv.Atoi(os.Getenv("COOKIEEXPIRES"))
+// OAuthError ответ об ошибке в формате RFC 6749 для OAuth эндпоинтов
+func OAuthError(c *gin.Context, code int, oauthErr string, description string) {
+	if oauthErr == "invalid_client" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.AbortWithStatusJSON(code, dto.OAuthError{Error: oauthErr, ErrorDescription: description})
+}
+
+// ClientCredentials возвращает учётные данные клиента из заголовка Authorization (Basic) или из тела формы
+func ClientCredentials(c *gin.Context) (clientID string, clientSecret string) {
+	if clientID, clientSecret, ok := c.Request.BasicAuth(); ok {
+		// RFC 6749 2.3.1: перед кодированием в Basic значения кодируются как application/x-www-form-urlencoded
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if secret, err := url.QueryUnescape(clientSecret); err == nil {
+			clientSecret = secret
+		}
+		return clientID, clientSecret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+// AuthorizationRequest читает параметры запроса авторизации из query или из тела формы
+func AuthorizationRequest(c *gin.Context) models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		ResponseType:        c.Request.FormValue("response_type"),
+		ClientID:            c.Request.FormValue("client_id"),
+		RedirectURI:         c.Request.FormValue("redirect_uri"),
+		Scope:               c.Request.FormValue("scope"),
+		State:               c.Request.FormValue("state"),
+		CodeChallenge:       c.Request.FormValue("code_challenge"),
+		CodeChallengeMethod: c.Request.FormValue("code_challenge_method"),
+		Nonce:               c.Request.FormValue("nonce"),
+	}
+}
+
+// AuthorizationRedirect перенаправляет пользователя на redirect_uri клиента, добавляя params и state к query
+func AuthorizationRedirect(c *gin.Context, redirectURI string, state string, params url.Values) {
+	location, err := url.Parse(redirectURI)
 	if err != nil {
-		logrus.Error(err)
-		Error(c, err.Error(), http.StatusInternalServerError)
+		Error(c, "", http.StatusInternalServerError)
+		return
+	}
+	query := location.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	location.RawQuery = query.Encode()
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, location.String())
+	c.Abort()
+}
+
+// BearerToken возвращает access токен из заголовка Authorization: Bearer (RFC 6750 2.1)
+func BearerToken(c *gin.Context) string {
+	return authorizationToken(c, "Bearer")
+}
+
+// DPoPToken возвращает привязанный к ключу access токен из заголовка Authorization: DPoP (RFC 9449 7.1)
+func DPoPToken(c *gin.Context) string {
+	return authorizationToken(c, "DPoP")
+}
+
+func authorizationToken(c *gin.Context, scheme string) string {
+	got, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(got, scheme) {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// DPoPProof возвращает DPoP proof из заголовка DPoP. Запрос с несколькими заголовками DPoP не допускается (RFC 9449 4.3)
+func DPoPProof(c *gin.Context) (proof string, ok bool) {
+	values := c.Request.Header.Values("DPoP")
+	if len(values) > 1 {
+		return "", false
+	}
+	return c.GetHeader("DPoP"), true
+}
+
+// SetDPoPNonce передаёт клиенту nonce для следующих DPoP proof
+func SetDPoPNonce(c *gin.Context, nonce string) {
+	if nonce != "" {
+		c.Header("DPoP-Nonce", nonce)
+	}
+}
+
+// DPoPError отклоняет запрос к ресурсу с access токеном, привязанным к ключу DPoP (RFC 9449 7.1)
+func DPoPError(c *gin.Context, dpopErr string, nonce string, algorithms []string) {
+	SetDPoPNonce(c, nonce)
+	c.Header("WWW-Authenticate", fmt.Sprintf(`DPoP error="%s", algs="%s"`, dpopErr, strings.Join(algorithms, " ")))
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// BaseURL возвращает адрес сервиса из JWT_ISSUER или, если он не задан, из адреса запроса
+func BaseURL(c *gin.Context, issuer string) string {
+	if issuer == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		issuer = scheme + "://" + c.Request.Host
+	}
+	return strings.TrimSuffix(issuer, "/")
+}
+
+// RequestURL адрес запроса без query, с которым сравнивается htu DPoP proof
+func RequestURL(c *gin.Context, issuer string) string {
+	return BaseURL(c, issuer) + c.Request.URL.Path
+}
+
+// AccessToken возвращает access токен запроса: из заголовка Authorization (Bearer или DPoP), если токены
+// принимаются в заголовках, иначе из cookie access токена. transport сообщает, откуда получен токен: header или cookie
+func AccessToken(c *gin.Context) (aToken string, transport string) {
+	transportConfig := GetTransport(c)
+	if transportConfig.Header() {
+		if aToken = BearerToken(c); aToken != "" {
+			return aToken, config.TransportHeader
+		}
+		if aToken = DPoPToken(c); aToken != "" {
+			return aToken, config.TransportHeader
+		}
+	}
+	if transportConfig.Cookie() {
+		if aToken = AccessCookie(c); aToken != "" {
+			return aToken, config.TransportCookie
+		}
+	}
+	return "", ""
+}
+
+// RefreshTokenWithBody возвращает refresh токен для /api/refresh: кроме заголовка rt и cookie, токен принимается
+// в поле refresh_token JSON тела, если токены принимаются в заголовках
+func RefreshTokenWithBody(c *gin.Context) (rToken string, transport string, err error) {
+	if GetTransport(c).Header() && c.GetHeader("rt") == "" && c.ContentType() == "application/json" && c.Request.ContentLength != 0 {
+		var req dto.RefreshRequest
+		if err = c.ShouldBindJSON(&req); err != nil {
+			return "", config.TransportHeader, apperror.ErrIncorrectRefreshToken
+		}
+		if req.RefreshToken != "" {
+			return req.RefreshToken, config.TransportHeader, nil
+		}
+	}
+	return RefreshToken(c)
+}
+
+// RefreshToken возвращает refresh токен из заголовка rt, если токены принимаются в заголовках, иначе из cookie
+// refresh токена (base64). Тело запроса не читается, чтобы оно осталось обработчику. transport сообщает,
+// откуда получен токен: header или cookie
+func RefreshToken(c *gin.Context) (rToken string, transport string, err error) {
+	transportConfig := GetTransport(c)
+	if transportConfig.Header() {
+		if rToken = c.GetHeader("rt"); rToken != "" {
+			return rToken, config.TransportHeader, nil
+		}
+	}
+	if transportConfig.Cookie() {
+		rtCookie, err := c.Request.Cookie(transportConfig.Cookies.RefreshName())
+		if err != nil {
+			return "", "", apperror.ErrUnauthorized
+		}
+		rTokenCookie, err := url.QueryUnescape(rtCookie.Value)
+		if err != nil {
+			return "", config.TransportCookie, apperror.ErrIncorrectRefreshToken
+		}
+		rTokenBase64, err := base64.StdEncoding.DecodeString(rTokenCookie)
+		if err != nil {
+			return "", config.TransportCookie, apperror.ErrIncorrectRefreshToken
+		}
+		return string(rTokenBase64), config.TransportCookie, nil
+	}
+	return "", "", apperror.ErrUnauthorized
+}
+
+// Типы клиентов в заголовке X-Client-Type. Если разрешены оба способа передачи токенов, браузер
+// получает токены только в cookie, нативное приложение только в теле ответа
+const (
+	ClientTypeBrowser = "browser"
+	ClientTypeNative  = "native"
+)
+
+// SendTokens выдаёт токены тем же способом, которым запрос передал свои токены (requestTransport).
+// Для запроса без токенов (вход) при способе both токены выдаются и в cookie, и в теле ответа, если тип клиента не указан.
+// Тело ответа со сроками действия токенов и сессией возвращается и при выдаче токенов в cookie, если клиент
+// принимает application/json, но сами токены в него не включаются
+func SendTokens(c *gin.Context, tokens models.Tokens, requestTransport string) {
+	transportConfig := GetTransport(c)
+	cookie := transportConfig.Cookie() && requestTransport != config.TransportHeader
+	body := transportConfig.Header() && requestTransport != config.TransportCookie
+	switch c.GetHeader("X-Client-Type") {
+	case ClientTypeBrowser:
+		body = body && !cookie
+	case ClientTypeNative:
+		cookie = cookie && !body
+	}
+	if cookie {
+		SetCookieTokens(c, GetTenant(c), tokens.AccessToken, tokens.RefreshToken)
+	}
+	if !body && !acceptsJSON(c) {
+		c.Status(http.StatusCreated)
 		return
 	}
-	c.SetCookie("at", accessT, timeExp, "/", "localhost", false, true)
-	c.SetCookie("rt", rtB64, timeExp, "/", "localhost", true, true)
+	resp := TokensToDTO(tokens)
+	if !body {
+		resp.AccessToken, resp.RefreshToken = "", ""
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, resp)
+}
+
+// acceptsJSON сообщает, что клиент явно указал application/json в заголовке Accept
+func acceptsJSON(c *gin.Context) bool {
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCookieTokens выдаёт токены в cookie по политике TransportConfig.Cookies. Cookie access токена по умолчанию
+// живёт столько же, сколько refresh токен, потому что истёкший access токен нужен для обновления
+func SetCookieTokens(c *gin.Context, tenant models.Tenant, accessT string, refreshT string) {
+	cookies := GetTransport(c).Cookies
+	rtB64 := base64.StdEncoding.EncodeToString([]byte(refreshT))
+	accessMaxAge, refreshMaxAge := cookies.AccessMaxAge, cookies.RefreshMaxAge
+	if accessMaxAge == 0 {
+		accessMaxAge = tenant.RefreshTTL
+	}
+	if refreshMaxAge == 0 {
+		refreshMaxAge = tenant.RefreshTTL
+	}
+	c.SetSameSite(cookies.SameSite)
+	c.SetCookie(cookies.AccessName(), accessT, int(accessMaxAge.Seconds()), cookies.AccessPath, cookies.Domain, cookies.Secure, true)
+	c.SetCookie(cookies.RefreshName(), rtB64, int(refreshMaxAge.Seconds()), refreshCookiePath(c, cookies), cookies.Domain, cookies.Secure, true)
+}
+
+// HasRefreshCookie сообщает, что запрос передал cookie refresh токена. Cookie, ограниченная эндпоинтом
+// обновления, в запросы к другим эндпоинтам не передаётся
+func HasRefreshCookie(c *gin.Context) bool {
+	_, err := c.Request.Cookie(GetTransport(c).Cookies.RefreshName())
+	return err == nil
+}
+
+// AccessCookie возвращает access токен из cookie
+func AccessCookie(c *gin.Context) string {
+	atCookie, err := c.Request.Cookie(GetTransport(c).Cookies.AccessName())
+	if err != nil {
+		return ""
+	}
+	return atCookie.Value
+}
+
+// csrfTTL время, за которое пользователь должен отправить форму согласия
+const csrfTTL = 10 * time.Minute
+
+// csrfCookieName имя cookie токена CSRF форм согласия
+func csrfCookieName(cookies config.CookieConfig) string {
+	if cookies.HostPrefix {
+		return "__Host-csrf"
+	}
+	return "csrf"
+}
+
+// SetCSRFToken выдаёт новый токен CSRF формы согласия. Токен возвращается в теле ответа и сохраняется в cookie
+// с SameSite=Strict независимо от COOKIE_SAMESITE, форма передаёт его в поле csrf_token
+func SetCSRFToken(c *gin.Context) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	cookies := GetTransport(c).Cookies
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName(cookies), token, int(csrfTTL.Seconds()), "/", "", cookies.Secure, true)
+	return token, nil
+}
+
+// CheckCSRFToken сверяет поле csrf_token формы с cookie, выданной SetCSRFToken, и удаляет cookie.
+// Чужой сайт может отправить форму только с cookie access токена, запросу с токеном в заголовке CSRF токен не нужен
+func CheckCSRFToken(c *gin.Context) error {
+	if _, transport := AccessToken(c); transport != config.TransportCookie {
+		return nil
+	}
+	cookies := GetTransport(c).Cookies
+	csrfCookie, err := c.Request.Cookie(csrfCookieName(cookies))
+	if err != nil {
+		return apperror.ErrInvalidCSRFToken
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName(cookies), "", -1, "/", "", cookies.Secure, true)
+	token := c.PostForm("csrf_token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(csrfCookie.Value)) != 1 {
+		return apperror.ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// refreshCookiePath путь cookie refresh токена: COOKIE_REFRESH_PATH или эндпоинт /refresh группы запроса
+func refreshCookiePath(c *gin.Context, cookies config.CookieConfig) string {
+	if cookies.RefreshPath != "" {
+		return cookies.RefreshPath
+	}
+	if tenant := c.Param("tenant"); tenant != "" {
+		return "/api/t/" + url.PathEscape(tenant) + "/refresh"
+	}
+	return "/api/refresh"
+}
+
+const transportKey = "transport"
+
+func SetTransport(c *gin.Context, transport config.TransportConfig) {
+	c.Set(transportKey, transport)
+}
+
+// GetTransport возвращает способ передачи токенов, заданный middleware.TokenTransport, по умолчанию cookie
+func GetTransport(c *gin.Context) config.TransportConfig {
+	transport, _ := c.Get(transportKey)
+	t, _ := transport.(config.TransportConfig)
+	return t
+}
+
+const accessTokenKey = "access_token"
+
+func SetAccessToken(c *gin.Context, aToken string) {
+	c.Set(accessTokenKey, aToken)
+}
+
+// GetAccessToken возвращает access токен, проверенный middleware.CheckAuthorization. После обновления
+// токенов в middleware возвращается новый токен
+func GetAccessToken(c *gin.Context) string {
+	return c.GetString(accessTokenKey)
+}
+
+const tenantKey = "tenant"
+
+func SetTenant(c *gin.Context, tenant models.Tenant) {
+	c.Set(tenantKey, tenant)
+}
+
+const clientKey = "client"
+
+func SetClient(c *gin.Context, client models.Client) {
+	c.Set(clientKey, client)
+}
+
+// GetClient возвращает клиента, определённого middleware.AuthenticateClient
+func GetClient(c *gin.Context) models.Client {
+	client, _ := c.Get(clientKey)
+	cl, _ := client.(models.Client)
+	return cl
+}
+
+const actorKey = "actor"
+
+func SetActor(c *gin.Context, actor string) {
+	c.Set(actorKey, actor)
+}
+
+// GetActor возвращает guid сотрудника, если запрос выполнен через токен имперсонации, иначе пустую строку
+func GetActor(c *gin.Context) string {
+	return c.GetString(actorKey)
+}
+
+const scopeKey = "scope"
+
+func SetScope(c *gin.Context, scope string) {
+	c.Set(scopeKey, scope)
+}
+
+// GetScope возвращает scope access токена, проверенного middleware.CheckAuthorization
+func GetScope(c *gin.Context) string {
+	return c.GetString(scopeKey)
+}
+
+// GetTenant возвращает тенант, определённый middleware.ResolveTenant
+func GetTenant(c *gin.Context) models.Tenant {
+	tenant, _ := c.Get(tenantKey)
+	t, _ := tenant.(models.Tenant)
+	return t
+}
+
+func ProfileToDTO(profile models.Profile) dto.Profile {
+	return dto.Profile{
+		Guid:        profile.GUID,
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		Roles:       profile.Roles,
+		MFAEnabled:  profile.MFAEnabled,
+		Session: dto.Session{
+			UserAgent:   profile.Session.UserAgent,
+			IP:          profile.Session.IP,
+			RefreshedAt: profile.Session.RefreshedAt,
+		},
+	}
+}
+
+func IntrospectionToDTO(introspection models.Introspection, client models.Client) dto.Introspection {
+	if !introspection.Active {
+		return dto.Introspection{Active: false}
+	}
+	resp := dto.Introspection{
+		Active:    true,
+		TokenType: introspection.TokenType,
+		Sub:       introspection.Subject,
+		Tenant:    introspection.Tenant,
+		Sid:       introspection.SessionID,
+		Iss:       introspection.Issuer,
+		Aud:       introspection.Audience,
+		Scope:     introspection.Scope,
+		ClientID:  introspection.ClientID,
+	}
+	if resp.ClientID == "" {
+		resp.ClientID = client.ID
+	}
+	if introspection.Actor != "" {
+		resp.Act = &dto.Actor{Sub: introspection.Actor}
+	}
+	if introspection.DPoPJKT != "" {
+		resp.Cnf = &dto.Confirmation{JKT: introspection.DPoPJKT}
+	}
+	if !introspection.IssuedAt.IsZero() {
+		resp.Iat = introspection.IssuedAt.Unix()
+	}
+	if !introspection.ExpiresAt.IsZero() {
+		resp.Exp = introspection.ExpiresAt.Unix()
+	}
+	return resp
+}
+
+func TokensToDTO(tokens models.Tokens) dto.TokenResponse {
+	tokenType := tokens.TokenType
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+	return dto.TokenResponse{
+		AccessToken:      tokens.AccessToken,
+		TokenType:        tokenType,
+		ExpiresIn:        int(tokens.ExpiresIn.Seconds()),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresIn: int(tokens.RefreshExpiresIn.Seconds()),
+		SessionID:        tokens.SessionID,
+		IDToken:          tokens.IDToken,
+		Scope:            tokens.Scope,
+		IssuedTokenType:  tokens.IssuedTokenType,
+	}
+}
+
+func ClientToDTO(client models.Client, secret string) dto.Client {
+	return dto.Client{
+		ClientID:          client.ID,
+		ClientSecret:      secret,
+		Name:              client.Name,
+		RedirectURIs:      client.RedirectURIs,
+		Confidential:      client.Confidential,
+		FirstParty:        client.FirstParty,
+		ClientCredentials: client.ClientCredentials,
+		Scopes:            client.Scopes,
+		ExchangeAudiences: client.ExchangeAudiences,
+	}
+}
+
+func UserInfoToDTO(profile models.Profile) dto.UserInfo {
+	return dto.UserInfo{
+		Sub:   profile.GUID,
+		Name:  profile.DisplayName,
+		Email: profile.Email,
+		Roles: profile.Roles,
+	}
 }
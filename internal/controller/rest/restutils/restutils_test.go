@@ -0,0 +1,206 @@
+package restutils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/controller/rest/dto"
+	"github.com/sater-151/AuthSystem/internal/models"
+)
+
+func newTestContext(transport config.TransportConfig) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/login", nil)
+	SetTransport(c, transport)
+	SetTenant(c, models.Tenant{ID: models.DefaultTenantID, RefreshTTL: time.Hour})
+	return c, recorder
+}
+
+func responseCookies(recorder *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range recorder.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestSetCookieTokensPolicy(t *testing.T) {
+	c, recorder := newTestContext(config.TransportConfig{Mode: config.TransportCookie, Cookies: config.CookieConfig{
+		AccessPath:   "/",
+		Secure:       true,
+		SameSite:     http.SameSiteStrictMode,
+		HostPrefix:   true,
+		AccessMaxAge: time.Minute,
+	}})
+	c.Params = gin.Params{{Key: "tenant", Value: "acme"}}
+	SetCookieTokens(c, GetTenant(c), "access", "refresh")
+
+	cookies := responseCookies(recorder)
+	access, refresh := cookies["__Host-at"], cookies["__Secure-rt"]
+	if access == nil || refresh == nil {
+		t.Fatalf("cookies = %v", recorder.Header().Values("Set-Cookie"))
+	}
+	if access.Value != "access" || access.Path != "/" || access.MaxAge != 60 {
+		t.Fatalf("access cookie = %+v", access)
+	}
+	// refresh токен передаётся только эндпоинту обновления тенанта и живёт столько же, сколько refresh токен
+	if refresh.Value != url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("refresh"))) || refresh.Path != "/api/t/acme/refresh" || refresh.MaxAge != 3600 {
+		t.Fatalf("refresh cookie = %+v", refresh)
+	}
+	for _, cookie := range []*http.Cookie{access, refresh} {
+		if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Domain != "" {
+			t.Fatalf("cookie %s attributes = %+v", cookie.Name, cookie)
+		}
+	}
+}
+
+func TestSetCookieTokensDefaults(t *testing.T) {
+	c, recorder := newTestContext(config.TransportConfig{Mode: config.TransportCookie, Cookies: config.CookieConfig{
+		Domain:      "example.com",
+		AccessPath:  "/",
+		RefreshPath: "/api",
+		SameSite:    http.SameSiteLaxMode,
+	}})
+	SetCookieTokens(c, GetTenant(c), "access", "refresh")
+
+	cookies := responseCookies(recorder)
+	access, refresh := cookies["at"], cookies["rt"]
+	if access == nil || refresh == nil {
+		t.Fatalf("cookies = %v", recorder.Header().Values("Set-Cookie"))
+	}
+	// истёкший access токен нужен для обновления, поэтому cookie живёт столько же, сколько refresh токен
+	if access.MaxAge != 3600 || refresh.MaxAge != 3600 || refresh.Path != "/api" || access.Domain != "example.com" || access.Secure {
+		t.Fatalf("access cookie = %+v, refresh cookie = %+v", access, refresh)
+	}
+}
+
+func TestSendTokensTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             string
+		requestTransport string
+		clientType       string
+		wantCookie       bool
+		wantBody         bool
+	}{
+		{"cookie", config.TransportCookie, "", "", true, false},
+		{"header", config.TransportHeader, "", "", false, true},
+		{"both login", config.TransportBoth, "", "", true, true},
+		{"both browser", config.TransportBoth, "", ClientTypeBrowser, true, false},
+		{"both native", config.TransportBoth, "", ClientTypeNative, false, true},
+		{"both refresh by cookie", config.TransportBoth, config.TransportCookie, "", true, false},
+		{"both refresh by header", config.TransportBoth, config.TransportHeader, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestContext(config.TransportConfig{Mode: tt.mode, Cookies: config.CookieConfig{AccessPath: "/", Secure: true, SameSite: http.SameSiteLaxMode}})
+			c.Request.Header.Set("Accept", "application/json")
+			c.Request.Header.Set("X-Client-Type", tt.clientType)
+			SendTokens(c, models.Tokens{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: time.Minute}, tt.requestTransport)
+
+			if recorder.Code != http.StatusCreated {
+				t.Fatalf("status = %d", recorder.Code)
+			}
+			_, cookie := responseCookies(recorder)["at"]
+			if cookie != tt.wantCookie {
+				t.Fatalf("cookie = %v, want %v", cookie, tt.wantCookie)
+			}
+			var resp dto.TokenResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if body := resp.AccessToken != "" && resp.RefreshToken != ""; body != tt.wantBody {
+				t.Fatalf("tokens in body = %v, want %v", body, tt.wantBody)
+			}
+			// сроки действия возвращаются и без токенов в теле
+			if resp.ExpiresIn != 60 || recorder.Header().Get("Cache-Control") != "no-store" {
+				t.Fatalf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenKeepsBody(t *testing.T) {
+	const body = `{"refresh_token":"from-body","display_name":"name"}`
+	newRequest := func() *gin.Context {
+		c, _ := newTestContext(config.TransportConfig{Mode: config.TransportBoth, Cookies: config.CookieConfig{AccessPath: "/"}})
+		c.Request = httptest.NewRequest(http.MethodPatch, "/api/auth/me", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.AddCookie(&http.Cookie{Name: "rt", Value: base64.StdEncoding.EncodeToString([]byte("from-cookie"))})
+		return c
+	}
+
+	// middleware получает токен из cookie, тело остаётся обработчику
+	c := newRequest()
+	rToken, transport, err := RefreshToken(c)
+	if err != nil || rToken != "from-cookie" || transport != config.TransportCookie {
+		t.Fatalf("RefreshToken() = %q, %q, %v", rToken, transport, err)
+	}
+	if read, err := io.ReadAll(c.Request.Body); err != nil || string(read) != body {
+		t.Fatalf("body = %q, %v", read, err)
+	}
+
+	c = newRequest()
+	rToken, transport, err = RefreshTokenWithBody(c)
+	if err != nil || rToken != "from-body" || transport != config.TransportHeader {
+		t.Fatalf("RefreshTokenWithBody() = %q, %q, %v", rToken, transport, err)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	transport := config.TransportConfig{Mode: config.TransportBoth, Cookies: config.CookieConfig{AccessPath: "/", Secure: true, SameSite: http.SameSiteNoneMode, HostPrefix: true}}
+	c, recorder := newTestContext(transport)
+	token, err := SetCSRFToken(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := responseCookies(recorder)["__Host-csrf"]
+	if cookie == nil || cookie.Value != token || cookie.SameSite != http.SameSiteStrictMode || !cookie.Secure || !cookie.HttpOnly || cookie.Path != "/" {
+		t.Fatalf("csrf cookie = %+v", cookie)
+	}
+
+	tests := []struct {
+		name      string
+		header    bool
+		cookie    string
+		formToken string
+		wantErr   error
+	}{
+		{"cookie session", false, token, token, nil},
+		{"without form token", false, token, "", apperror.ErrInvalidCSRFToken},
+		{"another token", false, token, token + "x", apperror.ErrInvalidCSRFToken},
+		{"without cookie", false, "", token, apperror.ErrInvalidCSRFToken},
+		// чужой сайт не может передать заголовок Authorization
+		{"bearer", true, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestContext(transport)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/oauth/authorize", strings.NewReader(url.Values{"csrf_token": {tt.formToken}}.Encode()))
+			c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header {
+				c.Request.Header.Set("Authorization", "Bearer access")
+			} else {
+				c.Request.AddCookie(&http.Cookie{Name: "__Host-at", Value: "access"})
+			}
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: "__Host-csrf", Value: tt.cookie})
+			}
+			if err := CheckCSRFToken(c); err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,29 +1,73 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/sater-151/AuthSystem/internal/apperror"
 	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
 	"github.com/sirupsen/logrus"
 )
 
 type Postgresql interface {
 	MigrationUp() (err error)
 	MigrationDown() (err error)
-	LoginDB(guid string, rt string, userAgent string, ip string) (err error)
-	UpdateRT(guid string, rt string) (err error)
+	LoginDB(tenantID string, guid string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) (sessionID string, err error)
 	GetBcrypt(rToken string) (rTokenBcrypt string, err error)
-	GetToken(guid string) (rToken string, err error)
-	DeleteUser(guid string) (err error)
-	GetUserInfo(guid string) (userAgent string, userIp string, err error)
+	GetUserStatus(tenantID string, guid string) (status string, suspendedUntil sql.NullTime, err error)
+	GetProfile(tenantID string, guid string) (profile models.Profile, err error)
+	UpdateProfile(tenantID string, guid string, email *string, displayName *string) (err error)
+	GrantRole(tenantID string, guid string, role string) (err error)
+	RevokeRole(tenantID string, guid string, role string) (err error)
+	GetTenant(tenantID string) (tenant models.Tenant, err error)
+	GetTenantByHost(host string) (tenant models.Tenant, err error)
+	HasTenantSecrets() (exists bool, err error)
+	AddImpersonationAudit(audit models.ImpersonationAudit) (err error)
+	GetSigningKeys() (signingKeys []keys.StoredKey, err error)
+	AddSigningKey(key keys.StoredKey) (err error)
+	DeleteSigningKey(kid string) (err error)
+	TryLockRotation() (unlock func(), err error)
+	GetClient(tenantID string, clientID string, secret string) (client models.Client, err error)
+	GetPublicClient(tenantID string, clientID string) (client models.Client, err error)
+	GetClientByID(tenantID string, clientID string) (client models.Client, err error)
+	AddClient(client models.Client, secret string) (err error)
+	SetAuthenticatedAt(tenantID string, guid string) (err error)
+	AddAuthorizationCode(code models.AuthorizationCode) (err error)
+	UseAuthorizationCode(tenantID string, codeHash string) (code models.AuthorizationCode, err error)
+	DeleteExpiredAuthorizationCodes() (err error)
+	AddDeviceCode(code models.DeviceCode) (err error)
+	GetDeviceCodeByUserCode(tenantID string, userCode string) (code models.DeviceCode, err error)
+	SetDeviceCodeDecision(tenantID string, userCode string, guid string, status string) (err error)
+	PollDeviceCode(tenantID string, deviceCodeHash string) (code models.DeviceCode, err error)
+	SlowDownDeviceCode(tenantID string, deviceCodeHash string, interval time.Duration) (err error)
+	UseDeviceCode(tenantID string, deviceCodeHash string) (err error)
+	DeleteExpiredDeviceCodes() (err error)
+	GetConsent(tenantID string, clientID string, guid string) (scope string, err error)
+	SaveConsent(tenantID string, clientID string, guid string, scope string) (err error)
+	RevokeSession(tenantID string, guid string, sessionID string) (accessJTI string, accessExpiresAt time.Time, err error)
+	AddRevokedToken(tenantID string, jti string, expiresAt time.Time) (err error)
+	GetRevokedTokens() (revoked map[string]time.Time, err error)
+	DeleteExpiredRevokedTokens() (err error)
+	UseDPoPProof(proofHash string, expiresAt time.Time) (err error)
+	GetSession(tenantID string, guid string) (session models.Session, err error)
+	GetSessionByRT(tenantID string, rTokenBcrypt string) (guid string, session models.Session, err error)
+	GetSessionByRefreshHash(tenantID string, sessionID string, refreshHash string) (guid string, session models.Session, err error)
+	AddClientSession(tenantID string, guid string, clientID string, authenticatedAt time.Time) (sessionID string, err error)
+	UpdateClientSession(tenantID string, sessionID string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) (err error)
+	GetClientSession(tenantID string, sessionID string) (guid string, session models.Session, err error)
+	GetClientSessionByRefreshHash(tenantID string, sessionID string, refreshHash string) (guid string, session models.Session, err error)
+	RevokeClientSession(tenantID string, guid string, sessionID string) (accessJTI string, accessExpiresAt time.Time, err error)
+	RevokeClientSessions(tenantID string, guid string) (accessTokens map[string]time.Time, err error)
+	DeleteExpiredClientSessions(tenantID string, refreshTTL time.Duration) (err error)
 }
 
 type PostgresqlManager struct {
@@ -49,12 +93,7 @@ func Open(config config.PostgresqlConfig) (*PostgresqlManager, func() error, err
 	if err = db.Ping(); err != nil {
 		return nil, nil, err
 	}
-	DB := &PostgresqlManager{db: db}
-	var ok bool
-	DB.hash, ok = os.LookupEnv("BCRYPYHASH")
-	if !ok {
-		logrus.Warn("bcrypt hash isn't found")
-	}
+	DB := &PostgresqlManager{db: db, hash: config.BcryptHash}
 	return DB, db.Close, nil
 }
 
@@ -90,9 +129,62 @@ func (db *PostgresqlManager) MigrationDown() error {
 	return nil
 }
 
-func (db *PostgresqlManager) LoginDB(guid string, rt string, userAgent string, ip string) error {
+// LoginDB сохраняет сессию и возвращает её идентификатор. refreshHash хеш секрета refresh токена второй версии,
+// он заменяет bcrypt refresh токена первой версии. jkt отпечаток ключа DPoP, к которому привязаны токены сессии, пустой для bearer токенов
+func (db *PostgresqlManager) LoginDB(tenantID string, guid string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) (string, error) {
 	logrus.Debug("set refresh token")
-	res, err := db.db.Exec("UPDATE users_auth SET refresh_t=crypt($1, $2), user_agent=$3, user_ip=$4 WHERE user_id=$5 RETURNING refresh_t", rt, db.hash, userAgent, ip, guid)
+	var sessionID string
+	err := db.db.QueryRow("UPDATE users_auth SET refresh_t=$1, user_agent=$2, user_ip=$3, refreshed_at=now(), access_jti=$4, access_expires_at=$5, scope=$6, dpop_jkt=NULLIF($7, '') WHERE tenant_id=$8 AND user_id=$9 RETURNING id",
+		refreshHash, userAgent, ip, accessJTI, accessExpiresAt, scope, jkt, tenantID, guid).Scan(&sessionID)
+	if err != nil {
+		return "", err
+	}
+	logrus.Debug("refresh token has beeb set")
+	return sessionID, nil
+}
+
+func (db *PostgresqlManager) GetBcrypt(rToken string) (string, error) {
+	var rTokenBcrypt sql.NullString
+	err := db.db.QueryRow("SELECT crypt($1, $2)", rToken, db.hash).Scan(&rTokenBcrypt)
+	if err != nil {
+		return "", err
+	}
+	return rTokenBcrypt.String, nil
+}
+
+func (db *PostgresqlManager) GetUserStatus(tenantID string, guid string) (string, sql.NullTime, error) {
+	var status string
+	var suspendedUntil sql.NullTime
+	err := db.db.QueryRow("SELECT status, suspended_until FROM users_auth WHERE tenant_id=$1 AND user_id=$2", tenantID, guid).Scan(&status, &suspendedUntil)
+	if err != nil {
+		return "", suspendedUntil, err
+	}
+	return status, suspendedUntil, nil
+}
+
+func (db *PostgresqlManager) GetProfile(tenantID string, guid string) (models.Profile, error) {
+	var profile models.Profile
+	var email, displayName, roles, userAgent, userIp sql.NullString
+	var refreshedAt sql.NullTime
+	err := db.db.QueryRow("SELECT user_id, email, display_name, array_to_string(roles, ','), mfa_enabled, id, user_agent, user_ip, refreshed_at FROM users_auth WHERE tenant_id=$1 AND user_id=$2", tenantID, guid).
+		Scan(&profile.GUID, &email, &displayName, &roles, &profile.MFAEnabled, &profile.Session.ID, &userAgent, &userIp, &refreshedAt)
+	if err != nil {
+		return profile, err
+	}
+	profile.Email = email.String
+	profile.DisplayName = displayName.String
+	profile.Roles = []string{}
+	if roles.String != "" {
+		profile.Roles = strings.Split(roles.String, ",")
+	}
+	profile.Session.UserAgent = userAgent.String
+	profile.Session.IP = userIp.String
+	profile.Session.RefreshedAt = refreshedAt.Time
+	return profile, nil
+}
+
+func (db *PostgresqlManager) UpdateProfile(tenantID string, guid string, email *string, displayName *string) error {
+	res, err := db.db.Exec("UPDATE users_auth SET email=COALESCE($1, email), display_name=COALESCE($2, display_name) WHERE tenant_id=$3 AND user_id=$4", email, displayName, tenantID, guid)
 	if err != nil {
 		return err
 	}
@@ -103,13 +195,20 @@ func (db *PostgresqlManager) LoginDB(guid string, rt string, userAgent string, i
 	if rows == 0 {
 		return sql.ErrNoRows
 	}
-	logrus.Debug("refresh token has beeb set")
 	return nil
 }
 
-func (db *PostgresqlManager) UpdateRT(guid string, rt string) error {
-	logrus.Debug("set refresh token")
-	res, err := db.db.Exec("UPDATE users_auth SET refresh_t=crypt($1, $2) WHERE user_id=$3 RETURNING refresh_t", rt, db.hash, guid)
+// GrantRole добавляет роль пользователю, повторное назначение роли не меняет список ролей
+func (db *PostgresqlManager) GrantRole(tenantID string, guid string, role string) error {
+	return db.updateRoles("UPDATE users_auth SET roles = CASE WHEN $1 = ANY(roles) THEN roles ELSE array_append(roles, $1) END WHERE tenant_id=$2 AND user_id=$3", role, tenantID, guid)
+}
+
+func (db *PostgresqlManager) RevokeRole(tenantID string, guid string, role string) error {
+	return db.updateRoles("UPDATE users_auth SET roles = array_remove(roles, $1) WHERE tenant_id=$2 AND user_id=$3", role, tenantID, guid)
+}
+
+func (db *PostgresqlManager) updateRoles(query string, role string, tenantID string, guid string) error {
+	res, err := db.db.Exec(query, role, tenantID, guid)
 	if err != nil {
 		return err
 	}
@@ -120,49 +219,453 @@ func (db *PostgresqlManager) UpdateRT(guid string, rt string) error {
 	if rows == 0 {
 		return sql.ErrNoRows
 	}
-	logrus.Debug("refresh token has beeb set")
 	return nil
 }
 
-func (db *PostgresqlManager) GetBcrypt(rToken string) (string, error) {
-	var rTokenBcrypt sql.NullString
-	err := db.db.QueryRow("SELECT crypt($1, $2)", rToken, db.hash).Scan(&rTokenBcrypt)
+func (db *PostgresqlManager) GetTenant(tenantID string) (models.Tenant, error) {
+	return db.getTenant("SELECT id, name, host, jwt_secret, at_expires, rt_expires FROM tenants WHERE id=$1", tenantID)
+}
+
+func (db *PostgresqlManager) GetTenantByHost(host string) (models.Tenant, error) {
+	return db.getTenant("SELECT id, name, host, jwt_secret, at_expires, rt_expires FROM tenants WHERE host=$1", host)
+}
+
+// HasTenantSecrets сообщает, что хотя бы у одного тенанта задан собственный ключ подписи
+func (db *PostgresqlManager) HasTenantSecrets() (bool, error) {
+	var exists bool
+	err := db.db.QueryRow("SELECT EXISTS(SELECT 1 FROM tenants WHERE jwt_secret IS NOT NULL AND jwt_secret <> '')").Scan(&exists)
+	return exists, err
+}
+
+func (db *PostgresqlManager) getTenant(query string, arg string) (models.Tenant, error) {
+	var tenant models.Tenant
+	var host, secret sql.NullString
+	var atExpires, rtExpires sql.NullInt64
+	err := db.db.QueryRow(query, arg).Scan(&tenant.ID, &tenant.Name, &host, &secret, &atExpires, &rtExpires)
 	if err != nil {
-		return "", err
+		return tenant, err
 	}
-	return rTokenBcrypt.String, nil
+	tenant.Host = host.String
+	if secret.Valid {
+		tenant.Secret = []byte(secret.String)
+	}
+	tenant.AccessTTL = time.Second * time.Duration(atExpires.Int64)
+	tenant.RefreshTTL = time.Second * time.Duration(rtExpires.Int64)
+	return tenant, nil
+}
+
+func (db *PostgresqlManager) AddImpersonationAudit(audit models.ImpersonationAudit) error {
+	_, err := db.db.Exec("INSERT INTO impersonation_audit (tenant_id, actor_id, target_id, reason, actor_ip, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		audit.TenantID, audit.ActorID, audit.TargetID, audit.Reason, audit.ActorIP, audit.ExpiresAt)
+	return err
+}
+
+func (db *PostgresqlManager) GetSigningKeys() ([]keys.StoredKey, error) {
+	rows, err := db.db.Query("SELECT kid, alg, material, created_at, activates_at FROM signing_keys")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var signingKeys []keys.StoredKey
+	for rows.Next() {
+		var key keys.StoredKey
+		if err = rows.Scan(&key.ID, &key.Alg, &key.Material, &key.CreatedAt, &key.ActivatesAt); err != nil {
+			return nil, err
+		}
+		signingKeys = append(signingKeys, key)
+	}
+	return signingKeys, rows.Err()
+}
+
+func (db *PostgresqlManager) AddSigningKey(key keys.StoredKey) error {
+	_, err := db.db.Exec("INSERT INTO signing_keys (kid, alg, material, created_at, activates_at) VALUES ($1, $2, $3, $4, $5)",
+		key.ID, key.Alg, key.Material, key.CreatedAt, key.ActivatesAt)
+	return err
+}
+
+func (db *PostgresqlManager) DeleteSigningKey(kid string) error {
+	_, err := db.db.Exec("DELETE FROM signing_keys WHERE kid=$1", kid)
+	return err
+}
+
+// rotationLockID ключ advisory lock ротации ключей подписи
+const rotationLockID = 7_265_301
+
+// TryLockRotation берёт advisory lock на отдельном соединении. Если экземпляр завершится во время ротации,
+// блокировка снимется вместе с соединением
+func (db *PostgresqlManager) TryLockRotation() (func(), error) {
+	ctx := context.Background()
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", rotationLockID).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		if err == nil {
+			err = keys.ErrRotationLocked
+		}
+		return nil, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", rotationLockID); err != nil {
+			logrus.Warn(err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// GetClient возвращает клиента, если secret совпадает с сохранённым хэшем
+const clientColumns = "client_id, tenant_id, name, introspection, secret_hash IS NOT NULL, first_party, array_to_string(redirect_uris, ' '), client_credentials, array_to_string(scopes, ' '), array_to_string(exchange_audiences, ' ')"
+
+func (db *PostgresqlManager) GetClient(tenantID string, clientID string, secret string) (models.Client, error) {
+	return db.getClient("SELECT "+clientColumns+" FROM oauth_clients WHERE tenant_id=$1 AND client_id=$2 AND secret_hash=crypt($3, secret_hash)", tenantID, clientID, secret)
+}
+
+func (db *PostgresqlManager) GetPublicClient(tenantID string, clientID string) (models.Client, error) {
+	return db.getClient("SELECT "+clientColumns+" FROM oauth_clients WHERE tenant_id=$1 AND client_id=$2 AND secret_hash IS NULL", tenantID, clientID)
+}
+
+// GetClientByID ищет клиента без проверки секрета, используется при запросе авторизации
+func (db *PostgresqlManager) GetClientByID(tenantID string, clientID string) (models.Client, error) {
+	return db.getClient("SELECT "+clientColumns+" FROM oauth_clients WHERE tenant_id=$1 AND client_id=$2", tenantID, clientID)
+}
+
+func (db *PostgresqlManager) getClient(query string, args ...any) (models.Client, error) {
+	var client models.Client
+	var redirectURIs, scopes, exchangeAudiences string
+	err := db.db.QueryRow(query, args...).
+		Scan(&client.ID, &client.TenantID, &client.Name, &client.Introspection, &client.Confidential, &client.FirstParty, &redirectURIs, &client.ClientCredentials, &scopes, &exchangeAudiences)
+	if err != nil {
+		return client, err
+	}
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.ExchangeAudiences = strings.Fields(exchangeAudiences)
+	return client, nil
+}
+
+// AddClient регистрирует клиента. Для публичного клиента secret пустой и секрет не сохраняется
+func (db *PostgresqlManager) AddClient(client models.Client, secret string) error {
+	_, err := db.db.Exec(`INSERT INTO oauth_clients (client_id, tenant_id, name, secret_hash, first_party, redirect_uris, client_credentials, scopes, exchange_audiences)
+		VALUES ($1, $2, $3, CASE WHEN $4='' THEN NULL ELSE crypt($4, gen_salt('bf')) END, $5, string_to_array($6, ' '), $7, string_to_array($8, ' '), string_to_array($9, ' '))`,
+		client.ID, client.TenantID, client.Name, secret, client.FirstParty, strings.Join(client.RedirectURIs, " "),
+		client.ClientCredentials, strings.Join(client.Scopes, " "), strings.Join(client.ExchangeAudiences, " "))
+	return err
+}
+
+// SetAuthenticatedAt запоминает время входа пользователя, обновление токенов его не меняет
+func (db *PostgresqlManager) SetAuthenticatedAt(tenantID string, guid string) error {
+	_, err := db.db.Exec("UPDATE users_auth SET authenticated_at=now() WHERE tenant_id=$1 AND user_id=$2", tenantID, guid)
+	return err
+}
+
+func (db *PostgresqlManager) AddAuthorizationCode(code models.AuthorizationCode) error {
+	_, err := db.db.Exec(`INSERT INTO oauth_codes (code_hash, tenant_id, client_id, user_id, redirect_uri, scope, code_challenge, expires_at, nonce, auth_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		code.CodeHash, code.TenantID, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.CodeChallenge, code.ExpiresAt, code.Nonce, code.AuthTime)
+	return err
+}
+
+// UseAuthorizationCode помечает код использованным и возвращает его. Повторно использовать код нельзя
+func (db *PostgresqlManager) UseAuthorizationCode(tenantID string, codeHash string) (models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	var authTime sql.NullTime
+	err := db.db.QueryRow(`UPDATE oauth_codes SET used_at=now() WHERE tenant_id=$1 AND code_hash=$2 AND used_at IS NULL
+		RETURNING code_hash, tenant_id, client_id, user_id, redirect_uri, scope, code_challenge, expires_at, nonce, auth_time`, tenantID, codeHash).
+		Scan(&code.CodeHash, &code.TenantID, &code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope, &code.CodeChallenge, &code.ExpiresAt, &code.Nonce, &authTime)
+	if err != nil {
+		return code, err
+	}
+	code.AuthTime = authTime.Time
+	return code, nil
+}
+
+func (db *PostgresqlManager) DeleteExpiredAuthorizationCodes() error {
+	_, err := db.db.Exec("DELETE FROM oauth_codes WHERE expires_at <= now()")
+	return err
+}
+
+func (db *PostgresqlManager) AddDeviceCode(code models.DeviceCode) error {
+	_, err := db.db.Exec(`INSERT INTO oauth_device_codes (device_code_hash, user_code, tenant_id, client_id, scope, poll_interval, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		code.DeviceCodeHash, code.UserCode, code.TenantID, code.ClientID, code.Scope, int(code.Interval.Seconds()), code.ExpiresAt)
+	return err
+}
+
+const deviceCodeColumns = "device_code_hash, user_code, tenant_id, client_id, scope, user_id, status, poll_interval, last_polled_at, expires_at"
+
+func (db *PostgresqlManager) GetDeviceCodeByUserCode(tenantID string, userCode string) (models.DeviceCode, error) {
+	return db.getDeviceCode("SELECT "+deviceCodeColumns+" FROM oauth_device_codes WHERE tenant_id=$1 AND user_code=$2", tenantID, userCode)
+}
+
+// SetDeviceCodeDecision сохраняет решение пользователя. Решение принимается один раз и только до истечения кода
+func (db *PostgresqlManager) SetDeviceCodeDecision(tenantID string, userCode string, guid string, status string) error {
+	res, err := db.db.Exec(`UPDATE oauth_device_codes SET user_id=$3, status=$4
+		WHERE tenant_id=$1 AND user_code=$2 AND status='pending' AND expires_at > now()`, tenantID, userCode, guid, status)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PollDeviceCode отмечает время опроса и возвращает код со временем предыдущего опроса в LastPolledAt
+func (db *PostgresqlManager) PollDeviceCode(tenantID string, deviceCodeHash string) (models.DeviceCode, error) {
+	return db.getDeviceCode(`UPDATE oauth_device_codes c SET last_polled_at=now()
+		FROM oauth_device_codes old WHERE c.device_code_hash=old.device_code_hash AND c.tenant_id=$1 AND c.device_code_hash=$2
+		RETURNING old.device_code_hash, old.user_code, old.tenant_id, old.client_id, old.scope, old.user_id, old.status, old.poll_interval, old.last_polled_at, old.expires_at`,
+		tenantID, deviceCodeHash)
+}
+
+func (db *PostgresqlManager) SlowDownDeviceCode(tenantID string, deviceCodeHash string, interval time.Duration) error {
+	_, err := db.db.Exec("UPDATE oauth_device_codes SET poll_interval=poll_interval+$3 WHERE tenant_id=$1 AND device_code_hash=$2",
+		tenantID, deviceCodeHash, int(interval.Seconds()))
+	return err
 }
 
-func (db *PostgresqlManager) GetToken(guid string) (string, error) {
-	var rtDB sql.NullString
-	err := db.db.QueryRow("SELECT refresh_t FROM users_auth WHERE user_id=$1", guid).Scan(&rtDB)
+// UseDeviceCode помечает подтверждённый код использованным, токены по коду выдаются один раз
+func (db *PostgresqlManager) UseDeviceCode(tenantID string, deviceCodeHash string) error {
+	res, err := db.db.Exec("UPDATE oauth_device_codes SET status='used' WHERE tenant_id=$1 AND device_code_hash=$2 AND status='approved'",
+		tenantID, deviceCodeHash)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *PostgresqlManager) DeleteExpiredDeviceCodes() error {
+	_, err := db.db.Exec("DELETE FROM oauth_device_codes WHERE expires_at <= now()")
+	return err
+}
+
+func (db *PostgresqlManager) getDeviceCode(query string, tenantID string, arg string) (models.DeviceCode, error) {
+	var code models.DeviceCode
+	var userID sql.NullString
+	var lastPolledAt sql.NullTime
+	var interval int
+	err := db.db.QueryRow(query, tenantID, arg).
+		Scan(&code.DeviceCodeHash, &code.UserCode, &code.TenantID, &code.ClientID, &code.Scope, &userID, &code.Status, &interval, &lastPolledAt, &code.ExpiresAt)
+	if err != nil {
+		return code, err
+	}
+	code.UserID = userID.String
+	code.Interval = time.Duration(interval) * time.Second
+	code.LastPolledAt = lastPolledAt.Time
+	return code, nil
+}
+
+func (db *PostgresqlManager) GetConsent(tenantID string, clientID string, guid string) (string, error) {
+	var scope string
+	err := db.db.QueryRow("SELECT scope FROM oauth_consents WHERE tenant_id=$1 AND client_id=$2 AND user_id=$3", tenantID, clientID, guid).Scan(&scope)
 	if err != nil {
 		return "", err
 	}
-	return rtDB.String, nil
+	return scope, nil
+}
+
+func (db *PostgresqlManager) SaveConsent(tenantID string, clientID string, guid string, scope string) error {
+	_, err := db.db.Exec(`INSERT INTO oauth_consents (tenant_id, client_id, user_id, scope) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, client_id, user_id) DO UPDATE SET scope=EXCLUDED.scope, granted_at=now()`, tenantID, clientID, guid, scope)
+	return err
+}
+
+// RevokeSession завершает сессию, удаляя refresh токен, и возвращает jti последнего выпущенного access токена.
+// Пользователь при этом не удаляется
+func (db *PostgresqlManager) RevokeSession(tenantID string, guid string, sessionID string) (string, time.Time, error) {
+	var accessJTI sql.NullString
+	var accessExpiresAt sql.NullTime
+	err := db.db.QueryRow(`UPDATE users_auth u SET refresh_t=NULL, access_jti=NULL, access_expires_at=NULL
+		FROM users_auth old WHERE u.id=old.id AND u.tenant_id=$1 AND u.user_id=$2 AND u.id=$3
+		RETURNING old.access_jti, old.access_expires_at`, tenantID, guid, sessionID).Scan(&accessJTI, &accessExpiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return accessJTI.String, accessExpiresAt.Time, nil
+}
+
+const sessionColumns = "user_id, id, user_agent, user_ip, refreshed_at, authenticated_at, scope, dpop_jkt, access_jti"
+
+func (db *PostgresqlManager) GetSession(tenantID string, guid string) (models.Session, error) {
+	_, session, err := db.getSession("SELECT "+sessionColumns+" FROM users_auth WHERE tenant_id=$1 AND user_id=$2", tenantID, guid)
+	return session, err
+}
+
+// GetSessionByRT ищет сессию по bcrypt refresh токена первой версии
+func (db *PostgresqlManager) GetSessionByRT(tenantID string, rTokenBcrypt string) (string, models.Session, error) {
+	return db.getSession("SELECT "+sessionColumns+" FROM users_auth WHERE tenant_id=$1 AND refresh_t=$2", tenantID, rTokenBcrypt)
+}
+
+// GetSessionByRefreshHash ищет сессию по идентификатору из refresh токена второй версии и хешу его секрета
+func (db *PostgresqlManager) GetSessionByRefreshHash(tenantID string, sessionID string, refreshHash string) (string, models.Session, error) {
+	return db.getSession("SELECT "+sessionColumns+" FROM users_auth WHERE tenant_id=$1 AND id=$2 AND refresh_t=$3", tenantID, sessionID, refreshHash)
+}
+
+func (db *PostgresqlManager) getSession(query string, args ...any) (string, models.Session, error) {
+	return scanSession(db.db.QueryRow(query, args...))
 }
 
-func (db *PostgresqlManager) DeleteUser(guid string) error {
-	res, err := db.db.Exec("DELETE FROM users_auth WHERE user_id=$1", guid)
+// scanSession читает колонки sessionColumns, extra получает колонки, следующие за ними
+func scanSession(row *sql.Row, extra ...any) (string, models.Session, error) {
+	var guid string
+	var session models.Session
+	var userAgent, userIp, scope, jkt, accessJTI sql.NullString
+	var refreshedAt, authenticatedAt sql.NullTime
+	dest := append([]any{&guid, &session.ID, &userAgent, &userIp, &refreshedAt, &authenticatedAt, &scope, &jkt, &accessJTI}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return "", session, err
+	}
+	session.UserAgent = userAgent.String
+	session.IP = userIp.String
+	session.RefreshedAt = refreshedAt.Time
+	session.AuthenticatedAt = authenticatedAt.Time
+	session.DPoPJKT = jkt.String
+	session.AccessJTI = accessJTI.String
+	if scope.Valid {
+		session.Scope = &scope.String
+	}
+	return guid, session, nil
+}
+
+// AddClientSession создаёт сессию OAuth клиента. Каждая выдача токенов клиенту по коду авторизации или коду устройства
+// создаёт свою сессию, сессия входа через cookie при этом не меняется
+func (db *PostgresqlManager) AddClientSession(tenantID string, guid string, clientID string, authenticatedAt time.Time) (string, error) {
+	var sessionID string
+	err := db.db.QueryRow("INSERT INTO oauth_sessions (tenant_id, client_id, user_id, authenticated_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		tenantID, clientID, guid, authenticatedAt).Scan(&sessionID)
+	return sessionID, err
+}
+
+// UpdateClientSession сохраняет в сессии клиента хеш нового refresh токена и jti access токена, как LoginDB для сессии входа
+func (db *PostgresqlManager) UpdateClientSession(tenantID string, sessionID string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) error {
+	res, err := db.db.Exec("UPDATE oauth_sessions SET refresh_t=$1, user_agent=$2, user_ip=$3, refreshed_at=now(), access_jti=$4, access_expires_at=$5, scope=$6, dpop_jkt=NULLIF($7, '') WHERE tenant_id=$8 AND id=$9",
+		refreshHash, userAgent, ip, accessJTI, accessExpiresAt, scope, jkt, tenantID, sessionID)
 	if err != nil {
 		return err
 	}
-	row, err := res.RowsAffected()
+	rows, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
-	if row == 0 {
-		return apperror.ErrUnauthorized
+	if rows == 0 {
+		return sql.ErrNoRows
 	}
-	logrus.Info("user deauthorized")
 	return nil
 }
 
-func (db *PostgresqlManager) GetUserInfo(guid string) (string, string, error) {
-	var userAgent, userIp string
-	err := db.db.QueryRow("SELECT user_agent, user_ip FROM users_auth WHERE user_id=$1", guid).Scan(&userAgent, &userIp)
+const clientSessionColumns = sessionColumns + ", client_id"
+
+func (db *PostgresqlManager) GetClientSession(tenantID string, sessionID string) (string, models.Session, error) {
+	return db.getClientSession("SELECT "+clientSessionColumns+" FROM oauth_sessions WHERE tenant_id=$1 AND id=$2", tenantID, sessionID)
+}
+
+// GetClientSessionByRefreshHash ищет сессию клиента по идентификатору из refresh токена и хешу его секрета
+func (db *PostgresqlManager) GetClientSessionByRefreshHash(tenantID string, sessionID string, refreshHash string) (string, models.Session, error) {
+	return db.getClientSession("SELECT "+clientSessionColumns+" FROM oauth_sessions WHERE tenant_id=$1 AND id=$2 AND refresh_t=$3", tenantID, sessionID, refreshHash)
+}
+
+func (db *PostgresqlManager) getClientSession(query string, args ...any) (string, models.Session, error) {
+	var clientID string
+	guid, session, err := scanSession(db.db.QueryRow(query, args...), &clientID)
+	session.ClientID = clientID
+	return guid, session, err
+}
+
+// RevokeClientSession удаляет сессию клиента и возвращает jti последнего выпущенного в ней access токена
+func (db *PostgresqlManager) RevokeClientSession(tenantID string, guid string, sessionID string) (string, time.Time, error) {
+	var accessJTI sql.NullString
+	var accessExpiresAt sql.NullTime
+	err := db.db.QueryRow("DELETE FROM oauth_sessions WHERE tenant_id=$1 AND user_id=$2 AND id=$3 RETURNING access_jti, access_expires_at",
+		tenantID, guid, sessionID).Scan(&accessJTI, &accessExpiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return accessJTI.String, accessExpiresAt.Time, nil
+}
+
+// RevokeClientSessions удаляет все сессии клиентов пользователя и возвращает jti и срок действия их последних access токенов
+func (db *PostgresqlManager) RevokeClientSessions(tenantID string, guid string) (map[string]time.Time, error) {
+	rows, err := db.db.Query("DELETE FROM oauth_sessions WHERE tenant_id=$1 AND user_id=$2 RETURNING access_jti, access_expires_at", tenantID, guid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accessTokens := map[string]time.Time{}
+	for rows.Next() {
+		var accessJTI sql.NullString
+		var accessExpiresAt sql.NullTime
+		if err = rows.Scan(&accessJTI, &accessExpiresAt); err != nil {
+			return nil, err
+		}
+		if accessJTI.Valid {
+			accessTokens[accessJTI.String] = accessExpiresAt.Time
+		}
+	}
+	return accessTokens, rows.Err()
+}
+
+// DeleteExpiredClientSessions удаляет сессии клиентов тенанта, refresh токен которых истёк
+func (db *PostgresqlManager) DeleteExpiredClientSessions(tenantID string, refreshTTL time.Duration) error {
+	_, err := db.db.Exec("DELETE FROM oauth_sessions WHERE tenant_id=$1 AND refreshed_at < $2", tenantID, time.Now().Add(-refreshTTL))
+	return err
+}
+
+func (db *PostgresqlManager) AddRevokedToken(tenantID string, jti string, expiresAt time.Time) error {
+	_, err := db.db.Exec("INSERT INTO revoked_tokens (jti, tenant_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING", jti, tenantID, expiresAt)
+	return err
+}
+
+func (db *PostgresqlManager) GetRevokedTokens() (map[string]time.Time, error) {
+	rows, err := db.db.Query("SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > now()")
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	defer rows.Close()
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err = rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
 	}
-	return userAgent, userIp, nil
+	return revoked, rows.Err()
+}
+
+func (db *PostgresqlManager) DeleteExpiredRevokedTokens() error {
+	_, err := db.db.Exec("DELETE FROM revoked_tokens WHERE expires_at <= now()")
+	return err
+}
+
+// UseDPoPProof запоминает DPoP proof до expiresAt и попутно удаляет истёкшие.
+// Повторно предъявленный proof не сохраняется, в этом случае возвращается sql.ErrNoRows
+func (db *PostgresqlManager) UseDPoPProof(proofHash string, expiresAt time.Time) error {
+	res, err := db.db.Exec(`WITH expired AS (DELETE FROM dpop_proofs WHERE expires_at <= now())
+		INSERT INTO dpop_proofs (proof_hash, expires_at) VALUES ($1, $2) ON CONFLICT (proof_hash) DO NOTHING`, proofHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/pkg/jwe"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+	"github.com/sater-151/AuthSystem/internal/pkg/paseto"
+)
+
+type Profile struct {
+	GUID        string
+	Email       string
+	DisplayName string
+	Roles       []string
+	MFAEnabled  bool
+	Session     Session
+}
+
+type Session struct {
+	ID              string
+	UserAgent       string
+	IP              string
+	RefreshedAt     time.Time
+	AuthenticatedAt time.Time
+	// Scope выданный в сессии scope, nil у сессий, созданных до появления scope в токенах
+	Scope *string
+	// DPoPJKT отпечаток ключа DPoP, к которому привязана сессия
+	DPoPJKT string
+	// AccessJTI jti последнего выпущенного в сессии access токена, пустой у завершённой сессии
+	AccessJTI string
+	// ClientID OAuth клиент, которому выданы токены сессии, пустой у сессии входа через cookie
+	ClientID string
+}
+
+const DefaultTenantID = "default"
+
+type Tenant struct {
+	ID         string
+	Name       string
+	Host       string
+	Secret     []byte
+	Keys       *keys.Ring
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+	// Format формат выпускаемых access токенов, Paseto ключи для токенов PASETO,
+	// Encryption ключи шифрования JWT, вложенных в JWE
+	Format     string
+	Paseto     *paseto.Keys
+	Encryption *jwe.Ring
+}
+
+const RoleAdmin = "admin"
+
+type ImpersonationAudit struct {
+	TenantID  string
+	ActorID   string
+	TargetID  string
+	Reason    string
+	ActorIP   string
+	ExpiresAt time.Time
+}
+
+// Client OAuth клиент. Публичный клиент (Confidential равен false) не имеет секрета.
+// Для доверенного клиента (FirstParty) согласие пользователя при авторизации не запрашивается.
+// Клиент с ClientCredentials получает токены от своего имени с scope из Scopes
+type Client struct {
+	ID                string
+	TenantID          string
+	Name              string
+	Introspection     bool
+	Confidential      bool
+	FirstParty        bool
+	RedirectURIs      []string
+	ClientCredentials bool
+	Scopes            []string
+	// ExchangeAudiences сервисы, для которых клиент может обменивать токены пользователей (RFC 8693)
+	ExchangeAudiences []string
+}
+
+// AuthorizationRequest параметры запроса авторизации (RFC 6749 4.1.1, RFC 7636 4.3)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// AuthorizationCode выданный код авторизации. Код хранится только в виде хэша
+type AuthorizationCode struct {
+	CodeHash      string
+	TenantID      string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	Nonce         string
+	AuthTime      time.Time
+}
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeUsed     = "used"
+)
+
+// DeviceCode запрос авторизации устройства (RFC 8628). device_code хранится только в виде хэша,
+// UserID заполняется, когда пользователь подтверждает или отклоняет запрос
+type DeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	TenantID       string
+	ClientID       string
+	Scope          string
+	UserID         string
+	Status         string
+	Interval       time.Duration
+	LastPolledAt   time.Time
+	ExpiresAt      time.Time
+}
+
+// DeviceAuthorization ответ на запрос авторизации устройства
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
+
+// Tokens токены, выданные OAuth клиенту
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresIn    time.Duration
+	Scope        string
+	// IssuedTokenType тип выданного токена при обмене (RFC 8693 2.2.1)
+	IssuedTokenType string
+	// TokenType DPoP для токенов, привязанных к ключу, иначе Bearer
+	TokenType string
+	// RefreshExpiresIn время жизни refresh токена, SessionID сессия, в которой выпущены токены пользователя
+	RefreshExpiresIn time.Duration
+	SessionID        string
+}
+
+// Introspection результат проверки токена по RFC 7662
+type Introspection struct {
+	Active    bool
+	TokenType string
+	TokenID   string
+	Subject   string
+	Tenant    string
+	SessionID string
+	Issuer    string
+	Audience  []string
+	Actor     string
+	ClientID  string
+	Scope     string
+	// DPoPJKT отпечаток ключа DPoP из claim cnf.jkt
+	DPoPJKT   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// OpenIDConfiguration сведения о провайдере OpenID Connect, не зависящие от адресов эндпоинтов
+type OpenIDConfiguration struct {
+	Issuer            string
+	SigningAlgorithms []string
+	DPoPAlgorithms    []string
+}
@@ -0,0 +1,78 @@
+package denylist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Store interface {
+	AddRevokedToken(tenantID string, jti string, expiresAt time.Time) (err error)
+	GetRevokedTokens() (revoked map[string]time.Time, err error)
+	DeleteExpiredRevokedTokens() (err error)
+}
+
+// Denylist список отозванных access токенов по jti. Записи хранятся до истечения токена,
+// локальная копия периодически синхронизируется с хранилищем, поэтому отзыв на других
+// экземплярах сервиса вступает в силу в течение интервала синхронизации
+type Denylist struct {
+	mu      sync.RWMutex
+	store   Store
+	entries map[string]time.Time
+}
+
+func New(store Store) *Denylist {
+	return &Denylist{store: store, entries: map[string]time.Time{}}
+}
+
+func (d *Denylist) Revoke(tenantID string, jti string, expiresAt time.Time) error {
+	if jti == "" || time.Now().After(expiresAt) {
+		return nil
+	}
+	if err := d.store.AddRevokedToken(tenantID, jti, expiresAt); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.entries[jti] = expiresAt
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Denylist) IsRevoked(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	expiresAt, ok := d.entries[jti]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Sync удаляет истёкшие записи из хранилища и заменяет локальную копию
+func (d *Denylist) Sync() error {
+	if err := d.store.DeleteExpiredRevokedTokens(); err != nil {
+		return err
+	}
+	entries, err := d.store.GetRevokedTokens()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.entries = entries
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Denylist) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.Sync(); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package denylist
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore общее хранилище нескольких экземпляров сервиса
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	err     error
+}
+
+func (s *memoryStore) AddRevokedToken(tenantID string, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.entries[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryStore) GetRevokedTokens() (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revoked := make(map[string]time.Time, len(s.entries))
+	for jti, expiresAt := range s.entries {
+		revoked[jti] = expiresAt
+	}
+	return revoked, s.err
+}
+
+func (s *memoryStore) DeleteExpiredRevokedTokens() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, expiresAt := range s.entries {
+		if time.Now().After(expiresAt) {
+			delete(s.entries, jti)
+		}
+	}
+	return s.err
+}
+
+func TestRevoke(t *testing.T) {
+	store := &memoryStore{entries: map[string]time.Time{}}
+	denylist := New(store)
+	if err := denylist.Revoke("default", "jti-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !denylist.IsRevoked("jti-1") {
+		t.Fatal("revoked token isn't in denylist")
+	}
+	if denylist.IsRevoked("jti-2") {
+		t.Fatal("token isn't revoked")
+	}
+
+	// истёкший токен и токен без jti не сохраняются
+	if err := denylist.Revoke("default", "jti-expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.Revoke("default", "", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.entries) != 1 {
+		t.Fatalf("store entries = %v", store.entries)
+	}
+
+	store.err = errors.New("store is unavailable")
+	if err := denylist.Revoke("default", "jti-3", time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("store error is ignored")
+	}
+	if denylist.IsRevoked("jti-3") {
+		t.Fatal("token isn't saved but is revoked locally")
+	}
+}
+
+func TestSync(t *testing.T) {
+	store := &memoryStore{entries: map[string]time.Time{}}
+	first, second := New(store), New(store)
+	if err := first.Revoke("default", "jti-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if second.IsRevoked("jti-1") {
+		t.Fatal("revoked on another instance before sync")
+	}
+	if err := second.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !second.IsRevoked("jti-1") {
+		t.Fatal("revoked token isn't synced")
+	}
+
+	store.entries["jti-expired"] = time.Now().Add(-time.Second)
+	if err := second.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.entries["jti-expired"]; ok {
+		t.Fatal("expired entry isn't deleted from store")
+	}
+	if second.IsRevoked("jti-expired") {
+		t.Fatal("expired entry is revoked")
+	}
+
+	// при ошибке хранилища локальная копия не заменяется
+	store.err = errors.New("store is unavailable")
+	if err := second.Sync(); err == nil {
+		t.Fatal("store error is ignored")
+	}
+	if !second.IsRevoked("jti-1") {
+		t.Fatal("local copy is lost after failed sync")
+	}
+}
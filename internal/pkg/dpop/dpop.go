@@ -0,0 +1,262 @@
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const proofType = "dpop+jwt"
+
+// Algorithms асимметричные алгоритмы подписи DPoP proof, none и HMAC не допускаются (RFC 9449 4.3)
+var Algorithms = []string{"ES256", "RS256", "PS256", "EdDSA"}
+
+var ErrInvalidProof = errors.New("invalid dpop proof")
+var ErrNonceKeyNotSet = errors.New("dpop nonce key isn't set")
+
+// Proof проверенный DPoP proof. JKT отпечаток открытого ключа из заголовка jwk (RFC 7638)
+type Proof struct {
+	ID              string
+	Method          string
+	URI             string
+	IssuedAt        time.Time
+	Nonce           string
+	AccessTokenHash string
+	JKT             string
+}
+
+type proofClaims struct {
+	jwt.RegisteredClaims
+	Method          string `json:"htm"`
+	URI             string `json:"htu"`
+	Nonce           string `json:"nonce,omitempty"`
+	AccessTokenHash string `json:"ath,omitempty"`
+}
+
+// Parse проверяет структуру и подпись DPoP proof ключом из его заголовка jwk.
+// Соответствие запросу, время, nonce и повторное использование проверяет вызывающий
+func Parse(proof string) (Proof, error) {
+	claims := &proofClaims{}
+	var thumbprint string
+	_, err := jwt.ParseWithClaims(proof, claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != proofType {
+			return nil, ErrInvalidProof
+		}
+		jwk, ok := t.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidProof
+		}
+		key, jkt, err := publicKey(jwk, t.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		thumbprint = jkt
+		return key, nil
+	}, jwt.WithValidMethods(Algorithms), jwt.WithoutClaimsValidation())
+	if err != nil {
+		logrus.Debug(err)
+		return Proof{}, ErrInvalidProof
+	}
+	if claims.ID == "" || claims.Method == "" || claims.URI == "" || claims.IssuedAt == nil {
+		return Proof{}, ErrInvalidProof
+	}
+	return Proof{
+		ID:              claims.ID,
+		Method:          claims.Method,
+		URI:             claims.URI,
+		IssuedAt:        claims.IssuedAt.Time,
+		Nonce:           claims.Nonce,
+		AccessTokenHash: claims.AccessTokenHash,
+		JKT:             thumbprint,
+	}, nil
+}
+
+// AccessTokenHash значение ath для access токена: base64url(SHA-256(token))
+func AccessTokenHash(aToken string) string {
+	sum := sha256.Sum256([]byte(aToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SameURI сравнивает htu с адресом запроса без query и fragment, схема и хост без учёта регистра,
+// порт по умолчанию не учитывается (RFC 9449 4.3, RFC 3986 6.2.2 и 6.2.3)
+func SameURI(htu string, requestURI string) bool {
+	a, err := normalizeURI(htu)
+	if err != nil {
+		return false
+	}
+	b, err := normalizeURI(requestURI)
+	if err != nil {
+		return false
+	}
+	return a == b
+}
+
+func normalizeURI(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return "", ErrInvalidProof
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path, nil
+}
+
+// ecThumbprint, rsaThumbprint и okpThumbprint задают обязательные члены JWK в лексикографическом порядке (RFC 7638 3.2)
+type ecThumbprint struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type rsaThumbprint struct {
+	E   string `json:"e"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+}
+
+type okpThumbprint struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+}
+
+// publicKey восстанавливает открытый ключ из JWK и вычисляет его отпечаток. Тип ключа должен соответствовать alg,
+// JWK с закрытой частью не принимается
+func publicKey(jwk map[string]interface{}, alg string) (crypto.PublicKey, string, error) {
+	if _, ok := jwk["d"]; ok {
+		return nil, "", ErrInvalidProof
+	}
+	member := func(name string) string {
+		value, _ := jwk[name].(string)
+		return value
+	}
+	var key crypto.PublicKey
+	var canonical any
+	switch kty := member("kty"); {
+	case kty == "EC" && alg == "ES256":
+		if member("crv") != "P-256" {
+			return nil, "", ErrInvalidProof
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(member("x"))
+		y, errY := base64.RawURLEncoding.DecodeString(member("y"))
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, "", ErrInvalidProof
+		}
+		// ecdh проверяет, что точка лежит на кривой
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, "", ErrInvalidProof
+		}
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		canonical = ecThumbprint{Crv: "P-256", Kty: kty, X: member("x"), Y: member("y")}
+	case kty == "RSA" && (alg == "RS256" || alg == "PS256"):
+		n, errN := base64.RawURLEncoding.DecodeString(member("n"))
+		e, errE := base64.RawURLEncoding.DecodeString(member("e"))
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", ErrInvalidProof
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if public.N.BitLen() < 2048 || public.E < 3 {
+			return nil, "", ErrInvalidProof
+		}
+		key = public
+		canonical = rsaThumbprint{E: member("e"), Kty: kty, N: member("n")}
+	case kty == "OKP" && alg == "EdDSA":
+		x, err := base64.RawURLEncoding.DecodeString(member("x"))
+		if member("crv") != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", ErrInvalidProof
+		}
+		key = ed25519.PublicKey(x)
+		canonical = okpThumbprint{Crv: "Ed25519", Kty: kty, X: member("x")}
+	default:
+		return nil, "", ErrInvalidProof
+	}
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Nonces выдаёт и проверяет nonce сервера (RFC 9449 8). Nonce не хранится: он содержит время выдачи
+// и HMAC от него, поэтому экземпляры сервиса с общим ключом принимают nonce друг друга
+type Nonces struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewNonces создаёт выдачу nonce со сроком действия ttl, нулевой ttl отключает nonce.
+// Если ключ не задан, возвращается ErrNonceKeyNotSet, а при allowTemporary создаётся временный ключ
+func NewNonces(key []byte, ttl time.Duration, allowTemporary bool) (*Nonces, error) {
+	if len(key) == 0 && ttl > 0 {
+		if !allowTemporary {
+			return nil, ErrNonceKeyNotSet
+		}
+		logrus.Warn("dpop nonce key isn't set, generating temporary key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Nonces{key: key, ttl: ttl}, nil
+}
+
+func (n *Nonces) Enabled() bool {
+	return n.ttl > 0
+}
+
+func (n *Nonces) Issue() string {
+	if !n.Enabled() {
+		return ""
+	}
+	issued := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(issued, n.mac(issued)...))
+}
+
+func (n *Nonces) Valid(nonce string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(data) != 8+sha256.Size {
+		return false
+	}
+	issued, tag := data[:8], data[8:]
+	if !hmac.Equal(tag, n.mac(issued)) {
+		return false
+	}
+	age := time.Since(time.Unix(int64(binary.BigEndian.Uint64(issued)), 0))
+	return age >= -time.Minute && age <= n.ttl
+}
+
+func (n *Nonces) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, n.key)
+	h.Write(data)
+	return h.Sum(nil)
+}
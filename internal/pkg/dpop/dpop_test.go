@@ -0,0 +1,201 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RSA ключ из примера RFC 7638 3.1 и его отпечаток
+const (
+	rfcModulus    = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	rfcThumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+)
+
+func TestThumbprintRFC7638(t *testing.T) {
+	// необязательные члены JWK в отпечаток не входят
+	jwk := map[string]interface{}{"kty": "RSA", "n": rfcModulus, "e": "AQAB", "alg": "RS256", "kid": "2011-04-29"}
+	_, jkt, err := publicKey(jwk, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jkt != rfcThumbprint {
+		t.Fatalf("thumbprint = %s, want %s", jkt, rfcThumbprint)
+	}
+	if _, _, err = publicKey(jwk, "ES256"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("RSA key with ES256: err = %v, want %v", err, ErrInvalidProof)
+	}
+	jwk["d"] = "private"
+	if _, _, err = publicKey(jwk, "RS256"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("private jwk: err = %v, want %v", err, ErrInvalidProof)
+	}
+}
+
+func ecJWK(key *ecdsa.PrivateKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// newProof подписывает proof ключом signer, в заголовок jwk помещается ключ header
+func newProof(t *testing.T, signer *ecdsa.PrivateKey, header map[string]interface{}, typ string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = typ
+	token.Header["jwk"] = header
+	proof, err := token.SignedString(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestParse(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, jkt, err := publicKey(ecJWK(key), "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"jti": "proof-1", "htm": "POST", "htu": "https://auth.example/api/oauth/token", "iat": time.Now().Unix(), "nonce": "n", "ath": AccessTokenHash("token")}
+	}
+
+	proof, err := Parse(newProof(t, key, ecJWK(key), proofType, claims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.ID != "proof-1" || proof.Method != "POST" || proof.URI != "https://auth.example/api/oauth/token" || proof.Nonce != "n" || proof.AccessTokenHash != AccessTokenHash("token") || proof.JKT != jkt {
+		t.Fatalf("proof = %+v", proof)
+	}
+
+	withoutJTI := claims()
+	delete(withoutJTI, "jti")
+	withoutIAT := claims()
+	delete(withoutIAT, "iat")
+	hmacProof := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	hmacProof.Header["typ"] = proofType
+	hmacProof.Header["jwk"] = map[string]interface{}{"kty": "oct", "k": "c2VjcmV0"}
+	hmacSigned, err := hmacProof.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offCurve := ecJWK(key)
+	offCurve["y"] = offCurve["x"]
+	tests := []struct {
+		name  string
+		proof string
+	}{
+		{"typ", newProof(t, key, ecJWK(key), "JWT", claims())},
+		{"other key", newProof(t, other, ecJWK(key), proofType, claims())},
+		{"point not on curve", newProof(t, key, offCurve, proofType, claims())},
+		{"jti", newProof(t, key, ecJWK(key), proofType, withoutJTI)},
+		{"iat", newProof(t, key, ecJWK(key), proofType, withoutIAT)},
+		{"hmac", hmacSigned},
+		{"malformed", "not.a.proof"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.proof); !errors.Is(err, ErrInvalidProof) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, ErrInvalidProof)
+		}
+	}
+}
+
+func TestSameURI(t *testing.T) {
+	tests := []struct {
+		htu  string
+		uri  string
+		want bool
+	}{
+		{"https://auth.example/api/oauth/token", "https://auth.example/api/oauth/token", true},
+		{"HTTPS://Auth.Example:443/api/oauth/token", "https://auth.example/api/oauth/token?x=1#f", true},
+		{"http://auth.example:80", "http://auth.example/", true},
+		{"https://auth.example:8443/api/oauth/token", "https://auth.example/api/oauth/token", false},
+		{"https://auth.example/api/oauth/Token", "https://auth.example/api/oauth/token", false},
+		{"http://auth.example/api/oauth/token", "https://auth.example/api/oauth/token", false},
+		{"/api/oauth/token", "https://auth.example/api/oauth/token", false},
+	}
+	for _, tt := range tests {
+		if got := SameURI(tt.htu, tt.uri); got != tt.want {
+			t.Fatalf("SameURI(%q, %q) = %v, want %v", tt.htu, tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	nonces, err := NewNonces(key, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := nonces.Issue()
+	if !nonces.Valid(nonce) {
+		t.Fatal("issued nonce isn't valid")
+	}
+	// nonce принимают все экземпляры с общим ключом
+	shared, err := NewNonces(key, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shared.Valid(nonce) {
+		t.Fatal("nonce isn't valid on instance with the same key")
+	}
+	other, err := NewNonces([]byte("fedcba9876543210fedcba9876543210"), time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Valid(nonce) {
+		t.Fatal("nonce is valid with other key")
+	}
+
+	issued := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(-2*time.Minute).Unix()))
+	if nonces.Valid(base64.RawURLEncoding.EncodeToString(append(issued, nonces.mac(issued)...))) {
+		t.Fatal("expired nonce is valid")
+	}
+	for _, invalid := range []string{"", "bm9uY2U", nonce[:len(nonce)-2]} {
+		if nonces.Valid(invalid) {
+			t.Fatalf("nonce %q is valid", invalid)
+		}
+	}
+
+	disabled, err := NewNonces(nil, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.Enabled() || disabled.Issue() != "" {
+		t.Fatal("nonces aren't disabled with zero ttl")
+	}
+}
+
+func TestNewNoncesWithoutKey(t *testing.T) {
+	if _, err := NewNonces(nil, time.Minute, false); !errors.Is(err, ErrNonceKeyNotSet) {
+		t.Fatalf("err = %v, want %v", err, ErrNonceKeyNotSet)
+	}
+	// без nonce ключ не нужен
+	if _, err := NewNonces(nil, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	nonces, err := NewNonces(nil, time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nonces.Valid(nonces.Issue()) {
+		t.Fatal("temporary key nonce isn't valid")
+	}
+}
@@ -0,0 +1,183 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Алгоритмы JWE (RFC 7516, RFC 7518): ключ шифрования содержимого задаётся напрямую (dir),
+// содержимое шифруется AES-256-GCM. Токены шифрует и расшифровывает только сам сервис,
+// поэтому обмен ключами не нужен
+const (
+	Algorithm  = "dir"
+	Encryption = "A256GCM"
+)
+
+const (
+	keySize = 32
+	ivSize  = 12
+	tagSize = 16
+)
+
+var ErrInvalidToken = errors.New("invalid jwe token")
+var ErrInvalidKey = errors.New("invalid jwe key")
+var ErrUnknownKey = errors.New("unknown jwe key")
+var ErrKeyNotSet = errors.New("jwe key isn't set")
+
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Ring ключи шифрования: первым ключом шифруются новые токены, остальными только расшифровываются
+// ранее выпущенные, пока они не истекут
+type Ring struct {
+	active Key
+	keys   map[string]Key
+}
+
+// header защищённый заголовок JWE, поля в порядке сериализации
+type header struct {
+	Alg         string   `json:"alg"`
+	Enc         string   `json:"enc"`
+	Kid         string   `json:"kid,omitempty"`
+	ContentType string   `json:"cty,omitempty"`
+	Crit        []string `json:"crit,omitempty"`
+}
+
+// LoadRing читает ключи из строки вида kid:hex,kid:hex, каждый ключ 32 байта в hex.
+// Если ключи не заданы, возвращается ErrKeyNotSet, а при allowTemporary создаётся временный ключ,
+// который теряется при перезапуске
+func LoadRing(spec string, allowTemporary bool) (*Ring, error) {
+	ring := &Ring{keys: map[string]Key{}}
+	if strings.TrimSpace(spec) == "" {
+		if !allowTemporary {
+			return nil, ErrKeyNotSet
+		}
+		logrus.Warn("jwe key isn't set, generating temporary key")
+		id := make([]byte, 8)
+		secret := make([]byte, keySize)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		ring.add(Key{ID: hex.EncodeToString(id), Secret: secret})
+		return ring, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || id == "" {
+			return nil, ErrInvalidKey
+		}
+		secret, err := hex.DecodeString(encoded)
+		if err != nil || len(secret) != keySize {
+			return nil, ErrInvalidKey
+		}
+		if _, exists := ring.keys[id]; exists {
+			return nil, ErrInvalidKey
+		}
+		ring.add(Key{ID: id, Secret: secret})
+	}
+	return ring, nil
+}
+
+func (r *Ring) add(key Key) {
+	if len(r.keys) == 0 {
+		r.active = key
+	}
+	r.keys[key.ID] = key
+}
+
+// IsJWE сообщает, что токен в компактной сериализации JWE: пять частей через точку
+func IsJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// Encrypt шифрует payload активным ключом. contentType указывается в cty, для вложенного JWT равен JWT
+func (r *Ring) Encrypt(payload []byte, contentType string) (string, error) {
+	protected, err := json.Marshal(header{Alg: Algorithm, Enc: Encryption, Kid: r.active.ID, ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := encode(protected)
+	gcm, err := newGCM(r.active.Secret)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, ivSize)
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	// AAD равен закодированному защищённому заголовку (RFC 7516 5.1, шаг 14)
+	sealed := gcm.Seal(nil, iv, payload, []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-tagSize], sealed[len(sealed)-tagSize:]
+	return strings.Join([]string{encodedHeader, "", encode(iv), encode(ciphertext), encode(tag)}, "."), nil
+}
+
+// Decrypt проверяет заголовок и расшифровывает токен ключом из kid, возвращает содержимое и cty
+func (r *Ring) Decrypt(token string) (payload []byte, contentType string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return nil, "", ErrInvalidToken
+	}
+	protected, err := decode(parts[0])
+	if err != nil {
+		return nil, "", ErrInvalidToken
+	}
+	var h header
+	if err = json.Unmarshal(protected, &h); err != nil {
+		return nil, "", ErrInvalidToken
+	}
+	// другие алгоритмы не принимаются, чтобы нельзя было подменить алгоритм в заголовке
+	if h.Alg != Algorithm || h.Enc != Encryption || len(h.Crit) != 0 {
+		return nil, "", ErrInvalidToken
+	}
+	key, ok := r.keys[h.Kid]
+	if !ok {
+		return nil, "", ErrUnknownKey
+	}
+	iv, errIV := decode(parts[2])
+	ciphertext, errCiphertext := decode(parts[3])
+	tag, errTag := decode(parts[4])
+	if errIV != nil || errCiphertext != nil || errTag != nil || len(iv) != ivSize || len(tag) != tagSize {
+		return nil, "", ErrInvalidToken
+	}
+	gcm, err := newGCM(key.Secret)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err = gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, "", ErrInvalidToken
+	}
+	return payload, h.ContentType, nil
+}
+
+func newGCM(secret []byte) (cipher.AEAD, error) {
+	if len(secret) != keySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,123 @@
+package jwe
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "k1:0001020304050607080910111213141516171819202122232425262728293031"
+	testKey2 = "k2:3130292827262524232221201918171615141312111009080706050403020100"
+)
+
+func loadRing(t *testing.T, spec string) *Ring {
+	t.Helper()
+	ring, err := LoadRing(spec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestRoundTrip(t *testing.T) {
+	ring := loadRing(t, testKey1)
+	token, err := ring.Encrypt([]byte("signed.jwt.token"), "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsJWE(token) {
+		t.Fatalf("IsJWE(%s) = false", token)
+	}
+	protected, err := decode(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h header
+	if err = json.Unmarshal(protected, &h); err != nil || h.Alg != Algorithm || h.Enc != Encryption || h.Kid != "k1" || h.ContentType != "JWT" {
+		t.Fatalf("header = %s, %v", protected, err)
+	}
+	payload, contentType, err := ring.Decrypt(token)
+	if err != nil || string(payload) != "signed.jwt.token" || contentType != "JWT" {
+		t.Fatalf("Decrypt() = %s, %s, %v", payload, contentType, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, err := loadRing(t, testKey1).Encrypt([]byte("old"), "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// новый ключ добавляется в начало, старый только расшифровывает
+	ring := loadRing(t, testKey2+","+testKey1)
+	if payload, _, err := ring.Decrypt(old); err != nil || string(payload) != "old" {
+		t.Fatalf("old token: %s, %v", payload, err)
+	}
+	token, err := ring.Encrypt([]byte("new"), "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = loadRing(t, testKey1).Decrypt(token); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("new token without k2: err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	ring := loadRing(t, testKey1)
+	token, err := ring.Encrypt([]byte("payload"), "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	withHeader := func(h header) string {
+		protected, err := json.Marshal(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(append([]string{encode(protected)}, parts[1:]...), ".")
+	}
+	tampered := []byte(parts[3])
+	tampered[0] ^= 1
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg", withHeader(header{Alg: "A256KW", Enc: Encryption, Kid: "k1", ContentType: "JWT"})},
+		{"enc", withHeader(header{Alg: Algorithm, Enc: "A128GCM", Kid: "k1", ContentType: "JWT"})},
+		{"crit", withHeader(header{Alg: Algorithm, Enc: Encryption, Kid: "k1", ContentType: "JWT", Crit: []string{"exp"}})},
+		// заголовок входит в AAD, поэтому изменённый cty не проходит проверку тега
+		{"aad", withHeader(header{Alg: Algorithm, Enc: Encryption, Kid: "k1"})},
+		{"encrypted key", strings.Join([]string{parts[0], "AAAA", parts[2], parts[3], parts[4]}, ".")},
+		{"ciphertext", strings.Join([]string{parts[0], parts[1], parts[2], string(tampered), parts[4]}, ".")},
+		{"parts", strings.Join(parts[:4], ".")},
+	}
+	for _, tt := range tests {
+		if _, _, err := ring.Decrypt(tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestLoadRingInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"0001020304050607080910111213141516171819202122232425262728293031",
+		"k1:00010203",
+		"k1:zz01020304050607080910111213141516171819202122232425262728293031",
+		testKey1 + "," + testKey1,
+		":0001020304050607080910111213141516171819202122232425262728293031",
+	} {
+		if _, err := LoadRing(spec, false); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("LoadRing(%q): err = %v, want %v", spec, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestLoadRingWithoutKey(t *testing.T) {
+	if _, err := LoadRing(" ", false); !errors.Is(err, ErrKeyNotSet) {
+		t.Fatalf("err = %v, want %v", err, ErrKeyNotSet)
+	}
+	if _, err := LoadRing("", true); err != nil {
+		t.Fatal(err)
+	}
+}
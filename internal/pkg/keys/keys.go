@@ -0,0 +1,197 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AlgHS512 = "HS512"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+var ErrKeyNotSet = errors.New("private key isn't set")
+
+// Key ключ подписи access токенов. Для HMAC SignKey и VerifyKey совпадают.
+// ID публикуется в заголовке kid, пустой ID у ключа из конфигурации, которым подписаны токены без kid
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func NewHMAC(secret []byte) Key {
+	return Key{Method: jwt.SigningMethodHS512, SignKey: secret, VerifyKey: secret}
+}
+
+// Load читает закрытый ключ алгоритма alg из PEM файла.
+// Если файл не указан, возвращается ErrKeyNotSet, а при allowTemporary создаётся временный ключ,
+// который теряется при перезапуске
+func Load(alg string, privateKeyFile string, allowTemporary bool) (Key, error) {
+	if privateKeyFile == "" {
+		if !allowTemporary {
+			return Key{}, ErrKeyNotSet
+		}
+		logrus.Warnf("private key for %s isn't set, generating temporary key", alg)
+		key, err := Generate(alg)
+		key.ID = ""
+		return key, err
+	}
+	pemKey, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return Key{}, err
+	}
+	return Parse("", alg, pemKey)
+}
+
+// Parse восстанавливает ключ из представления, полученного Marshal
+func Parse(id string, alg string, material []byte) (Key, error) {
+	switch alg {
+	case AlgHS512:
+		key := NewHMAC(material)
+		key.ID = id
+		return key, nil
+	case AlgRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(material)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{ID: id, Method: jwt.SigningMethodRS256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case AlgES256:
+		private, err := jwt.ParseECPrivateKeyFromPEM(material)
+		if err != nil {
+			return Key{}, err
+		}
+		if private.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("%s requires P-256 key", alg)
+		}
+		return Key{ID: id, Method: jwt.SigningMethodES256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case AlgEdDSA:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(material)
+		if err != nil {
+			return Key{}, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return Key{}, jwt.ErrNotEdPrivateKey
+		}
+		return Key{ID: id, Method: jwt.SigningMethodEdDSA, SignKey: signer, VerifyKey: signer.Public()}, nil
+	}
+	return Key{}, ErrUnsupportedAlg
+}
+
+// Marshal возвращает секрет HMAC как есть, закрытые ключи в PEM (PKCS #8)
+func Marshal(k Key) ([]byte, error) {
+	if secret, ok := k.SignKey.([]byte); ok {
+		return secret, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.SignKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Generate создаёт новый ключ алгоритма alg со случайным ID
+func Generate(alg string) (Key, error) {
+	id, err := newID()
+	if err != nil {
+		return Key{}, err
+	}
+	switch alg {
+	case AlgHS512:
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return Key{}, err
+		}
+		key := NewHMAC(secret)
+		key.ID = id
+		return key, nil
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{ID: id, Method: jwt.SigningMethodRS256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case AlgES256:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{ID: id, Method: jwt.SigningMethodES256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Key{}, err
+		}
+		return Key{ID: id, Method: jwt.SigningMethodEdDSA, SignKey: private, VerifyKey: public}, nil
+	}
+	return Key{}, ErrUnsupportedAlg
+}
+
+func newID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// JWK возвращает открытый ключ в формате RFC 7517. Для HMAC ключей ok равен false
+func (k Key) JWK() (jwk JWK, ok bool) {
+	jwk = JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+	switch public := k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encode(public)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,19 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoadWithoutKey(t *testing.T) {
+	if _, err := Load(AlgEdDSA, "", false); !errors.Is(err, ErrKeyNotSet) {
+		t.Fatalf("err = %v, want %v", err, ErrKeyNotSet)
+	}
+	key, err := Load(AlgEdDSA, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "" || key.SignKey == nil {
+		t.Fatalf("temporary key = %+v", key)
+	}
+}
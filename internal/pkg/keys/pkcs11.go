@@ -0,0 +1,115 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrKeyNotFound = errors.New("pkcs11 key isn't found")
+
+// PKCS11Config токен PKCS#11 с ключом подписи: библиотека модуля (например, libsofthsm2.so),
+// метка токена, PIN пользователя и метка пары ключей
+type PKCS11Config struct {
+	Module     string
+	TokenLabel string
+	PIN        string
+	KeyLabel   string
+}
+
+// LoadPKCS11 находит пару ключей на токене PKCS#11. Закрытый ключ не покидает токен,
+// подпись выполняется модулем. Поддерживаются RS256 и ES256, close закрывает сессии модуля
+func LoadPKCS11(alg string, config PKCS11Config) (key Key, close func() error, err error) {
+	if alg != AlgRS256 && alg != AlgES256 {
+		return Key{}, nil, fmt.Errorf("%w for pkcs11: %s", ErrUnsupportedAlg, alg)
+	}
+	ctx, err := crypto11.Configure(&crypto11.Config{Path: config.Module, TokenLabel: config.TokenLabel, Pin: config.PIN})
+	if err != nil {
+		return Key{}, nil, err
+	}
+	signer, err := ctx.FindKeyPair(nil, []byte(config.KeyLabel))
+	if err == nil && signer == nil {
+		err = ErrKeyNotFound
+	}
+	if err == nil {
+		key, err = NewSigner(alg, signer)
+	}
+	if err != nil {
+		ctx.Close()
+		return Key{}, nil, err
+	}
+	return key, ctx.Close, nil
+}
+
+// NewSigner создаёт ключ подписи из crypto.Signer, закрытая часть которого недоступна процессу.
+// Открытый ключ используется для проверки и публикуется в JWKS
+func NewSigner(alg string, signer crypto.Signer) (Key, error) {
+	key := Key{SignKey: signer, VerifyKey: signer.Public()}
+	switch public := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if alg != AlgRS256 {
+			return Key{}, fmt.Errorf("%s doesn't match rsa key", alg)
+		}
+		key.Method = signerMethod{alg: alg, hash: crypto.SHA256}
+	case *ecdsa.PublicKey:
+		if alg != AlgES256 {
+			return Key{}, fmt.Errorf("%s doesn't match ecdsa key", alg)
+		}
+		if public.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("%s requires P-256 key", alg)
+		}
+		key.Method = signerMethod{alg: alg, hash: crypto.SHA256, ecSize: 32}
+	default:
+		return Key{}, ErrUnsupportedAlg
+	}
+	return key, nil
+}
+
+// signerMethod подписывает токен через crypto.Signer. Стандартные методы jwt требуют закрытый ключ
+// в памяти, поэтому используются только для проверки подписи
+type signerMethod struct {
+	alg    string
+	hash   crypto.Hash
+	ecSize int
+}
+
+func (m signerMethod) Alg() string {
+	return m.alg
+}
+
+func (m signerMethod) Verify(signingString string, sig []byte, key interface{}) error {
+	return jwt.GetSigningMethod(m.alg).Verify(signingString, sig, key)
+}
+
+func (m signerMethod) Sign(signingString string, key interface{}) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	h := m.hash.New()
+	h.Write([]byte(signingString))
+	sig, err := signer.Sign(rand.Reader, h.Sum(nil), m.hash)
+	if err != nil || m.ecSize == 0 {
+		return sig, err
+	}
+	// ECDSA подпись возвращается в DER, JWS требует r и s фиксированной длины подряд (RFC 7518 3.4)
+	var ecSig struct {
+		R, S *big.Int
+	}
+	if _, err = asn1.Unmarshal(sig, &ecSig); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2*m.ecSize)
+	ecSig.R.FillBytes(out[:m.ecSize])
+	ecSig.S.FillBytes(out[m.ecSize:])
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// softHSMModules пути libsofthsm2.so в распространённых дистрибутивах, SOFTHSM2_MODULE задаёт путь явно
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// newSoftHSM создаёт токен SoftHSM в каталоге теста. Тест пропускается, если SoftHSM не установлен
+func newSoftHSM(t *testing.T) PKCS11Config {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, path := range softHSMModules {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(path); err == nil {
+			module = path
+		}
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if module == "" || err != nil {
+		t.Skip("softhsm2 isn't installed")
+	}
+
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err = os.Mkdir(tokens, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err = os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	config := PKCS11Config{Module: module, TokenLabel: "auth-test", PIN: "1234", KeyLabel: "jwt"}
+	out, err := exec.Command(util, "--init-token", "--free", "--label", config.TokenLabel, "--pin", config.PIN, "--so-pin", "123456").CombinedOutput()
+	if err != nil {
+		t.Fatalf("softhsm2-util: %v: %s", err, out)
+	}
+	return config
+}
+
+func TestLoadPKCS11SignsWithJWKSKey(t *testing.T) {
+	config := newSoftHSM(t)
+	tests := []struct {
+		alg      string
+		label    string
+		generate func(ctx *crypto11.Context, id []byte, label []byte) error
+	}{
+		{AlgES256, "jwt-es256", func(ctx *crypto11.Context, id []byte, label []byte) error {
+			_, err := ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P256())
+			return err
+		}},
+		{AlgRS256, "jwt-rs256", func(ctx *crypto11.Context, id []byte, label []byte) error {
+			_, err := ctx.GenerateRSAKeyPairWithLabel(id, label, 2048)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			ctx, err := crypto11.Configure(&crypto11.Config{Path: config.Module, TokenLabel: config.TokenLabel, Pin: config.PIN})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.generate(ctx, []byte(tt.label), []byte(tt.label))
+			ctx.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyConfig := config
+			keyConfig.KeyLabel = tt.label
+			key, closeToken, err := LoadPKCS11(tt.alg, keyConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeToken()
+
+			signed, err := jwt.NewWithClaims(key.Method, jwt.MapClaims{"sub": "pkcs11"}).SignedString(key.SignKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwk, ok := key.JWK()
+			if !ok || jwk.Alg != tt.alg {
+				t.Fatalf("JWK() = %+v, %v", jwk, ok)
+			}
+			public := publicKey(t, jwk)
+			token, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return public, nil }, jwt.WithValidMethods([]string{tt.alg}))
+			if err != nil || !token.Valid {
+				t.Fatalf("token signed on the token isn't verified with the jwks key: %v", err)
+			}
+
+			missing := config
+			missing.KeyLabel = "missing"
+			if _, _, err = LoadPKCS11(tt.alg, missing); err == nil {
+				t.Fatal("missing key is loaded")
+			}
+		})
+	}
+}
+
+// publicKey восстанавливает открытый ключ из JWK так же, как его получает сервис, проверяющий токены
+func publicKey(t *testing.T, jwk JWK) interface{} {
+	t.Helper()
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk.Kty {
+	case "EC":
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(jwk.X), Y: decode(jwk.Y)}
+	case "RSA":
+		return &rsa.PublicKey{N: decode(jwk.N), E: int(decode(jwk.E).Int64())}
+	}
+	t.Fatalf("unexpected jwk %+v", jwk)
+	return nil
+}
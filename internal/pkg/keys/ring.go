@@ -0,0 +1,305 @@
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Ring набор ключей: один активный ключ подписи и ключи, которыми ещё проверяются ранее выпущенные токены
+type Ring struct {
+	mu     sync.RWMutex
+	legacy Key
+	// legacyExpired ключ из конфигурации заменён ключом из хранилища раньше, чем Retention назад
+	legacyExpired bool
+	active        Key
+	keys          map[string]Key
+}
+
+// NewRing создаёт набор из одного ключа, которым подписываются и проверяются токены без kid
+func NewRing(legacy Key) *Ring {
+	return &Ring{legacy: legacy, active: legacy, keys: map[string]Key{}}
+}
+
+func (r *Ring) Active() Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// Lookup ищет ключ проверки по kid. Токены без kid проверяются ключом из конфигурации, пока он не истёк
+func (r *Ring) Lookup(kid string) (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if kid == "" {
+		return r.legacy, !r.legacyExpired
+	}
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+// Keys возвращает все ключи проверки, включая ещё не активированные
+func (r *Ring) Keys() []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]Key, 0, len(r.keys)+1)
+	if !r.legacyExpired {
+		keys = append(keys, r.legacy)
+	}
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (r *Ring) set(active Key, keys map[string]Key, legacyExpired bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = active
+	r.keys = keys
+	r.legacyExpired = legacyExpired
+}
+
+// StoredKey ключ в хранилище. Ключ становится активным в ActivatesAt, чтобы до этого момента
+// все экземпляры сервиса успели загрузить его для проверки
+type StoredKey struct {
+	ID          string    `json:"kid"`
+	Alg         string    `json:"alg"`
+	Material    []byte    `json:"material"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatesAt time.Time `json:"activates_at"`
+}
+
+type Store interface {
+	GetSigningKeys() (keys []StoredKey, err error)
+	AddSigningKey(key StoredKey) (err error)
+	DeleteSigningKey(kid string) (err error)
+}
+
+var ErrRotationLocked = errors.New("signing key rotation is in progress")
+
+// Locker хранилище, общее для нескольких экземпляров сервиса, блокирует ротацию, чтобы экземпляры
+// не создали новые ключи одновременно. Хранилище без блокировки должен использовать один экземпляр
+type Locker interface {
+	// TryLockRotation возвращает ErrRotationLocked, если ротацию выполняет другой экземпляр
+	TryLockRotation() (unlock func(), err error)
+}
+
+// FileStore хранит каждый ключ в отдельном JSON файле каталога Dir. Ротация не блокируется,
+// поэтому каталог не должен быть общим для нескольких экземпляров с включённой ротацией
+type FileStore struct {
+	Dir string
+}
+
+func (fs FileStore) GetSigningKeys() ([]StoredKey, error) {
+	files, err := filepath.Glob(filepath.Join(fs.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]StoredKey, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var key StoredKey
+		if err = json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (fs FileStore) AddSigningKey(key StoredKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(fs.Dir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(fs.path(key.ID), data, 0o600)
+}
+
+func (fs FileStore) DeleteSigningKey(kid string) error {
+	err := os.Remove(fs.path(kid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (fs FileStore) path(kid string) string {
+	return filepath.Join(fs.Dir, strings.ReplaceAll(kid, string(filepath.Separator), "_")+".json")
+}
+
+type ManagerConfig struct {
+	Alg string
+	// Interval период ротации, 0 отключает автоматическую ротацию
+	Interval time.Duration
+	// Retention сколько хранится ключ после замены, должен быть не меньше времени жизни refresh токена.
+	// Ключ из конфигурации перестаёт проверять токены через Retention после активации первого ключа из хранилища
+	Retention time.Duration
+	// Propagation задержка активации нового ключа
+	Propagation time.Duration
+	// Reload период перечитывания хранилища
+	Reload time.Duration
+}
+
+// Manager перечитывает ключи из хранилища и выполняет плановую ротацию
+type Manager struct {
+	store  Store
+	config ManagerConfig
+	ring   *Ring
+}
+
+func NewManager(store Store, legacy Key, config ManagerConfig) *Manager {
+	return &Manager{store: store, config: config, ring: NewRing(legacy)}
+}
+
+func (m *Manager) Ring() *Ring {
+	return m.ring
+}
+
+// Reload загружает ключи из хранилища, удаляет устаревшие и выбирает активный ключ
+func (m *Manager) Reload() error {
+	stored, err := m.store.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ActivatesAt.Before(stored[j].ActivatesAt) })
+
+	now := time.Now()
+	active := m.ring.legacy
+	// ключи удаляются по порядку, поэтому самый старый оставшийся ключ активирован не раньше,
+	// чем был заменён ключ из конфигурации
+	legacyExpired := len(stored) > 0 && stored[0].ActivatesAt.Add(m.config.Retention).Before(now)
+	keys := make(map[string]Key, len(stored))
+	for i, sk := range stored {
+		if i+1 < len(stored) && stored[i+1].ActivatesAt.Add(m.config.Retention).Before(now) {
+			logrus.Infof("signing key %s expired", sk.ID)
+			if err = m.store.DeleteSigningKey(sk.ID); err != nil {
+				logrus.Warn(err)
+			}
+			continue
+		}
+		key, err := Parse(sk.ID, sk.Alg, sk.Material)
+		if err != nil {
+			logrus.Errorf("signing key %s: %v", sk.ID, err)
+			continue
+		}
+		keys[key.ID] = key
+		if !sk.ActivatesAt.After(now) {
+			active = key
+		}
+	}
+	m.ring.set(active, keys, legacyExpired)
+	return nil
+}
+
+// Rotate создаёт новый ключ, который станет активным через Propagation.
+// Если хранилище блокирует ротацию и её выполняет другой экземпляр, возвращается ErrRotationLocked
+func (m *Manager) Rotate() error {
+	unlock, err := m.lockRotation()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return m.rotate()
+}
+
+// rotateIfDue создаёт новый ключ, если активный старше Interval. Срок проверяется под блокировкой,
+// поэтому экземпляр, дождавшийся ротации другого экземпляра, не создаёт ещё один ключ
+func (m *Manager) rotateIfDue() error {
+	unlock, err := m.lockRotation()
+	if errors.Is(err, ErrRotationLocked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !m.rotationDue() {
+		return nil
+	}
+	return m.rotate()
+}
+
+func (m *Manager) lockRotation() (func(), error) {
+	locker, ok := m.store.(Locker)
+	if !ok {
+		return func() {}, nil
+	}
+	return locker.TryLockRotation()
+}
+
+func (m *Manager) rotate() error {
+	key, err := Generate(m.config.Alg)
+	if err != nil {
+		return err
+	}
+	material, err := Marshal(key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = m.store.AddSigningKey(StoredKey{
+		ID:          key.ID,
+		Alg:         m.config.Alg,
+		Material:    material,
+		CreatedAt:   now,
+		ActivatesAt: now.Add(m.config.Propagation),
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("signing key %s created, activates in %v", key.ID, m.config.Propagation)
+	return m.Reload()
+}
+
+// Run периодически перечитывает хранилище и создаёт новый ключ, когда активный старше Interval
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Reload)
+	defer ticker.Stop()
+	for {
+		if err := m.Reload(); err != nil {
+			logrus.Error(err)
+		} else if m.rotationDue() {
+			if err = m.rotateIfDue(); err != nil {
+				logrus.Error(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) rotationDue() bool {
+	if m.config.Interval <= 0 {
+		return false
+	}
+	stored, err := m.store.GetSigningKeys()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	var newest time.Time
+	for _, sk := range stored {
+		if sk.Alg == m.config.Alg && sk.CreatedAt.After(newest) {
+			newest = sk.CreatedAt
+		}
+	}
+	return time.Since(newest) >= m.config.Interval
+}
@@ -0,0 +1,108 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// lockedStore хранилище с блокировкой ротации, которую держит другой экземпляр, пока locked
+type lockedStore struct {
+	FileStore
+	locked bool
+}
+
+func (s *lockedStore) TryLockRotation() (func(), error) {
+	if s.locked {
+		return nil, ErrRotationLocked
+	}
+	s.locked = true
+	return func() { s.locked = false }, nil
+}
+
+func storeKey(t *testing.T, store Store, activatesAt time.Time) Key {
+	t.Helper()
+	key, err := Generate(AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	material, err := Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSigningKey(StoredKey{ID: key.ID, Alg: AlgEdDSA, Material: material, CreatedAt: activatesAt, ActivatesAt: activatesAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestManagerLegacyExpiry(t *testing.T) {
+	legacy, err := Generate(AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.ID = ""
+	store := FileStore{Dir: t.TempDir()}
+	manager := NewManager(store, legacy, ManagerConfig{Alg: AlgEdDSA, Retention: time.Hour})
+	if err = manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manager.Ring().Lookup(""); !ok {
+		t.Fatal("legacy key isn't found before rotation")
+	}
+
+	stored := storeKey(t, store, time.Now().Add(-30*time.Minute))
+	if err = manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.Ring().Active().ID != stored.ID {
+		t.Fatalf("active key = %q, want %q", manager.Ring().Active().ID, stored.ID)
+	}
+	if _, ok := manager.Ring().Lookup(""); !ok {
+		t.Fatal("legacy key expired before retention")
+	}
+
+	manager.config.Retention = 10 * time.Minute
+	if err = manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manager.Ring().Lookup(""); ok {
+		t.Fatal("legacy key is found after retention")
+	}
+	for _, key := range manager.Ring().Keys() {
+		if key.ID == "" {
+			t.Fatal("expired legacy key is published")
+		}
+	}
+}
+
+func TestManagerRotationLock(t *testing.T) {
+	store := &lockedStore{FileStore: FileStore{Dir: t.TempDir()}, locked: true}
+	manager := NewManager(store, NewHMAC([]byte("legacy")), ManagerConfig{Alg: AlgEdDSA, Interval: time.Hour, Retention: time.Hour, Propagation: time.Minute})
+
+	if err := manager.Rotate(); !errors.Is(err, ErrRotationLocked) {
+		t.Fatalf("err = %v, want %v", err, ErrRotationLocked)
+	}
+	if err := manager.rotateIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.GetSigningKeys(); len(stored) != 0 {
+		t.Fatalf("locked rotation created %d keys", len(stored))
+	}
+
+	store.locked = false
+	if err := manager.rotateIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	// второй экземпляр получает блокировку после первого и видит созданный ключ
+	if err := manager.rotateIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.GetSigningKeys(); len(stored) != 1 {
+		t.Fatalf("rotation created %d keys, want 1", len(stored))
+	}
+	if store.locked {
+		t.Fatal("rotation lock isn't released")
+	}
+}
@@ -0,0 +1,236 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Версии и назначения токенов PASETO v4. Заголовок токена однозначно задаёт алгоритм,
+// поэтому подмена алгоритма, возможная в JWT, исключена
+const (
+	VersionLocal  = "v4.local"
+	VersionPublic = "v4.public"
+)
+
+const (
+	nonceSize = 32
+	macSize   = 32
+	keySize   = 32
+)
+
+var ErrInvalidToken = errors.New("invalid paseto token")
+var ErrInvalidKey = errors.New("invalid paseto key")
+var ErrKeyNotSet = errors.New("paseto key isn't set")
+
+// Keys ключи PASETO: симметричный ключ v4.local и ключ Ed25519 v4.public
+type Keys struct {
+	Local  []byte
+	Secret ed25519.PrivateKey
+	Public ed25519.PublicKey
+}
+
+// LoadKeys читает ключ v4.local из hex строки и закрытый ключ v4.public из PEM файла (PKCS #8).
+// Не заданный ключ остаётся пустым, и токены этой версии не выпускаются и не проверяются.
+// Если не задан ни один ключ, возвращается ErrKeyNotSet. При allowTemporary не заданный ключ
+// создаётся временным и теряется при перезапуске
+func LoadKeys(localKey string, secretKeyFile string, allowTemporary bool) (*Keys, error) {
+	if localKey == "" && secretKeyFile == "" && !allowTemporary {
+		return nil, ErrKeyNotSet
+	}
+	keys := &Keys{}
+	switch {
+	case localKey != "":
+		local, err := hex.DecodeString(localKey)
+		if err != nil || len(local) != keySize {
+			return nil, ErrInvalidKey
+		}
+		keys.Local = local
+	case allowTemporary:
+		logrus.Warnf("%s key isn't set, generating temporary key", VersionLocal)
+		keys.Local = make([]byte, keySize)
+		if _, err := rand.Read(keys.Local); err != nil {
+			return nil, err
+		}
+	}
+
+	if secretKeyFile == "" {
+		if !allowTemporary {
+			return keys, nil
+		}
+		logrus.Warnf("%s key isn't set, generating temporary key", VersionPublic)
+		public, secret, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keys.Secret, keys.Public = secret, public
+		return keys, nil
+	}
+	pemKey, err := os.ReadFile(secretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	private, err := jwt.ParseEdPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := private.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	keys.Secret, keys.Public = secret, secret.Public().(ed25519.PublicKey)
+	return keys, nil
+}
+
+// Encrypt создаёт токен v4.local: XChaCha20 с ключами, выведенными BLAKE2b из ключа и случайного nonce,
+// и MAC BLAKE2b по PAE заголовка, nonce, шифртекста, footer и implicit
+func Encrypt(key []byte, payload []byte, footer []byte, implicit []byte) (string, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encrypt(key, nonce, payload, footer, implicit)
+}
+
+func encrypt(key []byte, nonce []byte, payload []byte, footer []byte, implicit []byte) (string, error) {
+	if len(key) != keySize {
+		return "", ErrInvalidKey
+	}
+	encryptionKey, counterNonce, authKey := splitKey(key, nonce)
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(payload))
+	cipher.XORKeyStream(ciphertext, payload)
+
+	header := VersionLocal + "."
+	body := append(append(bytes.Clone(nonce), ciphertext...), mac(authKey, header, nonce, ciphertext, footer, implicit)...)
+	return encode(header, body, footer), nil
+}
+
+// Decrypt проверяет MAC токена v4.local и возвращает расшифрованные данные и footer
+func Decrypt(key []byte, token string, implicit []byte) (payload []byte, footer []byte, err error) {
+	if len(key) != keySize {
+		return nil, nil, ErrInvalidKey
+	}
+	header := VersionLocal + "."
+	body, footer, err := decode(header, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < nonceSize+macSize {
+		return nil, nil, ErrInvalidToken
+	}
+	nonce, ciphertext, tag := body[:nonceSize], body[nonceSize:len(body)-macSize], body[len(body)-macSize:]
+	encryptionKey, counterNonce, authKey := splitKey(key, nonce)
+	if subtle.ConstantTimeCompare(tag, mac(authKey, header, nonce, ciphertext, footer, implicit)) != 1 {
+		return nil, nil, ErrInvalidToken
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload = make([]byte, len(ciphertext))
+	cipher.XORKeyStream(payload, ciphertext)
+	return payload, footer, nil
+}
+
+// Sign создаёт токен v4.public, подпись Ed25519 вычисляется по PAE заголовка, данных, footer и implicit
+func Sign(secret ed25519.PrivateKey, payload []byte, footer []byte, implicit []byte) string {
+	header := VersionPublic + "."
+	signature := ed25519.Sign(secret, pae([]byte(header), payload, footer, implicit))
+	return encode(header, append(bytes.Clone(payload), signature...), footer)
+}
+
+// Verify проверяет подпись токена v4.public и возвращает данные и footer
+func Verify(public ed25519.PublicKey, token string, implicit []byte) (payload []byte, footer []byte, err error) {
+	if len(public) != ed25519.PublicKeySize {
+		return nil, nil, ErrInvalidKey
+	}
+	header := VersionPublic + "."
+	body, footer, err := decode(header, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, ErrInvalidToken
+	}
+	payload, signature := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(public, pae([]byte(header), payload, footer, implicit), signature) {
+		return nil, nil, ErrInvalidToken
+	}
+	return payload, footer, nil
+}
+
+// splitKey выводит ключ шифрования, nonce XChaCha20 и ключ MAC (PASETO v4.local, шаг 4)
+func splitKey(key []byte, nonce []byte) (encryptionKey []byte, counterNonce []byte, authKey []byte) {
+	tmp := blake2bSum(key, 56, []byte("paseto-encryption-key"), nonce)
+	authKey = blake2bSum(key, 32, []byte("paseto-auth-key-for-aead"), nonce)
+	return tmp[:32], tmp[32:], authKey
+}
+
+func mac(authKey []byte, header string, nonce []byte, ciphertext []byte, footer []byte, implicit []byte) []byte {
+	return blake2bSum(authKey, macSize, pae([]byte(header), nonce, ciphertext, footer, implicit))
+}
+
+func blake2bSum(key []byte, size int, parts ...[]byte) []byte {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		// размер и длина ключа фиксированы, ошибка означает ошибку в коде
+		panic(err)
+	}
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// pae pre-authentication encoding: число частей и длина каждой части в little-endian 64 бит
+func pae(pieces ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(piece))&(1<<63-1))
+		out = append(out, piece...)
+	}
+	return out
+}
+
+func encode(header string, body []byte, footer []byte) string {
+	token := header + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) != 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+func decode(header string, token string) (body []byte, footer []byte, err error) {
+	rest, ok := strings.CutPrefix(token, header)
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	encodedBody, encodedFooter, hasFooter := strings.Cut(rest, ".")
+	body, err = base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if hasFooter {
+		footer, err = base64.RawURLEncoding.DecodeString(encodedFooter)
+		if err != nil {
+			return nil, nil, ErrInvalidToken
+		}
+	}
+	return body, footer, nil
+}
@@ -0,0 +1,137 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// ключи и токены тестовых векторов PASETO v4 4-E-1 и 4-S-1
+const (
+	testLocalKey  = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	testSecretKey = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	localPayload  = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	localToken    = "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg"
+	publicPayload = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	publicToken   = "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLocalVector(t *testing.T) {
+	key := decodeHex(t, testLocalKey)
+	token, err := encrypt(key, make([]byte, nonceSize), []byte(localPayload), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != localToken {
+		t.Fatalf("encrypt() = %s, want %s", token, localToken)
+	}
+	payload, footer, err := Decrypt(key, localToken, nil)
+	if err != nil || string(payload) != localPayload || len(footer) != 0 {
+		t.Fatalf("Decrypt() = %s, %s, %v", payload, footer, err)
+	}
+}
+
+func TestPublicVector(t *testing.T) {
+	secret := ed25519.PrivateKey(decodeHex(t, testSecretKey))
+	if token := Sign(secret, []byte(publicPayload), nil, nil); token != publicToken {
+		t.Fatalf("Sign() = %s, want %s", token, publicToken)
+	}
+	payload, footer, err := Verify(secret.Public().(ed25519.PublicKey), publicToken, nil)
+	if err != nil || string(payload) != publicPayload || len(footer) != 0 {
+		t.Fatalf("Verify() = %s, %s, %v", payload, footer, err)
+	}
+}
+
+func TestLocalRejects(t *testing.T) {
+	key := decodeHex(t, testLocalKey)
+	token, err := Encrypt(key, []byte("payload"), []byte(`{"kid":"k1"}`), []byte("implicit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, footer, err := Decrypt(key, token, []byte("implicit"))
+	if err != nil || string(payload) != "payload" || string(footer) != `{"kid":"k1"}` {
+		t.Fatalf("Decrypt() = %s, %s, %v", payload, footer, err)
+	}
+
+	otherKey := bytes.Repeat([]byte{1}, keySize)
+	tampered := []byte(token)
+	tampered[len(VersionLocal)+10] ^= 1
+	tests := []struct {
+		name     string
+		key      []byte
+		token    string
+		implicit string
+	}{
+		{"other key", otherKey, token, "implicit"},
+		{"other implicit", key, token, "other"},
+		{"tampered", key, string(tampered), "implicit"},
+		{"public header", key, strings.Replace(token, VersionLocal, VersionPublic, 1), "implicit"},
+		{"short key", key[:16], token, "implicit"},
+	}
+	for _, tt := range tests {
+		if _, _, err := Decrypt(tt.key, tt.token, []byte(tt.implicit)); err == nil {
+			t.Fatalf("%s: token is accepted", tt.name)
+		}
+	}
+}
+
+func TestPublicRejects(t *testing.T) {
+	secret := ed25519.PrivateKey(decodeHex(t, testSecretKey))
+	public := secret.Public().(ed25519.PublicKey)
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := Sign(secret, []byte(publicPayload), nil, []byte("implicit"))
+	tests := []struct {
+		name     string
+		key      ed25519.PublicKey
+		token    string
+		implicit string
+	}{
+		{"other key", otherPublic, token, "implicit"},
+		{"other implicit", public, token, ""},
+		{"tampered payload", public, strings.Replace(token, "eyJkYXRh", "eyJkYXRi", 1), "implicit"},
+		{"local header", public, strings.Replace(token, VersionPublic, VersionLocal, 1), "implicit"},
+	}
+	for _, tt := range tests {
+		if _, _, err := Verify(tt.key, tt.token, []byte(tt.implicit)); err == nil {
+			t.Fatalf("%s: token is accepted", tt.name)
+		}
+	}
+}
+
+func TestLoadKeysWithoutKey(t *testing.T) {
+	if _, err := LoadKeys("", "", false); !errors.Is(err, ErrKeyNotSet) {
+		t.Fatalf("err = %v, want %v", err, ErrKeyNotSet)
+	}
+
+	// ключ другой версии не создаётся временным
+	keys, err := LoadKeys(testLocalKey, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Local == nil || keys.Secret != nil || keys.Public != nil {
+		t.Fatalf("keys = %+v", keys)
+	}
+
+	keys, err = LoadKeys("", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Local == nil || keys.Secret == nil || keys.Public == nil {
+		t.Fatalf("temporary keys = %+v", keys)
+	}
+}
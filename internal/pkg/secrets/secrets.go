@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Источники секретов: переменные окружения, файлы (секреты Docker и Kubernetes) и хранилище,
+// совместимое с KV v2 HashiCorp Vault
+const (
+	ProviderEnv   = "env"
+	ProviderFile  = "file"
+	ProviderVault = "vault"
+)
+
+var ErrNotFound = errors.New("secret isn't found")
+var ErrWeakSecret = errors.New("secret entropy is too low")
+var ErrUnsupportedProvider = errors.New("unsupported secret provider")
+
+type Provider interface {
+	Secret(name string) (value string, err error)
+}
+
+// Env читает секрет из переменной NAME, а если она не задана, из файла, указанного в NAME_FILE
+type Env struct{}
+
+func (Env) Secret(name string) (string, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	if path, ok := os.LookupEnv(name + "_FILE"); ok && path != "" {
+		return readFile(path)
+	}
+	return "", ErrNotFound
+}
+
+// Files читает секрет из файла с именем секрета в каталоге Dir, например /run/secrets/JWT_SECRET.
+// Имя файла в нижнем регистре тоже допускается
+type Files struct {
+	Dir string
+}
+
+func (f Files) Secret(name string) (string, error) {
+	for _, file := range []string{name, strings.ToLower(name)} {
+		value, err := readFile(filepath.Join(f.Dir, file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrNotFound
+}
+
+// Vault читает секреты из хранилища KV v2: GET {Addr}/v1/{Mount}/data/{Path}, секрет ищется
+// по имени среди ключей записи. Подходит любой сервер с тем же API, например vault server -dev
+type Vault struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Mount     string
+	Path      string
+	Client    *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func (v Vault) Secret(name string) (string, error) {
+	url := strings.TrimRight(v.Addr, "/") + "/v1/" + strings.Trim(v.Mount, "/") + "/data/" + strings.Trim(v.Path, "/")
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded with status %d", resp.StatusCode)
+	}
+	var body vaultResponse
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	value, ok := body.Data.Data[name].(string)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// файлы секретов обычно заканчиваются переводом строки, он не входит в секрет
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Entropy оценка энтропии секрета в битах: энтропия Шеннона распределения символов, умноженная на длину.
+// Оценка занижена для коротких случайных строк (32 случайных байта в hex дают около 250 бит,
+// в base64 около 200) и мала для повторяющихся символов и словарных паролей
+func Entropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	length := 0
+	for _, r := range value {
+		counts[r]++
+		length++
+	}
+	var perSymbol float64
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		perSymbol -= p * math.Log2(p)
+	}
+	return perSymbol * float64(length)
+}
+
+// CheckEntropy возвращает ErrWeakSecret, если оценка энтропии секрета меньше minBits
+func CheckEntropy(name string, value string, minBits float64) error {
+	if bits := Entropy(value); bits < minBits {
+		return fmt.Errorf("%w: %s has about %.0f bits, at least %.0f required", ErrWeakSecret, name, bits, minBits)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("TEST_SECRET", "from-env")
+	if value, err := (Env{}).Secret("TEST_SECRET"); err != nil || value != "from-env" {
+		t.Fatalf("Secret() = %q, %v", value, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_FILE_SECRET_FILE", path)
+	if value, err := (Env{}).Secret("TEST_FILE_SECRET"); err != nil || value != "from-file" {
+		t.Fatalf("Secret() = %q, %v", value, err)
+	}
+
+	if _, err := (Env{}).Secret("TEST_MISSING_SECRET"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "JWT_SECRET"), []byte("upper\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bcrypyhash"), []byte("lower"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files := Files{Dir: dir}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{"JWT_SECRET", "upper", nil},
+		{"BCRYPYHASH", "lower", nil},
+		{"JWE_KEYS", "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		value, err := files.Secret(tt.name)
+		if !errors.Is(err, tt.wantErr) || value != tt.want {
+			t.Fatalf("Secret(%s) = %q, %v, want %q, %v", tt.name, value, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/authsystem":
+			w.Write([]byte(`{"data":{"data":{"JWT_SECRET":"from-vault","NUMBER":1},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	vault := Vault{Addr: server.URL + "/", Token: "token", Namespace: "team", Mount: "secret", Path: "/authsystem/", Client: server.Client()}
+	if value, err := vault.Secret("JWT_SECRET"); err != nil || value != "from-vault" {
+		t.Fatalf("Secret() = %q, %v", value, err)
+	}
+	for _, name := range []string{"BCRYPYHASH", "NUMBER"} {
+		if _, err := vault.Secret(name); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Secret(%s): err = %v, want %v", name, err, ErrNotFound)
+		}
+	}
+
+	missing := vault
+	missing.Path = "other"
+	if _, err := missing.Secret("JWT_SECRET"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing path: err = %v, want %v", err, ErrNotFound)
+	}
+
+	forbidden := vault
+	forbidden.Token = "wrong"
+	if _, err := forbidden.Secret("JWT_SECRET"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("wrong token: err = %v", err)
+	}
+}
+
+func TestCheckEntropy(t *testing.T) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckEntropy("JWT_SECRET", hex.EncodeToString(random), 128); err != nil {
+		t.Fatal(err)
+	}
+	for _, weak := range []string{"", "secret", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "abababababababababababababababababababab"} {
+		if err := CheckEntropy("JWT_SECRET", weak, 128); !errors.Is(err, ErrWeakSecret) {
+			t.Fatalf("CheckEntropy(%q): err = %v, want %v", weak, err, ErrWeakSecret)
+		}
+	}
+}
@@ -2,133 +2,836 @@ package authsystem
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/config"
 	"github.com/sater-151/AuthSystem/internal/database/postgresql"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/denylist"
+	"github.com/sater-151/AuthSystem/internal/pkg/dpop"
+	"github.com/sater-151/AuthSystem/internal/pkg/jwe"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+	"github.com/sater-151/AuthSystem/internal/pkg/paseto"
 	"github.com/sater-151/AuthSystem/internal/pkg/webhooks"
 	"github.com/sater-151/AuthSystem/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
 type AuthSystem interface {
-	Login(guid string, host string, ip string) (aToken string, rToken string, err error)
-	RefreshTokens(at string, rt string, userAgent string, ip string) (aToken string, rToken string, err error)
-	CheckTokens(aToken string, rToken string) (err error)
-	CompareRT(rToken, guid string) (ok bool, err error)
-	Logout(guid string) (err error)
-	GetGUID(aToken string) (guid string, err error)
+	ResolveTenant(tenantID string, host string) (tenant models.Tenant, err error)
+	Login(tenant models.Tenant, guid string, userAgent string, ip string, scope string, jkt string) (tokens models.Tokens, err error)
+	RefreshTokens(tenant models.Tenant, at string, rt string, userAgent string, ip string, scope string, jkt string) (tokens models.Tokens, err error)
+	TokenScope(tenant models.Tenant, aToken string) (scope string, err error)
+	CheckTokens(tenant models.Tenant, aToken string, rToken string) (err error)
+	CheckAccessToken(tenant models.Tenant, aToken string) (err error)
+	Logout(tenant models.Tenant, aToken string) (err error)
+	GetGUID(tenant models.Tenant, aToken string) (guid string, err error)
+	CheckStatus(tenant models.Tenant, aToken string) (err error)
+	GetProfile(tenant models.Tenant, aToken string) (profile models.Profile, err error)
+	UpdateProfile(tenant models.Tenant, aToken string, email *string, displayName *string) (err error)
+	Impersonate(tenant models.Tenant, aToken string, guid string, reason string, userAgent string, ip string) (impToken string, expiresIn time.Duration, err error)
+	GetActor(tenant models.Tenant, aToken string) (actor string, err error)
+	JWKS() (jwks []keys.JWK)
+	AuthenticateClient(tenant models.Tenant, clientID string, clientSecret string) (client models.Client, err error)
+	Introspect(tenant models.Tenant, client models.Client, token string, tokenTypeHint string) (introspection models.Introspection, err error)
+	Revoke(tenant models.Tenant, client models.Client, token string, tokenTypeHint string) (err error)
+	RevokeUser(tenant models.Tenant, aToken string, guid string, ip string) (err error)
+	RegisterClient(tenant models.Tenant, aToken string, client models.Client) (registered models.Client, secret string, err error)
+	Authorize(tenant models.Tenant, aToken string, request models.AuthorizationRequest) (client models.Client, scope string, code string, err error)
+	Consent(tenant models.Tenant, aToken string, request models.AuthorizationRequest, approved bool) (client models.Client, scope string, code string, err error)
+	ExchangeCode(tenant models.Tenant, client models.Client, code string, redirectURI string, codeVerifier string, userAgent string, ip string, jkt string) (tokens models.Tokens, err error)
+	RefreshGrant(tenant models.Tenant, client models.Client, rToken string, userAgent string, ip string, scope string, jkt string) (tokens models.Tokens, err error)
+	ClientCredentials(tenant models.Tenant, client models.Client, scope string, jkt string) (tokens models.Tokens, err error)
+	CheckClientToken(tenant models.Tenant, aToken string) (client models.Client, err error)
+	OpenIDConfiguration() (configuration models.OpenIDConfiguration)
+	UserInfo(tenant models.Tenant, aToken string) (profile models.Profile, err error)
+	DeviceAuthorization(tenant models.Tenant, client models.Client, scope string) (authorization models.DeviceAuthorization, err error)
+	GetDeviceRequest(tenant models.Tenant, aToken string, userCode string) (client models.Client, code models.DeviceCode, err error)
+	ApproveDevice(tenant models.Tenant, aToken string, userCode string, approved bool) (err error)
+	DeviceCodeGrant(tenant models.Tenant, client models.Client, deviceCode string, userAgent string, ip string, jkt string) (tokens models.Tokens, err error)
+	ExchangeToken(tenant models.Tenant, client models.Client, subjectToken string, subjectTokenType string, audience string, scope string) (tokens models.Tokens, err error)
+	DPoPProof(tenant models.Tenant, proof string, method string, uri string, aToken string) (jkt string, err error)
+	CheckDPoP(tenant models.Tenant, aToken string, proof string, method string, uri string) (jkt string, err error)
+	DPoPNonce() (nonce string)
 }
 
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+const (
+	StatusActive    = "active"
+	StatusDisabled  = "disabled"
+	StatusSuspended = "suspended"
+)
+
 type AuthSystemManager struct {
-	db postgresql.Postgresql
-	wh webhooks.WebHooks
+	db          postgresql.Postgresql
+	wh          webhooks.WebHooks
+	tokenConfig config.TokenConfig
+	ring        *keys.Ring
+	denylist    *denylist.Denylist
+	paseto      *paseto.Keys
+	encryption  *jwe.Ring
+	nonces      *dpop.Nonces
+}
+
+// New создаёт сервис авторизации. Токены подписываются ключами из ring,
+// тенант с собственным секретом (только при подписи HS512) использует только свой ключ.
+// Отозванные до истечения access токены хранятся в denylist. Ключи PASETO и ключи шифрования JWE нужны, только если
+// токены выпускаются или выпускались в соответствующем формате
+func New(db postgresql.Postgresql, wh webhooks.WebHooks, tokenConfig config.TokenConfig, ring *keys.Ring, denylist *denylist.Denylist, pasetoKeys *paseto.Keys, encryption *jwe.Ring) (*AuthSystemManager, error) {
+	nonces, err := dpop.NewNonces(tokenConfig.DPoPNonceKey, tokenConfig.DPoPNonceTTL, tokenConfig.AllowTemporaryKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthSystemManager{db: db, wh: wh, tokenConfig: tokenConfig, ring: ring, denylist: denylist, paseto: pasetoKeys, encryption: encryption, nonces: nonces}, nil
 }
 
-func New(db postgresql.Postgresql, wh webhooks.WebHooks) *AuthSystemManager {
-	authsystem := &AuthSystemManager{db: db, wh: wh}
-	return authsystem
+// ResolveTenant ищет тенант по идентификатору из пути, затем по заголовку Host.
+// Если тенант не указан явно и не найден по хосту, используется тенант по умолчанию.
+// Собственный ключ тенанта (jwt_secret) поддерживается только для HS512, с асимметричными алгоритмами
+// такой тенант подписывал бы токены общим ключом, поэтому он отклоняется
+func (as *AuthSystemManager) ResolveTenant(tenantID string, host string) (models.Tenant, error) {
+	var tenant models.Tenant
+	var err error
+	switch {
+	case tenantID != "":
+		tenant, err = as.db.GetTenant(tenantID)
+	default:
+		tenant, err = as.db.GetTenantByHost(host)
+		if err == sql.ErrNoRows {
+			tenant, err = as.db.GetTenant(models.DefaultTenantID)
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return tenant, apperror.ErrTenantNotFound
+		}
+		return tenant, err
+	}
+	tenant.Issuer = as.tokenConfig.Issuer
+	tenant.Audience = as.tokenConfig.Audience
+	tenant.Leeway = as.tokenConfig.Leeway
+	tenant.Format = as.tokenConfig.Format
+	tenant.Paseto = as.paseto
+	tenant.Encryption = as.encryption
+	tenant.Keys = as.ring
+	if len(tenant.Secret) != 0 {
+		if as.tokenConfig.Algorithm != keys.AlgHS512 {
+			return tenant, apperror.ErrTenantKeyUnsupported
+		}
+		tenant.Keys = keys.NewRing(keys.NewHMAC(tenant.Secret))
+	}
+	if tenant.AccessTTL == 0 {
+		tenant.AccessTTL = as.tokenConfig.AccessTTL
+	}
+	if tenant.RefreshTTL == 0 {
+		tenant.RefreshTTL = as.tokenConfig.RefreshTTL
+	}
+	return tenant, nil
 }
 
-func (as *AuthSystemManager) Login(guid string, userAgent string, ip string) (aToken string, rToken string, err error) {
+// Login выпускает токены пользователя. Без scope в запросе выдаётся scope по умолчанию, запрошенный scope
+// должен в него входить. Непустой jkt привязывает токены сессии к ключу DPoP
+func (as *AuthSystemManager) Login(tenant models.Tenant, guid string, userAgent string, ip string, scope string, jkt string) (models.Tokens, error) {
 	logrus.Debug("starting authorization")
-	aToken, rToken, err = utils.NewTokens(userAgent, guid)
+	scope, err := downScope(as.tokenConfig.DefaultScope, scope)
 	if err != nil {
-		return aToken, rToken, err
+		return models.Tokens{}, err
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return models.Tokens{}, err
 	}
-	err = as.db.LoginDB(guid, rToken, userAgent, ip)
+	tokens, err := as.issueTokens(tenant, guid, userAgent, ip, scope, jkt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return aToken, rToken, apperror.ErrUnauthorized
+			return models.Tokens{}, apperror.ErrUnauthorized
 
 		}
-		return aToken, rToken, err
+		return models.Tokens{}, err
+	}
+	if err = as.db.SetAuthenticatedAt(tenant.ID, guid); err != nil {
+		return models.Tokens{}, err
 	}
 	logrus.Debug("user logged")
-	return aToken, rToken, nil
+	return tokens, nil
 }
 
-func (as *AuthSystemManager) RefreshTokens(at string, rt string, userAgent string, ip string) (aToken string, rToken string, err error) {
+// RefreshTokens обновляет пару токенов. Scope нового токена можно только сузить относительно выданного в сессии.
+// Сессия, привязанная к ключу DPoP, обновляется только с proof этого же ключа
+func (as *AuthSystemManager) RefreshTokens(tenant models.Tenant, at string, rt string, userAgent string, ip string, scope string, jkt string) (models.Tokens, error) {
 	logrus.Debug("refreshing tokens")
 
-	guid, err := utils.GetGUIDFromJWT(at)
+	guid, err := utils.GetGUIDFromJWT(tenant, at)
 	if err != nil {
-		return aToken, rToken, err
+		if err == apperror.ErrTenantMismatch {
+			return models.Tokens{}, apperror.ErrUnauthorized
+		}
+		return models.Tokens{}, err
 	}
 
-	ok, err := as.CompareRT(rt, guid)
+	sessionGUID, session, err := as.sessionByRefreshToken(tenant, rt)
 	if err != nil {
-		return aToken, rToken, err
+		if err == sql.ErrNoRows {
+			return models.Tokens{}, apperror.ErrUnauthorized
+		}
+		return models.Tokens{}, err
 	}
-	if !ok {
-		return aToken, rToken, apperror.ErrUnauthorized
+	if sessionGUID != guid {
+		return models.Tokens{}, apperror.ErrUnauthorized
 	}
 
-	logrus.Debug("getting user info")
-	oldUserAgent, oldUserIp, err := as.db.GetUserInfo(guid)
-	if err != nil {
-		return aToken, rToken, err
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return models.Tokens{}, err
 	}
-	if userAgent != oldUserAgent {
-		as.Logout(aToken)
-		return aToken, rToken, apperror.ErrUnauthorized
+
+	if userAgent != session.UserAgent {
+		as.Logout(tenant, at)
+		return models.Tokens{}, apperror.ErrUnauthorized
 	}
-	if ip != oldUserIp {
+	if ip != session.IP {
 		as.wh.SendMessageAboutAnotherIp()
 	}
 
-	logrus.Debug("generating new tokens")
-	aToken, rToken, err = utils.NewTokens(userAgent, guid)
+	scope, err = downScope(as.sessionScope(session), scope)
 	if err != nil {
-		return aToken, rToken, err
+		return models.Tokens{}, err
+	}
+	if err = checkSessionKey(session, jkt); err != nil {
+		return models.Tokens{}, err
 	}
 
-	err = as.db.LoginDB(guid, rToken, userAgent, ip)
+	logrus.Debug("generating new tokens")
+	tokens, err := as.issueTokens(tenant, guid, userAgent, ip, scope, jkt)
 	if err != nil {
-		return aToken, rToken, err
+		return models.Tokens{}, err
 	}
 
 	logrus.Debug("tokens refreshed")
-	return aToken, rToken, nil
+	return tokens, nil
+}
+
+// issueTokens выпускает пару токенов и сохраняет хеш refresh токена вместе с jti access токена,
+// чтобы при завершении сессии access токен можно было отозвать. Сессия привязывается к ключу DPoP jkt
+func (as *AuthSystemManager) issueTokens(tenant models.Tenant, guid string, userAgent string, ip string, scope string, jkt string) (models.Tokens, error) {
+	aToken, claims, err := utils.NewAccessToken(tenant, userAgent, guid, "", scope, jkt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	secret, refreshHash, err := utils.NewRefreshToken()
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	sessionID, err := as.db.LoginDB(tenant.ID, guid, refreshHash, userAgent, ip, claims.ID, claims.ExpiresAt.Time, scope, jkt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	return sessionTokens(tenant, sessionID, aToken, secret, scope, jkt), nil
+}
+
+// issueClientTokens выпускает пару токенов в сессии OAuth клиента, не затрагивая сессию входа через cookie.
+// Пустой sessionID создаёт новую сессию клиента, иначе в существующей сессии заменяются refresh токен и jti access токена.
+// Access токен содержит идентификатор сессии в claim sid
+func (as *AuthSystemManager) issueClientTokens(tenant models.Tenant, client models.Client, guid string, sessionID string, userAgent string, ip string, scope string, jkt string, authTime time.Time) (models.Tokens, error) {
+	var err error
+	if sessionID == "" {
+		if err = as.db.DeleteExpiredClientSessions(tenant.ID, tenant.RefreshTTL); err != nil {
+			logrus.Warn(err)
+		}
+		if sessionID, err = as.db.AddClientSession(tenant.ID, guid, client.ID, authTime); err != nil {
+			return models.Tokens{}, err
+		}
+	}
+	aToken, claims, err := utils.NewAccessToken(tenant, userAgent, guid, sessionID, scope, jkt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	secret, refreshHash, err := utils.NewRefreshToken()
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	err = as.db.UpdateClientSession(tenant.ID, sessionID, refreshHash, userAgent, ip, claims.ID, claims.ExpiresAt.Time, scope, jkt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	return sessionTokens(tenant, sessionID, aToken, secret, scope, jkt), nil
+}
+
+func sessionTokens(tenant models.Tenant, sessionID string, aToken string, secret string, scope string, jkt string) models.Tokens {
+	return models.Tokens{
+		AccessToken:      aToken,
+		RefreshToken:     utils.FormatRefreshToken(sessionID, secret),
+		ExpiresIn:        tenant.AccessTTL,
+		RefreshExpiresIn: tenant.RefreshTTL,
+		SessionID:        sessionID,
+		Scope:            scope,
+		TokenType:        tokenType(jkt),
+	}
 }
 
-func (as *AuthSystemManager) CheckTokens(aToken string, rToken string) error {
-	err := utils.CheckLinkTokens(aToken, rToken)
+// CheckTokens проверяет, что refresh токен относится к действующей сессии, а access токен последний выпущенный в ней.
+// Для истёкшего access токена возвращается jwt.ErrTokenExpired, сессию в этом случае проверяет RefreshTokens
+func (as *AuthSystemManager) CheckTokens(tenant models.Tenant, aToken string, rToken string) error {
+	claims, err := utils.ParseAccessToken(tenant, aToken, false)
 	if err != nil {
-		if err.Error() == "token has invalid claims: token is expired" {
+		if errors.Is(err, jwt.ErrTokenExpired) {
 			return jwt.ErrTokenExpired
 		}
 		return err
 	}
+	guid, session, err := as.sessionByRefreshToken(tenant, rToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return apperror.ErrUnauthorized
+		}
+		return err
+	}
+	if guid != claims.UserID() || claims.ID == "" || claims.ID != session.AccessJTI {
+		logrus.Debug("access token doesn't belong to session")
+		return apperror.ErrUnauthorized
+	}
+	if as.denylist.IsRevoked(claims.ID) {
+		logrus.Debug("access token revoked")
+		return apperror.ErrUnauthorized
+	}
 	return nil
 }
 
-func (as *AuthSystemManager) CompareRT(rToken, guid string) (bool, error) {
-	rTokenBcryptNew, err := as.db.GetBcrypt(rToken)
+// CheckAccessToken проверяет access токен, переданный без refresh токена: токен не истёк, не отозван
+// и последний выпущенный в сессии. Истёкший токен клиент обновляет сам через /refresh
+func (as *AuthSystemManager) CheckAccessToken(tenant models.Tenant, aToken string) error {
+	claims, err := utils.ParseAccessToken(tenant, aToken, false)
 	if err != nil {
-		return false, err
+		logrus.Debug(err)
+		return apperror.ErrUnauthorized
+	}
+	if as.denylist.IsRevoked(claims.ID) {
+		logrus.Debug("access token revoked")
+		return apperror.ErrUnauthorized
 	}
-	rTokenOld, err := as.db.GetToken(guid)
+	session, err := as.accessSession(tenant, claims)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, apperror.ErrUnauthorized
+			return apperror.ErrUnauthorized
 		}
-		return false, err
+		return err
 	}
-	if rTokenBcryptNew != rTokenOld {
-		return false, nil
+	if claims.ID == "" || claims.ID != session.AccessJTI {
+		logrus.Debug("access token doesn't belong to session")
+		return apperror.ErrUnauthorized
 	}
-	return true, nil
+	return nil
 }
 
-func (as *AuthSystemManager) Logout(aToken string) error {
-	guid, err := utils.GetGUIDFromJWT(aToken)
+// accessSession возвращает сессию, в которой выпущен access токен: сессию клиента по sid или сессию входа пользователя
+func (as *AuthSystemManager) accessSession(tenant models.Tenant, claims *utils.AccessClaims) (models.Session, error) {
+	if claims.SessionID == "" {
+		return as.db.GetSession(tenant.ID, claims.UserID())
+	}
+	guid, session, err := as.db.GetClientSession(tenant.ID, claims.SessionID)
 	if err != nil {
+		return models.Session{}, err
+	}
+	if guid != claims.UserID() {
+		return models.Session{}, sql.ErrNoRows
+	}
+	return session, nil
+}
+
+// TokenScope возвращает scope уже проверенного access токена
+func (as *AuthSystemManager) TokenScope(tenant models.Tenant, aToken string) (string, error) {
+	claims, err := utils.ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		logrus.Debug(err)
+		return "", apperror.ErrUnauthorized
+	}
+	return claims.Scope, nil
+}
+
+// sessionScope возвращает scope сессии. Сессии, созданные до появления scope, получают scope по умолчанию
+func (as *AuthSystemManager) sessionScope(session models.Session) string {
+	if session.Scope == nil {
+		return as.tokenConfig.DefaultScope
+	}
+	return *session.Scope
+}
+
+// Logout удаляет сессию пользователя и отзывает access токен, чтобы он не действовал до истечения.
+// Для токена сессии OAuth клиента завершается только эта сессия
+func (as *AuthSystemManager) Logout(tenant models.Tenant, aToken string) error {
+	claims, err := utils.ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		return err
+	}
+	if err = as.denylist.Revoke(tenant.ID, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	if claims.SessionID != "" {
+		return as.revokeSession(tenant, claims.UserID(), claims.SessionID)
+	}
+	// токен входа через cookie не содержит sid, завершается сессия пользователя, профиль, роли и статус сохраняются
+	session, err := as.db.GetSession(tenant.ID, claims.UserID())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return apperror.ErrUnauthorized
+		}
 		return err
 	}
-	return as.db.DeleteUser(guid)
+	return as.revokeSession(tenant, claims.UserID(), session.ID)
+}
+
+func (as *AuthSystemManager) GetGUID(tenant models.Tenant, aToken string) (string, error) {
+	return guidFromToken(tenant, aToken)
+}
+
+func (as *AuthSystemManager) GetProfile(tenant models.Tenant, aToken string) (models.Profile, error) {
+	guid, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	profile, err := as.db.GetProfile(tenant.ID, guid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return profile, apperror.ErrUnauthorized
+		}
+		return profile, err
+	}
+	return profile, nil
 }
 
-func (as *AuthSystemManager) GetGUID(aToken string) (string, error) {
-	return utils.GetGUIDFromJWT(aToken)
+func (as *AuthSystemManager) UpdateProfile(tenant models.Tenant, aToken string, email *string, displayName *string) error {
+	guid, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return err
+	}
+	err = as.db.UpdateProfile(tenant.ID, guid, email, displayName)
+	if err == sql.ErrNoRows {
+		return apperror.ErrUnauthorized
+	}
+	return err
+}
+
+// Impersonate выпускает короткоживущий access токен пользователя guid для сотрудника поддержки.
+// Выпуск доступен только администраторам и всегда записывается в журнал аудита
+func (as *AuthSystemManager) Impersonate(tenant models.Tenant, aToken string, guid string, reason string, userAgent string, ip string) (string, time.Duration, error) {
+	if strings.TrimSpace(reason) == "" {
+		return "", 0, apperror.ErrReasonRequired
+	}
+	actor, err := as.GetActor(tenant, aToken)
+	if err != nil {
+		return "", 0, err
+	}
+	if actor != "" {
+		return "", 0, apperror.ErrForbidden
+	}
+	adminGUID, err := as.requireAdmin(tenant, aToken)
+	if err != nil {
+		return "", 0, err
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		if err == apperror.ErrUnauthorized {
+			return "", 0, apperror.ErrUserNotFound
+		}
+		return "", 0, err
+	}
+
+	ttl := as.tokenConfig.ImpersonationTTL
+	impToken, err := utils.NewImpersonationToken(tenant, ttl, adminGUID, guid, userAgent, as.tokenConfig.DefaultScope)
+	if err != nil {
+		return "", 0, err
+	}
+	audit := models.ImpersonationAudit{
+		TenantID:  tenant.ID,
+		ActorID:   adminGUID,
+		TargetID:  guid,
+		Reason:    reason,
+		ActorIP:   ip,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err = as.db.AddImpersonationAudit(audit); err != nil {
+		return "", 0, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"actor":  adminGUID,
+		"target": guid,
+		"reason": reason,
+		"ip":     ip,
+	}).Warn("impersonation token issued")
+	return impToken, ttl, nil
+}
+
+// RevokeUser завершает сессию пользователя guid и его сессии в OAuth клиентах и отзывает их последние access токены.
+// Доступно только администраторам
+func (as *AuthSystemManager) RevokeUser(tenant models.Tenant, aToken string, guid string, ip string) error {
+	adminGUID, err := as.requireAdmin(tenant, aToken)
+	if err != nil {
+		return err
+	}
+	session, err := as.db.GetSession(tenant.ID, guid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return apperror.ErrUserNotFound
+		}
+		return err
+	}
+	if err = as.revokeSession(tenant, guid, session.ID); err != nil {
+		return err
+	}
+	accessTokens, err := as.db.RevokeClientSessions(tenant.ID, guid)
+	if err != nil {
+		return err
+	}
+	for accessJTI, accessExpiresAt := range accessTokens {
+		if err = as.denylist.Revoke(tenant.ID, accessJTI, accessExpiresAt); err != nil {
+			return err
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"actor":  adminGUID,
+		"target": guid,
+		"ip":     ip,
+	}).Warn("user session revoked")
+	return nil
+}
+
+// requireAdmin возвращает guid владельца токена, если у него есть роль администратора
+func (as *AuthSystemManager) requireAdmin(tenant models.Tenant, aToken string) (string, error) {
+	adminGUID, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return "", err
+	}
+	admin, err := as.db.GetProfile(tenant.ID, adminGUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", apperror.ErrUnauthorized
+		}
+		return "", err
+	}
+	if !slices.Contains(admin.Roles, models.RoleAdmin) {
+		return "", apperror.ErrForbidden
+	}
+	return adminGUID, nil
+}
+
+// revokeSession удаляет refresh токен сессии входа или сессию клиента и добавляет в denylist последний выпущенный в ней access токен
+func (as *AuthSystemManager) revokeSession(tenant models.Tenant, guid string, sessionID string) error {
+	accessJTI, accessExpiresAt, err := as.db.RevokeSession(tenant.ID, guid, sessionID)
+	if err == sql.ErrNoRows {
+		accessJTI, accessExpiresAt, err = as.db.RevokeClientSession(tenant.ID, guid, sessionID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	return as.denylist.Revoke(tenant.ID, accessJTI, accessExpiresAt)
+}
+
+func (as *AuthSystemManager) GetActor(tenant models.Tenant, aToken string) (string, error) {
+	claims, err := utils.GetActorFromJWT(tenant, aToken)
+	if err != nil {
+		logrus.Debug(err)
+		return "", apperror.ErrUnauthorized
+	}
+	if claims.Actor == nil {
+		return "", nil
+	}
+	if as.denylist.IsRevoked(claims.ID) {
+		logrus.Debug("impersonation token revoked")
+		return "", apperror.ErrUnauthorized
+	}
+	return claims.Actor.Subject, nil
+}
+
+// JWKS возвращает открытые ключи проверки подписи, включая ещё не активированные и заменённые. Ключи HMAC не публикуются
+func (as *AuthSystemManager) JWKS() []keys.JWK {
+	jwks := []keys.JWK{}
+	for _, key := range as.ring.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	return jwks
+}
+
+func (as *AuthSystemManager) AuthenticateClient(tenant models.Tenant, clientID string, clientSecret string) (models.Client, error) {
+	if clientID == "" {
+		return models.Client{}, apperror.ErrInvalidClient
+	}
+	var client models.Client
+	var err error
+	if clientSecret == "" {
+		// публичный клиент (мобильное приложение) не имеет секрета и идентифицируется только по client_id
+		client, err = as.db.GetPublicClient(tenant.ID, clientID)
+	} else {
+		client, err = as.db.GetClient(tenant.ID, clientID, clientSecret)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return client, apperror.ErrInvalidClient
+		}
+		return client, err
+	}
+	return client, nil
+}
+
+// Introspect проверяет access или refresh токен по RFC 7662. Сначала проверяется тип из tokenTypeHint.
+// Токен активен, если он корректен, пользователь не заблокирован и сессия, к которой относится токен, не завершена
+func (as *AuthSystemManager) Introspect(tenant models.Tenant, client models.Client, token string, tokenTypeHint string) (models.Introspection, error) {
+	if !client.Introspection {
+		return models.Introspection{}, apperror.ErrForbidden
+	}
+	return as.inspect(tenant, token, tokenTypeHint)
+}
+
+// Revoke завершает сессию, к которой относится access или refresh токен (RFC 7009).
+// Access токен дополнительно попадает в denylist. Неизвестный или уже неактивный токен не считается ошибкой
+func (as *AuthSystemManager) Revoke(tenant models.Tenant, client models.Client, token string, tokenTypeHint string) error {
+	introspection, err := as.inspect(tenant, token, tokenTypeHint)
+	if err != nil || !introspection.Active {
+		return err
+	}
+	if introspection.TokenType == TokenTypeAccess {
+		if err = as.denylist.Revoke(tenant.ID, introspection.TokenID, introspection.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if introspection.SessionID == "" {
+		logrus.Debug("token isn't bound to session")
+		return nil
+	}
+	if err = as.revokeSession(tenant, introspection.Subject, introspection.SessionID); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"client": client.ID,
+		"sid":    introspection.SessionID,
+	}).Info("session revoked")
+	return nil
+}
+
+func (as *AuthSystemManager) inspect(tenant models.Tenant, token string, tokenTypeHint string) (models.Introspection, error) {
+	introspectors := []func(models.Tenant, string) (models.Introspection, error){as.introspectAccess, as.introspectRefresh}
+	if tokenTypeHint == TokenTypeRefresh {
+		introspectors = []func(models.Tenant, string) (models.Introspection, error){as.introspectRefresh, as.introspectAccess}
+	}
+	for _, introspect := range introspectors {
+		introspection, err := introspect(tenant, token)
+		if err != nil || introspection.Active {
+			return introspection, err
+		}
+	}
+	return models.Introspection{}, nil
+}
+
+func (as *AuthSystemManager) introspectAccess(tenant models.Tenant, aToken string) (models.Introspection, error) {
+	claims, err := utils.ParseAccessToken(withoutAudience(tenant), aToken, false)
+	if err != nil {
+		logrus.Debug(err)
+		return models.Introspection{}, nil
+	}
+	if as.denylist.IsRevoked(claims.ID) {
+		return models.Introspection{}, nil
+	}
+	if claims.IsClient() {
+		return as.introspectClient(tenant, claims)
+	}
+	guid := claims.UserID()
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return models.Introspection{}, ignoreStatusError(err)
+	}
+	introspection := models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeAccess,
+		TokenID:   claims.ID,
+		Subject:   guid,
+		Tenant:    tenant.ID,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		Scope:     claims.Scope,
+		DPoPJKT:   claims.JKT(),
+	}
+	if claims.IssuedAt != nil {
+		introspection.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		introspection.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.Actor != nil {
+		// токены имперсонации и обмена не привязаны к сессии пользователя
+		introspection.Actor = claims.Actor.Subject
+		introspection.ClientID = claims.ClientID
+		return introspection, nil
+	}
+
+	// токен активен, пока он последний выпущенный в незавершённой сессии
+	session, err := as.accessSession(tenant, claims)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Introspection{}, nil
+		}
+		return models.Introspection{}, err
+	}
+	if claims.ID == "" || claims.ID != session.AccessJTI {
+		return models.Introspection{}, nil
+	}
+	introspection.SessionID = session.ID
+	return introspection, nil
+}
+
+// withoutAudience отключает проверку aud. Токен, выпущенный по обмену для другого сервиса, проверяет
+// по aud сам этот сервис, а интроспекция подтверждает только подлинность и активность токена
+func withoutAudience(tenant models.Tenant) models.Tenant {
+	tenant.Audience = ""
+	return tenant
+}
+
+// introspectClient проверяет токен клиента: клиент должен существовать и иметь право на client credentials
+func (as *AuthSystemManager) introspectClient(tenant models.Tenant, claims *utils.AccessClaims) (models.Introspection, error) {
+	client, err := as.db.GetClientByID(tenant.ID, claims.ClientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Introspection{}, nil
+		}
+		return models.Introspection{}, err
+	}
+	if !client.ClientCredentials {
+		return models.Introspection{}, nil
+	}
+	introspection := models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeAccess,
+		TokenID:   claims.ID,
+		Subject:   claims.Subject,
+		Tenant:    tenant.ID,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		ClientID:  claims.ClientID,
+		Scope:     claims.Scope,
+		DPoPJKT:   claims.JKT(),
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		introspection.IssuedAt = claims.IssuedAt.Time
+	}
+	return introspection, nil
+}
+
+func (as *AuthSystemManager) introspectRefresh(tenant models.Tenant, rToken string) (models.Introspection, error) {
+	guid, session, err := as.sessionByRefreshToken(tenant, rToken)
+	if err == sql.ErrNoRows {
+		guid, session, err = as.clientSessionByRefreshToken(tenant, rToken)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Introspection{}, nil
+		}
+		return models.Introspection{}, err
+	}
+	expiresAt := session.RefreshedAt.Add(tenant.RefreshTTL)
+	if time.Now().After(expiresAt) {
+		return models.Introspection{}, nil
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return models.Introspection{}, ignoreStatusError(err)
+	}
+	return models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeRefresh,
+		Subject:   guid,
+		Tenant:    tenant.ID,
+		SessionID: session.ID,
+		Issuer:    tenant.Issuer,
+		IssuedAt:  session.RefreshedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// sessionByRefreshToken ищет сессию по refresh токену. Токен принимается как в виде значения cookie (base64), так и в исходном виде.
+// Токен второй версии ищется по идентификатору сессии и хешу секрета, токен первой версии по bcrypt
+func (as *AuthSystemManager) sessionByRefreshToken(tenant models.Tenant, rToken string) (string, models.Session, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(rToken); err == nil {
+		rToken = string(decoded)
+	}
+	if strings.HasPrefix(rToken, utils.RefreshTokenPrefix) {
+		sessionID, refreshHash, ok := utils.ParseRefreshToken(rToken)
+		if !ok {
+			return "", models.Session{}, sql.ErrNoRows
+		}
+		return as.db.GetSessionByRefreshHash(tenant.ID, sessionID, refreshHash)
+	}
+	rTokenBcrypt, err := as.db.GetBcrypt(rToken)
+	if err != nil {
+		return "", models.Session{}, err
+	}
+	return as.db.GetSessionByRT(tenant.ID, rTokenBcrypt)
+}
+
+// clientSessionByRefreshToken ищет сессию OAuth клиента. Клиентам выдаются только refresh токены второй версии
+func (as *AuthSystemManager) clientSessionByRefreshToken(tenant models.Tenant, rToken string) (string, models.Session, error) {
+	sessionID, refreshHash, ok := utils.ParseRefreshToken(rToken)
+	if !ok {
+		return "", models.Session{}, sql.ErrNoRows
+	}
+	return as.db.GetClientSessionByRefreshHash(tenant.ID, sessionID, refreshHash)
+}
+
+// ignoreStatusError оставляет только внутренние ошибки, отказ в доступе означает неактивный токен
+func ignoreStatusError(err error) error {
+	switch err {
+	case apperror.ErrUnauthorized, apperror.ErrUserDisabled, apperror.ErrUserSuspended:
+		return nil
+	}
+	return err
+}
+
+// guidFromToken возвращает guid из access токена, любая ошибка разбора токена считается ошибкой авторизации
+func guidFromToken(tenant models.Tenant, aToken string) (string, error) {
+	guid, err := utils.GetGUIDFromJWT(tenant, aToken)
+	if err != nil {
+		logrus.Debug(err)
+		return "", apperror.ErrUnauthorized
+	}
+	return guid, nil
+}
+
+func (as *AuthSystemManager) CheckStatus(tenant models.Tenant, aToken string) error {
+	guid, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return err
+	}
+	return as.checkUserStatus(tenant, guid)
+}
+
+// checkUserStatus возвращает ошибку, если аккаунт отключён или приостановлен.
+// Приостановка снимается автоматически по истечении suspended_until
+func (as *AuthSystemManager) checkUserStatus(tenant models.Tenant, guid string) error {
+	status, suspendedUntil, err := as.db.GetUserStatus(tenant.ID, guid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return apperror.ErrUnauthorized
+		}
+		return err
+	}
+	switch status {
+	case StatusDisabled:
+		return apperror.ErrUserDisabled
+	case StatusSuspended:
+		if !suspendedUntil.Valid || time.Now().Before(suspendedUntil.Time) {
+			return apperror.ErrUserSuspended
+		}
+	}
+	return nil
 }
@@ -0,0 +1,37 @@
+package authsystem
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/utils"
+)
+
+func TestLogoutKeepsUser(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := exchangeTestCode(t, as, tenant, db, models.Client{ID: "app"}, "openid")
+	aToken := userToken(t, tenant)
+	claims, err := utils.ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.Logout(tenant, aToken); err != nil {
+		t.Fatal(err)
+	}
+	// завершается только сессия входа через cookie, запись пользователя и сессии клиентов остаются
+	if !slices.Equal(db.loggedOut, []string{testGUID}) || len(db.clientSessions) != 1 {
+		t.Fatalf("logged out = %v, client sessions = %d", db.loggedOut, len(db.clientSessions))
+	}
+	if _, ok := db.revoked[claims.ID]; !ok {
+		t.Fatal("access token isn't revoked")
+	}
+
+	if err = as.Logout(tenant, client.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.loggedOut) != 1 || len(db.clientSessions) != 0 {
+		t.Fatalf("logged out = %v, client sessions = %d", db.loggedOut, len(db.clientSessions))
+	}
+}
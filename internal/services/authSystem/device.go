@@ -0,0 +1,203 @@
+package authsystem
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GrantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+	// devicePollInterval минимальный интервал опроса, при slow_down увеличивается на столько же (RFC 8628 3.5)
+	devicePollInterval = 5 * time.Second
+	// userCodeAlphabet согласные без похожих символов, код вводится вручную (RFC 8628 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// DeviceAuthorization начинает авторизацию устройства без браузера (RFC 8628 3.1).
+// Устройство показывает пользователю user_code и опрашивает /token с device_code.
+// Запрошенный scope должен входить в разрешённые клиенту, без запроса выдаются все разрешённые
+func (as *AuthSystemManager) DeviceAuthorization(tenant models.Tenant, client models.Client, scope string) (models.DeviceAuthorization, error) {
+	scope, err := downScope(as.clientScope(client), scope)
+	if err != nil {
+		return models.DeviceAuthorization{}, err
+	}
+	if scope == "" {
+		return models.DeviceAuthorization{}, apperror.ErrInvalidScope
+	}
+	if err = as.db.DeleteExpiredDeviceCodes(); err != nil {
+		logrus.Warn(err)
+	}
+	deviceCode, err := randomString(32)
+	if err != nil {
+		return models.DeviceAuthorization{}, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return models.DeviceAuthorization{}, err
+	}
+	err = as.db.AddDeviceCode(models.DeviceCode{
+		DeviceCodeHash: hashCode(deviceCode),
+		UserCode:       userCode,
+		TenantID:       tenant.ID,
+		ClientID:       client.ID,
+		Scope:          scope,
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(as.tokenConfig.DeviceCodeTTL),
+	})
+	if err != nil {
+		return models.DeviceAuthorization{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"client": client.ID,
+	}).Info("device code issued")
+	return models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   FormatUserCode(userCode),
+		ExpiresIn:  as.tokenConfig.DeviceCodeTTL,
+		Interval:   devicePollInterval,
+	}, nil
+}
+
+// GetDeviceRequest возвращает ожидающий подтверждения запрос устройства по user_code, чтобы показать его пользователю
+func (as *AuthSystemManager) GetDeviceRequest(tenant models.Tenant, aToken string, userCode string) (models.Client, models.DeviceCode, error) {
+	if _, err := guidFromToken(tenant, aToken); err != nil {
+		return models.Client{}, models.DeviceCode{}, err
+	}
+	code, err := as.db.GetDeviceCodeByUserCode(tenant.ID, normalizeUserCode(userCode))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Client{}, models.DeviceCode{}, apperror.ErrInvalidUserCode
+		}
+		return models.Client{}, models.DeviceCode{}, err
+	}
+	if code.Status != models.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		return models.Client{}, models.DeviceCode{}, apperror.ErrInvalidUserCode
+	}
+	client, err := as.db.GetClientByID(tenant.ID, code.ClientID)
+	if err != nil {
+		return models.Client{}, models.DeviceCode{}, err
+	}
+	return client, code, nil
+}
+
+// ApproveDevice сохраняет решение вошедшего пользователя по запросу устройства
+func (as *AuthSystemManager) ApproveDevice(tenant models.Tenant, aToken string, userCode string, approved bool) error {
+	guid, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return err
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return err
+	}
+	status := models.DeviceCodeDenied
+	if approved {
+		status = models.DeviceCodeApproved
+	}
+	if err = as.db.SetDeviceCodeDecision(tenant.ID, normalizeUserCode(userCode), guid, status); err != nil {
+		if err == sql.ErrNoRows {
+			return apperror.ErrInvalidUserCode
+		}
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"user":   guid,
+		"status": status,
+	}).Info("device authorization decided")
+	return nil
+}
+
+// DeviceCodeGrant выдаёт токены устройству после подтверждения пользователем (RFC 8628 3.4, 3.5) в новой сессии клиента.
+// До решения пользователя возвращается ErrAuthorizationPending, при слишком частом опросе ErrSlowDown
+func (as *AuthSystemManager) DeviceCodeGrant(tenant models.Tenant, client models.Client, deviceCode string, userAgent string, ip string, jkt string) (models.Tokens, error) {
+	code, err := as.db.PollDeviceCode(tenant.ID, hashCode(deviceCode))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	if code.ClientID != client.ID {
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return models.Tokens{}, apperror.ErrExpiredToken
+	}
+	switch code.Status {
+	case models.DeviceCodePending:
+		if !code.LastPolledAt.IsZero() && time.Since(code.LastPolledAt) < code.Interval {
+			if err = as.db.SlowDownDeviceCode(tenant.ID, code.DeviceCodeHash, devicePollInterval); err != nil {
+				return models.Tokens{}, err
+			}
+			return models.Tokens{}, apperror.ErrSlowDown
+		}
+		return models.Tokens{}, apperror.ErrAuthorizationPending
+	case models.DeviceCodeDenied:
+		return models.Tokens{}, apperror.ErrAccessDenied
+	case models.DeviceCodeApproved:
+	default:
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+
+	if err = as.db.UseDeviceCode(tenant.ID, code.DeviceCodeHash); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	if err = as.checkUserStatus(tenant, code.UserID); err != nil {
+		if ignoreStatusError(err) == nil {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	session, err := as.db.GetSession(tenant.ID, code.UserID)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	tokens, err := as.issueClientTokens(tenant, client, code.UserID, "", userAgent, ip, code.Scope, jkt, session.AuthenticatedAt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	if tokens.IDToken, err = idToken(tenant, client, code.UserID, code.Scope, "", session.AuthenticatedAt); err != nil {
+		return models.Tokens{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"client": client.ID,
+		"user":   code.UserID,
+	}).Info("device authorized")
+	return tokens, nil
+}
+
+// FormatUserCode разделяет user_code дефисом для удобства ввода: BCDF-GHJK
+func FormatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode приводит введённый пользователем код к сохранённому виду: без дефисов, пробелов и в верхнем регистре
+func normalizeUserCode(userCode string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+}
+
+func newUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i, j := range b {
+		b[i] = userCodeAlphabet[int(j)%len(userCodeAlphabet)]
+	}
+	return string(b), nil
+}
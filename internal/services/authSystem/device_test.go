@@ -0,0 +1,110 @@
+package authsystem
+
+import (
+	"testing"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+)
+
+func TestDeviceAuthorizationScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		scope   string
+		want    string
+		wantErr error
+	}{
+		{"client scopes by default", []string{"orders:read", "orders:write"}, "", "orders:read orders:write", nil},
+		{"narrowed", []string{"orders:read", "orders:write"}, "orders:read", "orders:read", nil},
+		{"not allowed to client", []string{"orders:read"}, "orders:write", "", apperror.ErrInvalidScope},
+		{"default scope", nil, "openid", "openid", nil},
+		{"wider than default scope", nil, "openid admin", "", apperror.ErrInvalidScope},
+		{"invalid characters", nil, "open\"id", "", apperror.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, tenant, db := newTestSystem(t)
+			authorization, err := as.DeviceAuthorization(tenant, models.Client{ID: "cli", Scopes: tt.scopes}, tt.scope)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			code := db.deviceCodes[hashCode(authorization.DeviceCode)]
+			if code.Scope != tt.want {
+				t.Fatalf("scope = %q, want %q", code.Scope, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceAuthorizationRejectsEmptyScope(t *testing.T) {
+	as, tenant, _ := newTestSystem(t)
+	as.tokenConfig.DefaultScope = ""
+	if _, err := as.DeviceAuthorization(tenant, models.Client{ID: "cli"}, ""); err != apperror.ErrInvalidScope {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidScope)
+	}
+}
+
+// approveDevice подтверждает код устройства от имени testGUID в обход ApproveDevice
+func approveDevice(db *fakeDB, deviceCode string, status string) {
+	code := db.deviceCodes[hashCode(deviceCode)]
+	code.UserID = testGUID
+	code.Status = status
+	db.deviceCodes[hashCode(deviceCode)] = code
+}
+
+func TestDeviceCodeGrantCreatesClientSession(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "cli"}
+	authorization, err := as.DeviceAuthorization(tenant, client, "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approveDevice(db, authorization.DeviceCode, models.DeviceCodeApproved)
+
+	tokens, err := as.DeviceCodeGrant(tenant, client, authorization.DeviceCode, testUserAgent, testIP, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.logins != 0 {
+		t.Fatal("device grant changed the cookie session")
+	}
+	session, ok := db.clientSessions[tokens.SessionID]
+	if !ok || session.session.ClientID != client.ID || session.guid != testGUID {
+		t.Fatalf("client session isn't created: %+v", session)
+	}
+	if tokens.IDToken == "" {
+		t.Fatal("id token isn't issued for openid scope")
+	}
+	if _, err = as.DeviceCodeGrant(tenant, client, authorization.DeviceCode, testUserAgent, testIP, ""); err != apperror.ErrInvalidGrant {
+		t.Fatalf("second grant: err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+func TestDeviceCodeGrantPolling(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "cli"}
+	authorization, err := as.DeviceAuthorization(tenant, client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = as.DeviceCodeGrant(tenant, client, authorization.DeviceCode, testUserAgent, testIP, ""); err != apperror.ErrAuthorizationPending {
+		t.Fatalf("first poll: err = %v, want %v", err, apperror.ErrAuthorizationPending)
+	}
+	if _, err = as.DeviceCodeGrant(tenant, client, authorization.DeviceCode, testUserAgent, testIP, ""); err != apperror.ErrSlowDown {
+		t.Fatalf("second poll: err = %v, want %v", err, apperror.ErrSlowDown)
+	}
+	if interval := db.deviceCodes[hashCode(authorization.DeviceCode)].Interval; interval != 2*devicePollInterval {
+		t.Fatalf("interval = %v, want %v", interval, 2*devicePollInterval)
+	}
+	if _, err = as.DeviceCodeGrant(tenant, models.Client{ID: "other"}, authorization.DeviceCode, testUserAgent, testIP, ""); err != apperror.ErrInvalidGrant {
+		t.Fatalf("another client: err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+	approveDevice(db, authorization.DeviceCode, models.DeviceCodeDenied)
+	if _, err = as.DeviceCodeGrant(tenant, client, authorization.DeviceCode, testUserAgent, testIP, ""); err != apperror.ErrAccessDenied {
+		t.Fatalf("denied: err = %v, want %v", err, apperror.ErrAccessDenied)
+	}
+}
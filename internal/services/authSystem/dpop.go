@@ -0,0 +1,110 @@
+package authsystem
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/dpop"
+	"github.com/sater-151/AuthSystem/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// TokenTypeDPoP тип токена в ответе и схема Authorization для токенов, привязанных к ключу DPoP (RFC 9449 5, 7.1)
+const TokenTypeDPoP = "DPoP"
+
+// dpopProofLifetime допустимое отклонение iat proof от времени сервера без учёта JWT_LEEWAY
+const dpopProofLifetime = time.Minute
+
+// DPoPProof проверяет DPoP proof запроса method к uri и возвращает отпечаток его ключа (RFC 9449 4.3).
+// Proof одноразовый, при включённых nonce должен содержать действующий nonce сервера.
+// Для запроса к ресурсу передаётся aToken, proof должен содержать его хеш в ath. Без proof возвращается пустой отпечаток
+func (as *AuthSystemManager) DPoPProof(tenant models.Tenant, proof string, method string, uri string, aToken string) (string, error) {
+	if proof == "" {
+		return "", nil
+	}
+	parsed, err := dpop.Parse(proof)
+	if err != nil {
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	if parsed.Method != method || !dpop.SameURI(parsed.URI, uri) {
+		logrus.Debug("dpop proof was issued for another request")
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	window := dpopProofLifetime + tenant.Leeway
+	if age := time.Since(parsed.IssuedAt); age > window || age < -window {
+		logrus.Debug("dpop proof is expired or issued in the future")
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	if as.nonces.Enabled() && !as.nonces.Valid(parsed.Nonce) {
+		return "", apperror.ErrUseDPoPNonce
+	}
+	if aToken != "" && parsed.AccessTokenHash != dpop.AccessTokenHash(aToken) {
+		logrus.Debug("dpop proof was issued for another access token")
+		return "", apperror.ErrInvalidDPoPProof
+	}
+
+	// jti уникален для ключа, proof хранится, пока его iat попадает в допустимое окно
+	sum := sha256.Sum256([]byte(tenant.ID + "." + parsed.JKT + "." + parsed.ID))
+	err = as.db.UseDPoPProof(hex.EncodeToString(sum[:]), parsed.IssuedAt.Add(window))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logrus.Warn("dpop proof replayed")
+			return "", apperror.ErrInvalidDPoPProof
+		}
+		return "", err
+	}
+	return parsed.JKT, nil
+}
+
+// CheckDPoP проверяет, что запрос с access токеном, привязанным к ключу DPoP, содержит proof этого ключа.
+// Для bearer токена proof не требуется и возвращается пустой отпечаток
+func (as *AuthSystemManager) CheckDPoP(tenant models.Tenant, aToken string, proof string, method string, uri string) (string, error) {
+	claims, err := utils.ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		logrus.Debug(err)
+		return "", apperror.ErrUnauthorized
+	}
+	if claims.JKT() == "" {
+		return "", nil
+	}
+	if proof == "" {
+		logrus.Debug("dpop proof required")
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	jkt, err := as.DPoPProof(tenant, proof, method, uri, aToken)
+	if err != nil {
+		return "", err
+	}
+	if jkt != claims.JKT() {
+		logrus.Warn("dpop proof key doesn't match access token")
+		return "", apperror.ErrInvalidDPoPProof
+	}
+	return jkt, nil
+}
+
+// DPoPNonce выдаёт nonce для заголовка DPoP-Nonce, пустая строка, если nonce отключены
+func (as *AuthSystemManager) DPoPNonce() string {
+	return as.nonces.Issue()
+}
+
+// checkSessionKey не даёт обновить привязанную к ключу DPoP сессию без proof этого ключа.
+// Сессия без привязки при обновлении с proof привязывается к его ключу
+func checkSessionKey(session models.Session, jkt string) error {
+	if session.DPoPJKT != "" && session.DPoPJKT != jkt {
+		logrus.Warn("dpop proof key doesn't match session")
+		return apperror.ErrInvalidDPoPProof
+	}
+	return nil
+}
+
+// tokenType тип выданного токена для ответа, пустой тип означает Bearer
+func tokenType(jkt string) string {
+	if jkt == "" {
+		return ""
+	}
+	return TokenTypeDPoP
+}
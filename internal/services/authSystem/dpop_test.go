@@ -0,0 +1,177 @@
+package authsystem
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/dpop"
+	"github.com/sater-151/AuthSystem/internal/utils"
+)
+
+const tokenURI = "https://auth.example/api/oauth/token"
+
+// proofKey ключ клиента, которым подписываются DPoP proof
+type proofKey struct {
+	t   *testing.T
+	key *ecdsa.PrivateKey
+}
+
+func newProofKey(t *testing.T) proofKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proofKey{t: t, key: key}
+}
+
+// proof подписывает DPoP proof запроса method к uri, aToken и nonce добавляются, если не пустые
+func (pk proofKey) proof(method string, uri string, aToken string, nonce string, issuedAt time.Time) string {
+	pk.t.Helper()
+	jti, err := utils.CreateLink()
+	if err != nil {
+		pk.t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"jti": jti, "htm": method, "htu": uri, "iat": issuedAt.Unix()}
+	if aToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(aToken)
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pk.key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pk.key.Y.FillBytes(make([]byte, 32))),
+	}
+	proof, err := token.SignedString(pk.key)
+	if err != nil {
+		pk.t.Fatal(err)
+	}
+	return proof
+}
+
+func TestDPoPProof(t *testing.T) {
+	as, tenant, _ := newTestSystem(t)
+	key := newProofKey(t)
+
+	if jkt, err := as.DPoPProof(tenant, "", "POST", tokenURI, ""); err != nil || jkt != "" {
+		t.Fatalf("without proof: %q, %v", jkt, err)
+	}
+	proof := key.proof("POST", tokenURI, "", "", time.Now())
+	jkt, err := as.DPoPProof(tenant, proof, "POST", tokenURI+"?grant_type=refresh_token", "")
+	if err != nil || jkt == "" {
+		t.Fatalf("DPoPProof() = %q, %v", jkt, err)
+	}
+	if _, err = as.DPoPProof(tenant, proof, "POST", tokenURI, ""); !errors.Is(err, apperror.ErrInvalidDPoPProof) {
+		t.Fatalf("replayed proof: err = %v, want %v", err, apperror.ErrInvalidDPoPProof)
+	}
+
+	tests := []struct {
+		name   string
+		proof  string
+		method string
+		uri    string
+		aToken string
+	}{
+		{"method", key.proof("GET", tokenURI, "", "", time.Now()), "POST", tokenURI, ""},
+		{"uri", key.proof("POST", "https://auth.example/api/oauth/revoke", "", "", time.Now()), "POST", tokenURI, ""},
+		{"expired", key.proof("POST", tokenURI, "", "", time.Now().Add(-5*time.Minute)), "POST", tokenURI, ""},
+		{"future", key.proof("POST", tokenURI, "", "", time.Now().Add(5*time.Minute)), "POST", tokenURI, ""},
+		{"ath", key.proof("GET", "https://api.example/orders", "other", "", time.Now()), "GET", "https://api.example/orders", "token"},
+		{"malformed", "proof", "POST", tokenURI, ""},
+	}
+	for _, tt := range tests {
+		if _, err := as.DPoPProof(tenant, tt.proof, tt.method, tt.uri, tt.aToken); !errors.Is(err, apperror.ErrInvalidDPoPProof) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, apperror.ErrInvalidDPoPProof)
+		}
+	}
+}
+
+func TestDPoPProofNonce(t *testing.T) {
+	as, tenant, _ := newTestSystem(t)
+	var err error
+	as.nonces, err = dpop.NewNonces([]byte("0123456789abcdef0123456789abcdef"), time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newProofKey(t)
+
+	if _, err = as.DPoPProof(tenant, key.proof("POST", tokenURI, "", "", time.Now()), "POST", tokenURI, ""); !errors.Is(err, apperror.ErrUseDPoPNonce) {
+		t.Fatalf("without nonce: err = %v, want %v", err, apperror.ErrUseDPoPNonce)
+	}
+	if _, err = as.DPoPProof(tenant, key.proof("POST", tokenURI, "", "forged", time.Now()), "POST", tokenURI, ""); !errors.Is(err, apperror.ErrUseDPoPNonce) {
+		t.Fatalf("forged nonce: err = %v, want %v", err, apperror.ErrUseDPoPNonce)
+	}
+	if _, err = as.DPoPProof(tenant, key.proof("POST", tokenURI, "", as.DPoPNonce(), time.Now()), "POST", tokenURI, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDPoP(t *testing.T) {
+	as, tenant, _ := newTestSystem(t)
+	key := newProofKey(t)
+	const resourceURI = "https://api.example/orders"
+	jkt, err := as.DPoPProof(tenant, key.proof("POST", tokenURI, "", "", time.Now()), "POST", tokenURI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aToken, _, err := utils.NewAccessToken(tenant, "", testGUID, "", "openid", jkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := as.CheckDPoP(tenant, aToken, key.proof("GET", resourceURI, aToken, "", time.Now()), "GET", resourceURI); err != nil || got != jkt {
+		t.Fatalf("CheckDPoP() = %q, %v, want %q", got, err, jkt)
+	}
+	otherKey := newProofKey(t)
+	for name, proof := range map[string]string{
+		"without proof": "",
+		"other key":     otherKey.proof("GET", resourceURI, aToken, "", time.Now()),
+		"without ath":   key.proof("GET", resourceURI, "", "", time.Now()),
+	} {
+		if _, err = as.CheckDPoP(tenant, aToken, proof, "GET", resourceURI); !errors.Is(err, apperror.ErrInvalidDPoPProof) {
+			t.Fatalf("%s: err = %v, want %v", name, err, apperror.ErrInvalidDPoPProof)
+		}
+	}
+
+	bearer, _, err := utils.NewAccessToken(tenant, "", testGUID, "", "openid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := as.CheckDPoP(tenant, bearer, "", "GET", resourceURI); err != nil || got != "" {
+		t.Fatalf("bearer token: %q, %v", got, err)
+	}
+}
+
+func TestRefreshGrantRequiresSessionKey(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	addCode(db, client, "code", "openid")
+	tokens, err := as.ExchangeCode(tenant, client, "code", testRedirectURI, testVerifier, testUserAgent, testIP, "jkt-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.TokenType != TokenTypeDPoP {
+		t.Fatalf("token type = %q, want %q", tokens.TokenType, TokenTypeDPoP)
+	}
+	for _, jkt := range []string{"", "jkt-2"} {
+		if _, err = as.RefreshGrant(tenant, client, tokens.RefreshToken, testUserAgent, testIP, "", jkt); !errors.Is(err, apperror.ErrInvalidDPoPProof) {
+			t.Fatalf("refresh with key %q: err = %v, want %v", jkt, err, apperror.ErrInvalidDPoPProof)
+		}
+	}
+	if _, err = as.RefreshGrant(tenant, client, tokens.RefreshToken, testUserAgent, testIP, "", "jkt-1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package authsystem
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GrantTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// TokenTypeAccessURN идентификатор типа access токена в запросе и ответе обмена (RFC 8693 3)
+	TokenTypeAccessURN = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// ExchangeToken обменивает access токен пользователя на токен для сервиса audience (RFC 8693 2).
+// Новый токен сохраняет sub пользователя, вызывающий клиент указывается в act, scope не шире разрешённых
+// клиенту и scope исходного токена. Токен живёт не дольше исходного и не обновляется
+func (as *AuthSystemManager) ExchangeToken(tenant models.Tenant, client models.Client, subjectToken string, subjectTokenType string, audience string, scope string) (models.Tokens, error) {
+	if !client.Confidential || len(client.ExchangeAudiences) == 0 {
+		return models.Tokens{}, apperror.ErrUnauthorizedClient
+	}
+	if subjectTokenType != TokenTypeAccessURN {
+		return models.Tokens{}, apperror.ErrInvalidRequest
+	}
+	// без JWT_AUDIENCE токен для другого сервиса принимался бы и этим сервисом
+	if tenant.Audience == "" || audience == tenant.Audience || !slices.Contains(client.ExchangeAudiences, audience) {
+		return models.Tokens{}, apperror.ErrInvalidTarget
+	}
+
+	introspection, err := as.introspectAccess(tenant, subjectToken)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	if !introspection.Active {
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	claims, err := utils.ParseAccessToken(withoutAudience(tenant), subjectToken, false)
+	if err != nil {
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	// токен клиента не представляет пользователя, обменивать нечего
+	if claims.IsClient() {
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+
+	allowed := slices.DeleteFunc(slices.Clone(client.Scopes), func(s string) bool {
+		return !scopeCovers(claims.Scope, s)
+	})
+	if scope == "" {
+		scope = strings.Join(allowed, " ")
+	}
+	if scope == "" || !scopeCovers(strings.Join(allowed, " "), scope) {
+		return models.Tokens{}, apperror.ErrInvalidScope
+	}
+
+	expiresAt := time.Now().Add(tenant.AccessTTL)
+	if introspection.ExpiresAt.Before(expiresAt) {
+		expiresAt = introspection.ExpiresAt
+	}
+	aToken, err := utils.NewExchangedToken(tenant, introspection.Subject, audience, scope, client.ID, claims.Actor, expiresAt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant":   tenant.ID,
+		"client":   client.ID,
+		"guid":     introspection.Subject,
+		"audience": audience,
+		"scope":    scope,
+	}).Info("token exchanged")
+	return models.Tokens{
+		AccessToken:     aToken,
+		ExpiresIn:       time.Until(expiresAt).Round(time.Second),
+		Scope:           scope,
+		IssuedTokenType: TokenTypeAccessURN,
+	}, nil
+}
@@ -0,0 +1,134 @@
+package authsystem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/utils"
+)
+
+const paymentsAudience = "payments"
+
+var ordersAPI = models.Client{
+	ID:                "orders-api",
+	Confidential:      true,
+	Scopes:            []string{"orders:read", "payments:read"},
+	ExchangeAudiences: []string{paymentsAudience},
+}
+
+// newExchangeSystem создаёт сервис, токены которого предназначены только для него (JWT_AUDIENCE), и токен пользователя
+// из сессии клиента app со scope
+func newExchangeSystem(t *testing.T, scope string) (*AuthSystemManager, models.Tenant, *fakeDB, string) {
+	t.Helper()
+	as, tenant, db := newTestSystem(t)
+	tenant.Audience = "authsystem"
+	tokens := exchangeTestCode(t, as, tenant, db, models.Client{ID: "app"}, scope)
+	return as, tenant, db, tokens.AccessToken
+}
+
+func TestExchangeToken(t *testing.T) {
+	as, tenant, _, subjectToken := newExchangeSystem(t, "openid payments:read")
+
+	tokens, err := as.ExchangeToken(tenant, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// scope ограничен и токеном пользователя, и scope клиента
+	if tokens.Scope != "payments:read" || tokens.IssuedTokenType != TokenTypeAccessURN || tokens.RefreshToken != "" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+	if tokens.ExpiresIn <= 0 || tokens.ExpiresIn > tenant.AccessTTL {
+		t.Fatalf("expires in = %v", tokens.ExpiresIn)
+	}
+
+	payments := tenant
+	payments.Audience = paymentsAudience
+	claims, err := utils.ParseAccessToken(payments, tokens.AccessToken, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID() != testGUID || claims.Actor == nil || claims.Actor.Subject != ordersAPI.ID || claims.Actor.Actor != nil || claims.ClientID != ordersAPI.ID {
+		t.Fatalf("claims = %+v", claims)
+	}
+	// токен для другого сервиса не принимается этим сервисом
+	if err = as.CheckAccessToken(tenant, tokens.AccessToken); err == nil {
+		t.Fatal("exchanged token is accepted by issuer")
+	}
+
+	// при повторном обмене предыдущий участник цепочки вкладывается в act
+	paymentsAPI := models.Client{ID: "payments-api", Confidential: true, Scopes: []string{"payments:read"}, ExchangeAudiences: []string{"ledger"}}
+	chained, err := as.ExchangeToken(tenant, paymentsAPI, tokens.AccessToken, TokenTypeAccessURN, "ledger", "payments:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := tenant
+	ledger.Audience = "ledger"
+	claims, err = utils.ParseAccessToken(ledger, chained.AccessToken, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Actor.Subject != paymentsAPI.ID || claims.Actor.Actor == nil || claims.Actor.Actor.Subject != ordersAPI.ID {
+		t.Fatalf("actor chain = %+v", claims.Actor)
+	}
+}
+
+func TestExchangeTokenRejects(t *testing.T) {
+	as, tenant, db, subjectToken := newExchangeSystem(t, "openid payments:read")
+	clientToken, err := utils.NewClientToken(tenant, time.Minute, ordersAPI.ID, "payments:read", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.clients[ordersAPI.ID] = ordersAPI
+	publicClient := ordersAPI
+	publicClient.Confidential = false
+	withoutAudiences := ordersAPI
+	withoutAudiences.ExchangeAudiences = nil
+	withoutTenantAudience := tenant
+	withoutTenantAudience.Audience = ""
+
+	tests := []struct {
+		name      string
+		tenant    models.Tenant
+		client    models.Client
+		token     string
+		tokenType string
+		audience  string
+		scope     string
+		want      error
+	}{
+		{"public client", tenant, publicClient, subjectToken, TokenTypeAccessURN, paymentsAudience, "", apperror.ErrUnauthorizedClient},
+		{"client without audiences", tenant, withoutAudiences, subjectToken, TokenTypeAccessURN, paymentsAudience, "", apperror.ErrUnauthorizedClient},
+		{"token type", tenant, ordersAPI, subjectToken, "urn:ietf:params:oauth:token-type:refresh_token", paymentsAudience, "", apperror.ErrInvalidRequest},
+		{"audience isn't allowed", tenant, ordersAPI, subjectToken, TokenTypeAccessURN, "ledger", "", apperror.ErrInvalidTarget},
+		{"own audience", tenant, ordersAPI, subjectToken, TokenTypeAccessURN, tenant.Audience, "", apperror.ErrInvalidTarget},
+		{"tenant without audience", withoutTenantAudience, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, "", apperror.ErrInvalidTarget},
+		{"invalid token", tenant, ordersAPI, "token", TokenTypeAccessURN, paymentsAudience, "", apperror.ErrInvalidGrant},
+		{"client token", tenant, ordersAPI, clientToken, TokenTypeAccessURN, paymentsAudience, "", apperror.ErrInvalidGrant},
+		{"scope above token", tenant, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, "orders:read", apperror.ErrInvalidScope},
+		{"scope above client", tenant, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, "openid", apperror.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := as.ExchangeToken(tt.tenant, tt.client, tt.token, tt.tokenType, tt.audience, tt.scope); err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// токен завершённой сессии не обменивается
+	for sessionID := range db.clientSessions {
+		delete(db.clientSessions, sessionID)
+	}
+	if _, err = as.ExchangeToken(tenant, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, ""); err != apperror.ErrInvalidGrant {
+		t.Fatalf("revoked session: err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+func TestExchangeTokenWithoutCommonScope(t *testing.T) {
+	as, tenant, _, subjectToken := newExchangeSystem(t, "openid profile")
+	if _, err := as.ExchangeToken(tenant, ordersAPI, subjectToken, TokenTypeAccessURN, paymentsAudience, ""); err != apperror.ErrInvalidScope {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidScope)
+	}
+}
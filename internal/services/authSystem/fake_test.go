@@ -0,0 +1,237 @@
+package authsystem
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/config"
+	"github.com/sater-151/AuthSystem/internal/database/postgresql"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/denylist"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+)
+
+// fakeDB хранит в памяти только то, что нужно тестам сервиса, остальные методы Postgresql не реализованы
+type fakeDB struct {
+	postgresql.Postgresql
+	clients        map[string]models.Client
+	consents       map[string]string
+	codes          map[string]models.AuthorizationCode
+	deviceCodes    map[string]models.DeviceCode
+	clientSessions map[string]*fakeClientSession
+	revoked        map[string]time.Time
+	proofs         map[string]time.Time
+	loggedOut      []string
+	logins         int
+	nextSession    int
+}
+
+type fakeClientSession struct {
+	guid        string
+	refreshHash string
+	session     models.Session
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		clients:        map[string]models.Client{},
+		consents:       map[string]string{},
+		codes:          map[string]models.AuthorizationCode{},
+		deviceCodes:    map[string]models.DeviceCode{},
+		clientSessions: map[string]*fakeClientSession{},
+		revoked:        map[string]time.Time{},
+		proofs:         map[string]time.Time{},
+	}
+}
+
+func (db *fakeDB) LoginDB(tenantID string, guid string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) (string, error) {
+	db.logins++
+	return loginSessionID, nil
+}
+
+func (db *fakeDB) GetUserStatus(tenantID string, guid string) (string, sql.NullTime, error) {
+	return StatusActive, sql.NullTime{}, nil
+}
+
+func (db *fakeDB) GetClientByID(tenantID string, clientID string) (models.Client, error) {
+	client, ok := db.clients[clientID]
+	if !ok {
+		return models.Client{}, sql.ErrNoRows
+	}
+	return client, nil
+}
+
+func (db *fakeDB) GetConsent(tenantID string, clientID string, guid string) (string, error) {
+	scope, ok := db.consents[clientID+"/"+guid]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return scope, nil
+}
+
+func (db *fakeDB) SaveConsent(tenantID string, clientID string, guid string, scope string) error {
+	db.consents[clientID+"/"+guid] = scope
+	return nil
+}
+
+func (db *fakeDB) AddAuthorizationCode(code models.AuthorizationCode) error {
+	db.codes[code.CodeHash] = code
+	return nil
+}
+
+func (db *fakeDB) DeleteExpiredAuthorizationCodes() error {
+	return nil
+}
+
+func (db *fakeDB) UseAuthorizationCode(tenantID string, codeHash string) (models.AuthorizationCode, error) {
+	code, ok := db.codes[codeHash]
+	if !ok || code.TenantID != tenantID {
+		return models.AuthorizationCode{}, sql.ErrNoRows
+	}
+	delete(db.codes, codeHash)
+	return code, nil
+}
+
+func (db *fakeDB) GetSession(tenantID string, guid string) (models.Session, error) {
+	return models.Session{ID: loginSessionID, AuthenticatedAt: time.Now().Add(-time.Minute)}, nil
+}
+
+func (db *fakeDB) AddDeviceCode(code models.DeviceCode) error {
+	code.Status = models.DeviceCodePending
+	db.deviceCodes[code.DeviceCodeHash] = code
+	return nil
+}
+
+func (db *fakeDB) DeleteExpiredDeviceCodes() error {
+	return nil
+}
+
+func (db *fakeDB) PollDeviceCode(tenantID string, deviceCodeHash string) (models.DeviceCode, error) {
+	code, ok := db.deviceCodes[deviceCodeHash]
+	if !ok || code.TenantID != tenantID {
+		return models.DeviceCode{}, sql.ErrNoRows
+	}
+	polled := code
+	polled.LastPolledAt = time.Now()
+	db.deviceCodes[deviceCodeHash] = polled
+	return code, nil
+}
+
+func (db *fakeDB) SlowDownDeviceCode(tenantID string, deviceCodeHash string, interval time.Duration) error {
+	code := db.deviceCodes[deviceCodeHash]
+	code.Interval += interval
+	db.deviceCodes[deviceCodeHash] = code
+	return nil
+}
+
+func (db *fakeDB) UseDeviceCode(tenantID string, deviceCodeHash string) error {
+	code, ok := db.deviceCodes[deviceCodeHash]
+	if !ok || code.Status != models.DeviceCodeApproved {
+		return sql.ErrNoRows
+	}
+	code.Status = "used"
+	db.deviceCodes[deviceCodeHash] = code
+	return nil
+}
+
+func (db *fakeDB) AddClientSession(tenantID string, guid string, clientID string, authenticatedAt time.Time) (string, error) {
+	db.nextSession++
+	sessionID := fmt.Sprintf("00000000-0000-4000-8000-%012d", db.nextSession)
+	db.clientSessions[sessionID] = &fakeClientSession{
+		guid:    guid,
+		session: models.Session{ID: sessionID, ClientID: clientID, AuthenticatedAt: authenticatedAt},
+	}
+	return sessionID, nil
+}
+
+func (db *fakeDB) UpdateClientSession(tenantID string, sessionID string, refreshHash string, userAgent string, ip string, accessJTI string, accessExpiresAt time.Time, scope string, jkt string) error {
+	s, ok := db.clientSessions[sessionID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	s.refreshHash = refreshHash
+	s.session.UserAgent = userAgent
+	s.session.IP = ip
+	s.session.RefreshedAt = time.Now()
+	s.session.AccessJTI = accessJTI
+	s.session.Scope = &scope
+	s.session.DPoPJKT = jkt
+	return nil
+}
+
+func (db *fakeDB) GetClientSession(tenantID string, sessionID string) (string, models.Session, error) {
+	s, ok := db.clientSessions[sessionID]
+	if !ok {
+		return "", models.Session{}, sql.ErrNoRows
+	}
+	return s.guid, s.session, nil
+}
+
+func (db *fakeDB) GetClientSessionByRefreshHash(tenantID string, sessionID string, refreshHash string) (string, models.Session, error) {
+	s, ok := db.clientSessions[sessionID]
+	if !ok || s.refreshHash != refreshHash {
+		return "", models.Session{}, sql.ErrNoRows
+	}
+	return s.guid, s.session, nil
+}
+
+// RevokeSession завершает сессию входа через cookie, сессии клиентов хранятся отдельно
+func (db *fakeDB) RevokeSession(tenantID string, guid string, sessionID string) (string, time.Time, error) {
+	if sessionID != loginSessionID {
+		return "", time.Time{}, sql.ErrNoRows
+	}
+	db.loggedOut = append(db.loggedOut, guid)
+	return "", time.Time{}, nil
+}
+
+func (db *fakeDB) RevokeClientSession(tenantID string, guid string, sessionID string) (string, time.Time, error) {
+	s, ok := db.clientSessions[sessionID]
+	if !ok || s.guid != guid {
+		return "", time.Time{}, sql.ErrNoRows
+	}
+	delete(db.clientSessions, sessionID)
+	return s.session.AccessJTI, time.Now().Add(time.Minute), nil
+}
+
+func (db *fakeDB) DeleteExpiredClientSessions(tenantID string, refreshTTL time.Duration) error {
+	return nil
+}
+
+func (db *fakeDB) AddRevokedToken(tenantID string, jti string, expiresAt time.Time) error {
+	db.revoked[jti] = expiresAt
+	return nil
+}
+
+func (db *fakeDB) UseDPoPProof(proofHash string, expiresAt time.Time) error {
+	if _, ok := db.proofs[proofHash]; ok {
+		return sql.ErrNoRows
+	}
+	db.proofs[proofHash] = expiresAt
+	return nil
+}
+
+const (
+	testGUID       = "090bb747-d6d3-4067-a1da-2b83726eb24d"
+	loginSessionID = "00000000-0000-4000-8000-000000000000"
+)
+
+// newTestSystem создаёт сервис с тенантом по умолчанию, токены которого подписываются HS512
+func newTestSystem(t *testing.T) (*AuthSystemManager, models.Tenant, *fakeDB) {
+	t.Helper()
+	db := newFakeDB()
+	tokenConfig := config.TokenConfig{DefaultScope: "openid profile", DeviceCodeTTL: time.Minute}
+	as, err := New(db, nil, tokenConfig, nil, denylist.New(db), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := models.Tenant{
+		ID:         models.DefaultTenantID,
+		Keys:       keys.NewRing(keys.NewHMAC([]byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"))),
+		AccessTTL:  time.Minute,
+		RefreshTTL: time.Hour,
+		Issuer:     "auth-test",
+	}
+	return as, tenant, db
+}
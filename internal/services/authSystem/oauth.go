@@ -0,0 +1,464 @@
+package authsystem
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/dpop"
+	"github.com/sater-151/AuthSystem/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ResponseTypeCode       = "code"
+	CodeChallengeS256      = "S256"
+	authorizationCodeTTL   = time.Minute
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = utils.GrantClientCredentials
+	ScopeOpenID            = "openid"
+	ScopeProfile           = "profile"
+	ScopeEmail             = "email"
+	// вход выполняется только по guid без проверки учётных данных, поэтому уровень аутентификации
+	// минимальный (acr "0" в терминах OpenID Connect Core 2)
+	acrMinimal = "0"
+	amrGUID    = "guid"
+)
+
+// scopePattern формат отдельного значения scope (RFC 6749 3.3)
+var scopePattern = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+
+// codeVerifierPattern формат code_verifier и code_challenge (RFC 7636 4.1, 4.2)
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// RegisterClient регистрирует OAuth клиента. Секрет создаётся только для конфиденциального клиента
+// и возвращается один раз, в базе хранится его хэш. Доступно только администраторам
+func (as *AuthSystemManager) RegisterClient(tenant models.Tenant, aToken string, client models.Client) (models.Client, string, error) {
+	adminGUID, err := as.requireAdmin(tenant, aToken)
+	if err != nil {
+		return models.Client{}, "", err
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return models.Client{}, "", apperror.ErrInvalidRedirectURI
+		}
+	}
+	// клиенту, который получает токены только от своего имени, redirect_uri не нужен
+	if len(client.RedirectURIs) == 0 && !client.ClientCredentials {
+		return models.Client{}, "", apperror.ErrInvalidRedirectURI
+	}
+	if (client.ClientCredentials || len(client.ExchangeAudiences) != 0) && !client.Confidential {
+		return models.Client{}, "", apperror.ErrUnauthorizedClient
+	}
+	for _, audience := range client.ExchangeAudiences {
+		if !scopePattern.MatchString(audience) || audience == tenant.Audience {
+			return models.Client{}, "", apperror.ErrInvalidTarget
+		}
+	}
+	for _, scope := range client.Scopes {
+		if !scopePattern.MatchString(scope) {
+			return models.Client{}, "", apperror.ErrInvalidScope
+		}
+	}
+	client.TenantID = tenant.ID
+	client.ID, err = randomString(12)
+	if err != nil {
+		return models.Client{}, "", err
+	}
+	var secret string
+	if client.Confidential {
+		secret, err = randomString(32)
+		if err != nil {
+			return models.Client{}, "", err
+		}
+	}
+	if err = as.db.AddClient(client, secret); err != nil {
+		return models.Client{}, "", err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"actor":  adminGUID,
+		"client": client.ID,
+	}).Info("oauth client registered")
+	return client, secret, nil
+}
+
+// Authorize выдаёт код авторизации пользователю, вошедшему по access токену (RFC 6749 4.1, PKCE RFC 7636).
+// Доверенным клиентам код выдаётся сразу, остальным после согласия пользователя на scope кода, он же возвращается
+// для экрана согласия. Ошибки ErrInvalidClient и ErrInvalidRedirectURI нельзя возвращать по redirect_uri
+func (as *AuthSystemManager) Authorize(tenant models.Tenant, aToken string, request models.AuthorizationRequest) (models.Client, string, string, error) {
+	client, guid, err := as.authorizationRequest(tenant, aToken, &request)
+	if err != nil {
+		return client, "", "", err
+	}
+	if !client.FirstParty {
+		consent, err := as.db.GetConsent(tenant.ID, client.ID, guid)
+		if err != nil && err != sql.ErrNoRows {
+			return client, request.Scope, "", err
+		}
+		if err == sql.ErrNoRows || !scopeCovers(consent, request.Scope) {
+			return client, request.Scope, "", apperror.ErrConsentRequired
+		}
+	}
+	code, err := as.newAuthorizationCode(tenant, client, guid, request)
+	return client, request.Scope, code, err
+}
+
+// Consent сохраняет решение пользователя по запросу авторизации и при согласии выдаёт код
+func (as *AuthSystemManager) Consent(tenant models.Tenant, aToken string, request models.AuthorizationRequest, approved bool) (models.Client, string, string, error) {
+	client, guid, err := as.authorizationRequest(tenant, aToken, &request)
+	if err != nil {
+		return client, "", "", err
+	}
+	if !approved {
+		return client, request.Scope, "", apperror.ErrAccessDenied
+	}
+	if err = as.db.SaveConsent(tenant.ID, client.ID, guid, request.Scope); err != nil {
+		return client, request.Scope, "", err
+	}
+	code, err := as.newAuthorizationCode(tenant, client, guid, request)
+	return client, request.Scope, code, err
+}
+
+// authorizationRequest проверяет клиента, redirect_uri и параметры PKCE и возвращает guid пользователя.
+// Scope запроса ограничивается разрешёнными клиенту, без scope в запросе код получает все разрешённые
+func (as *AuthSystemManager) authorizationRequest(tenant models.Tenant, aToken string, request *models.AuthorizationRequest) (models.Client, string, error) {
+	client, err := as.db.GetClientByID(tenant.ID, request.ClientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return client, "", apperror.ErrInvalidClient
+		}
+		return client, "", err
+	}
+	if !redirectAllowed(client, request.RedirectURI) {
+		return client, "", apperror.ErrInvalidRedirectURI
+	}
+	guid, err := guidFromToken(tenant, aToken)
+	if err != nil {
+		return client, "", err
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		return client, "", err
+	}
+	if request.ResponseType != ResponseTypeCode {
+		return client, "", apperror.ErrUnsupportedResponseType
+	}
+	if request.CodeChallengeMethod != CodeChallengeS256 || !codeVerifierPattern.MatchString(request.CodeChallenge) {
+		return client, "", apperror.ErrInvalidRequest
+	}
+	if request.Scope, err = downScope(as.clientScope(client), request.Scope); err != nil {
+		return client, "", err
+	}
+	if request.Scope == "" {
+		return client, "", apperror.ErrInvalidScope
+	}
+	return client, guid, nil
+}
+
+func (as *AuthSystemManager) newAuthorizationCode(tenant models.Tenant, client models.Client, guid string, request models.AuthorizationRequest) (string, error) {
+	if err := as.db.DeleteExpiredAuthorizationCodes(); err != nil {
+		logrus.Warn(err)
+	}
+	session, err := as.db.GetSession(tenant.ID, guid)
+	if err != nil {
+		return "", err
+	}
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	err = as.db.AddAuthorizationCode(models.AuthorizationCode{
+		CodeHash:      hashCode(code),
+		TenantID:      tenant.ID,
+		ClientID:      client.ID,
+		UserID:        guid,
+		RedirectURI:   request.RedirectURI,
+		Scope:         request.Scope,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+		Nonce:         request.Nonce,
+		AuthTime:      session.AuthenticatedAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"client": client.ID,
+		"user":   guid,
+	}).Info("authorization code issued")
+	return code, nil
+}
+
+// ExchangeCode обменивает код авторизации на токены. Код одноразовый, должен быть выдан этому же клиенту
+// с тем же redirect_uri, а code_verifier должен соответствовать code_challenge. Токены выдаются в новой сессии клиента
+func (as *AuthSystemManager) ExchangeCode(tenant models.Tenant, client models.Client, code string, redirectURI string, codeVerifier string, userAgent string, ip string, jkt string) (models.Tokens, error) {
+	authCode, err := as.db.UseAuthorizationCode(tenant.ID, hashCode(code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	switch {
+	case authCode.ClientID != client.ID,
+		authCode.RedirectURI != redirectURI,
+		time.Now().After(authCode.ExpiresAt),
+		!verifyCodeChallenge(authCode.CodeChallenge, codeVerifier):
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	if err = as.checkUserStatus(tenant, authCode.UserID); err != nil {
+		if ignoreStatusError(err) == nil {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	tokens, err := as.issueClientTokens(tenant, client, authCode.UserID, "", userAgent, ip, authCode.Scope, jkt, authCode.AuthTime)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	if tokens.IDToken, err = idToken(tenant, client, authCode.UserID, authCode.Scope, authCode.Nonce, authCode.AuthTime); err != nil {
+		return models.Tokens{}, err
+	}
+	return tokens, nil
+}
+
+// idToken создаёт ID токен, если клиент запросил scope openid
+func idToken(tenant models.Tenant, client models.Client, guid string, scope string, nonce string, authTime time.Time) (string, error) {
+	if !slices.Contains(strings.Fields(scope), ScopeOpenID) {
+		return "", nil
+	}
+	return utils.NewIDToken(tenant, guid, client.ID, nonce, authTime, acrMinimal, []string{amrGUID})
+}
+
+// RefreshGrant выпускает новую пару токенов по refresh токену сессии клиента без access токена.
+// Refresh токен принимается только от клиента, которому он выдан (RFC 6749 6, 10.4).
+// Как и при обновлении через cookie, смена User-Agent завершает сессию, а scope можно только сузить (RFC 6749 6)
+func (as *AuthSystemManager) RefreshGrant(tenant models.Tenant, client models.Client, rToken string, userAgent string, ip string, scope string, jkt string) (models.Tokens, error) {
+	guid, session, err := as.clientSessionByRefreshToken(tenant, rToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	if session.ClientID != client.ID {
+		logrus.WithField("client", client.ID).Warn("refresh token issued to another client")
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	if time.Now().After(session.RefreshedAt.Add(tenant.RefreshTTL)) {
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	if err = as.checkUserStatus(tenant, guid); err != nil {
+		if ignoreStatusError(err) == nil {
+			return models.Tokens{}, apperror.ErrInvalidGrant
+		}
+		return models.Tokens{}, err
+	}
+	if userAgent != session.UserAgent {
+		logrus.WithField("client", client.ID).Warn("user agent changed, session revoked")
+		if err = as.revokeSession(tenant, guid, session.ID); err != nil {
+			return models.Tokens{}, err
+		}
+		return models.Tokens{}, apperror.ErrInvalidGrant
+	}
+	if ip != session.IP {
+		as.wh.SendMessageAboutAnotherIp()
+	}
+	scope, err = downScope(as.sessionScope(session), scope)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	if err = checkSessionKey(session, jkt); err != nil {
+		return models.Tokens{}, err
+	}
+	return as.issueClientTokens(tenant, client, guid, session.ID, userAgent, ip, scope, jkt, session.AuthenticatedAt)
+}
+
+// redirectAllowed сравнивает redirect_uri с зарегистрированными без нормализации (RFC 6749 3.1.2.3).
+// Если redirect_uri не передан, клиент должен иметь ровно один зарегистрированный адрес
+func redirectAllowed(client models.Client, redirectURI string) bool {
+	if redirectURI == "" {
+		return len(client.RedirectURIs) == 1
+	}
+	return slices.Contains(client.RedirectURIs, redirectURI)
+}
+
+// RedirectURI возвращает адрес, на который отправляется ответ авторизации
+func RedirectURI(client models.Client, request models.AuthorizationRequest) string {
+	if request.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		return client.RedirectURIs[0]
+	}
+	return request.RedirectURI
+}
+
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+	return !strings.ContainsAny(redirectURI, " \t\r\n")
+}
+
+// clientScope граница scope, который пользователь может выдать клиенту: зарегистрированные scopes клиента,
+// для клиента без них scope по умолчанию
+func (as *AuthSystemManager) clientScope(client models.Client) string {
+	if len(client.Scopes) == 0 {
+		return as.tokenConfig.DefaultScope
+	}
+	return strings.Join(client.Scopes, " ")
+}
+
+// downScope возвращает запрошенный scope, если он входит в выданный, без запроса остаётся выданный scope
+func downScope(granted string, requested string) (string, error) {
+	if requested == "" {
+		return granted, nil
+	}
+	for _, scope := range strings.Fields(requested) {
+		if !scopePattern.MatchString(scope) {
+			return "", apperror.ErrInvalidScope
+		}
+	}
+	if !scopeCovers(granted, requested) {
+		return "", apperror.ErrInvalidScope
+	}
+	return strings.Join(strings.Fields(requested), " "), nil
+}
+
+func scopeCovers(granted string, requested string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(grantedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ClientCredentials выпускает клиенту короткоживущий access токен от его имени (RFC 6749 4.4).
+// Refresh токен не выдаётся, токен не привязан к User-Agent. Без scope в запросе выдаются все разрешённые клиенту scope
+func (as *AuthSystemManager) ClientCredentials(tenant models.Tenant, client models.Client, scope string, jkt string) (models.Tokens, error) {
+	if !client.Confidential || !client.ClientCredentials {
+		return models.Tokens{}, apperror.ErrUnauthorizedClient
+	}
+	if scope == "" {
+		scope = strings.Join(client.Scopes, " ")
+	}
+	if !scopeCovers(strings.Join(client.Scopes, " "), scope) {
+		return models.Tokens{}, apperror.ErrInvalidScope
+	}
+	aToken, err := utils.NewClientToken(tenant, as.tokenConfig.ClientTTL, client.ID, scope, jkt)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant": tenant.ID,
+		"client": client.ID,
+		"scope":  scope,
+	}).Info("client token issued")
+	return models.Tokens{AccessToken: aToken, ExpiresIn: as.tokenConfig.ClientTTL, Scope: scope, TokenType: tokenType(jkt)}, nil
+}
+
+// CheckClientToken проверяет токен клиента и возвращает клиента. Для токена пользователя возвращается пустой клиент.
+// Токен клиента не обновляется, поэтому истёкший токен является ошибкой
+func (as *AuthSystemManager) CheckClientToken(tenant models.Tenant, aToken string) (models.Client, error) {
+	claims, err := utils.ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		logrus.Debug(err)
+		return models.Client{}, apperror.ErrUnauthorized
+	}
+	if !claims.IsClient() {
+		return models.Client{}, nil
+	}
+	if _, err = utils.ParseAccessToken(tenant, aToken, false); err != nil {
+		logrus.Debug(err)
+		return models.Client{}, apperror.ErrUnauthorized
+	}
+	if as.denylist.IsRevoked(claims.ID) {
+		logrus.Debug("client token revoked")
+		return models.Client{}, apperror.ErrUnauthorized
+	}
+	client, err := as.db.GetClientByID(tenant.ID, claims.ClientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Client{}, apperror.ErrUnauthorized
+		}
+		return models.Client{}, err
+	}
+	if !client.ClientCredentials {
+		return models.Client{}, apperror.ErrUnauthorized
+	}
+	return client, nil
+}
+
+func (as *AuthSystemManager) OpenIDConfiguration() models.OpenIDConfiguration {
+	return models.OpenIDConfiguration{
+		Issuer:            as.tokenConfig.Issuer,
+		SigningAlgorithms: []string{as.tokenConfig.Algorithm},
+		DPoPAlgorithms:    dpop.Algorithms,
+	}
+}
+
+// UserInfo возвращает профиль владельца access токена (OpenID Connect Core 5.3).
+// Токен должен быть активен в том же смысле, что и при интроспекции, токены клиентов не принимаются.
+// Требуется scope openid, имя и роли возвращаются только со scope profile, email со scope email
+func (as *AuthSystemManager) UserInfo(tenant models.Tenant, aToken string) (models.Profile, error) {
+	introspection, err := as.introspectAccess(tenant, aToken)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	if !introspection.Active || introspection.ClientID != "" {
+		return models.Profile{}, apperror.ErrUnauthorized
+	}
+	if !scopeCovers(introspection.Scope, ScopeOpenID) {
+		return models.Profile{}, apperror.ErrInsufficientScope
+	}
+	profile, err := as.db.GetProfile(tenant.ID, introspection.Subject)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Profile{}, apperror.ErrUnauthorized
+		}
+		return models.Profile{}, err
+	}
+	if !scopeCovers(introspection.Scope, ScopeProfile) {
+		profile.DisplayName = ""
+		profile.Roles = nil
+	}
+	if !scopeCovers(introspection.Scope, ScopeEmail) {
+		profile.Email = ""
+	}
+	return profile, nil
+}
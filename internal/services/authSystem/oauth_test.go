@@ -0,0 +1,335 @@
+package authsystem
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/utils"
+)
+
+const (
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testRedirectURI = "https://app.example/callback"
+	testUserAgent   = "test-agent"
+	testIP          = "127.0.0.1"
+)
+
+// userToken выпускает access токен сессии входа testGUID
+func userToken(t *testing.T, tenant models.Tenant) string {
+	t.Helper()
+	aToken, _, err := utils.NewAccessToken(tenant, testUserAgent, testGUID, "", "openid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aToken
+}
+
+func authorizationRequest(clientID string, scope string) models.AuthorizationRequest {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return models.AuthorizationRequest{
+		ResponseType:        ResponseTypeCode,
+		ClientID:            clientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: CodeChallengeS256,
+	}
+}
+
+// codeScope возвращает scope единственного выданного кода авторизации
+func codeScope(t *testing.T, db *fakeDB) string {
+	t.Helper()
+	if len(db.codes) != 1 {
+		t.Fatalf("%d codes issued, want 1", len(db.codes))
+	}
+	for _, code := range db.codes {
+		return code.Scope
+	}
+	return ""
+}
+
+func TestAuthorizeBoundsScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		scope   string
+		want    string
+		wantErr error
+	}{
+		{"client scopes by default", []string{"openid", "orders:read"}, "", "openid orders:read", nil},
+		{"narrowed", []string{"openid", "orders:read"}, "openid", "openid", nil},
+		{"not allowed to client", []string{"openid"}, "openid admin", "", apperror.ErrInvalidScope},
+		{"default scope", nil, "", "openid profile", nil},
+		{"wider than default scope", nil, "openid email", "", apperror.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, tenant, db := newTestSystem(t)
+			db.clients["app"] = models.Client{ID: "app", FirstParty: true, RedirectURIs: []string{testRedirectURI}, Scopes: tt.scopes}
+			_, scope, code, err := as.Authorize(tenant, userToken(t, tenant), authorizationRequest("app", tt.scope))
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(db.codes) != 0 {
+					t.Fatal("code issued with invalid scope")
+				}
+				return
+			}
+			if code == "" || scope != tt.want || codeScope(t, db) != tt.want {
+				t.Fatalf("scope = %q, code scope = %q, want %q", scope, codeScope(t, db), tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeConsentUsesBoundedScope(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	db.clients["app"] = models.Client{ID: "app", RedirectURIs: []string{testRedirectURI}, Scopes: []string{"openid", "orders:read"}}
+	aToken := userToken(t, tenant)
+	request := authorizationRequest("app", "")
+
+	_, scope, _, err := as.Authorize(tenant, aToken, request)
+	if err != apperror.ErrConsentRequired {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrConsentRequired)
+	}
+	if scope != "openid orders:read" {
+		t.Fatalf("consent scope = %q, want %q", scope, "openid orders:read")
+	}
+	if _, _, _, err = as.Consent(tenant, aToken, request, true); err != nil {
+		t.Fatal(err)
+	}
+	if consent := db.consents["app/"+testGUID]; consent != "openid orders:read" {
+		t.Fatalf("saved consent = %q", consent)
+	}
+	if _, _, _, err = as.Authorize(tenant, aToken, authorizationRequest("app", "orders:read")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	db.clients["app"] = models.Client{ID: "app", FirstParty: true, RedirectURIs: []string{testRedirectURI}}
+	request := authorizationRequest("app", "")
+	request.CodeChallengeMethod = "plain"
+	if _, _, _, err := as.Authorize(tenant, userToken(t, tenant), request); err != apperror.ErrInvalidRequest {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidRequest)
+	}
+	request = authorizationRequest("app", "")
+	request.RedirectURI = "https://evil.example/callback"
+	if _, _, _, err := as.Authorize(tenant, userToken(t, tenant), request); err != apperror.ErrInvalidRedirectURI {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidRedirectURI)
+	}
+}
+
+// addCode сохраняет код авторизации, выданный клиенту client с code_challenge для testVerifier
+func addCode(db *fakeDB, client models.Client, code string, scope string) {
+	sum := sha256.Sum256([]byte(testVerifier))
+	db.codes[hashCode(code)] = models.AuthorizationCode{
+		CodeHash:      hashCode(code),
+		TenantID:      models.DefaultTenantID,
+		ClientID:      client.ID,
+		UserID:        testGUID,
+		RedirectURI:   testRedirectURI,
+		Scope:         scope,
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	}
+}
+
+func TestExchangeCodeCreatesClientSession(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	addCode(db, client, "code-1", "openid")
+	addCode(db, client, "code-2", "openid")
+
+	first, err := as.ExchangeCode(tenant, client, "code-1", testRedirectURI, testVerifier, testUserAgent, testIP, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := as.ExchangeCode(tenant, client, "code-2", testRedirectURI, testVerifier, testUserAgent, testIP, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.logins != 0 {
+		t.Fatal("code exchange changed the cookie session")
+	}
+	if first.SessionID == second.SessionID {
+		t.Fatal("code exchanges share a session")
+	}
+	if first.IDToken == "" {
+		t.Fatal("id token isn't issued for openid scope")
+	}
+	claims, err := utils.ParseAccessToken(tenant, first.AccessToken, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.SessionID != first.SessionID {
+		t.Fatalf("sid = %q, want %q", claims.SessionID, first.SessionID)
+	}
+	for _, tokens := range []models.Tokens{first, second} {
+		if err = as.CheckAccessToken(tenant, tokens.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExchangeCodeRejectsInvalidGrant(t *testing.T) {
+	client := models.Client{ID: "app"}
+	tests := []struct {
+		name         string
+		client       models.Client
+		code         string
+		redirectURI  string
+		codeVerifier string
+	}{
+		{"unknown code", client, "unknown", testRedirectURI, testVerifier},
+		{"another client", models.Client{ID: "other"}, "code", testRedirectURI, testVerifier},
+		{"another redirect uri", client, "code", "https://app.example/other", testVerifier},
+		{"wrong verifier", client, "code", testRedirectURI, "0000000000000000000000000000000000000000000"},
+		{"no verifier", client, "code", testRedirectURI, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, tenant, db := newTestSystem(t)
+			addCode(db, client, "code", "")
+			_, err := as.ExchangeCode(tenant, tt.client, tt.code, tt.redirectURI, tt.codeVerifier, testUserAgent, testIP, "")
+			if err != apperror.ErrInvalidGrant {
+				t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeIsSingleUse(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	addCode(db, client, "code", "")
+	if _, err := as.ExchangeCode(tenant, client, "code", testRedirectURI, testVerifier, testUserAgent, testIP, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err := as.ExchangeCode(tenant, client, "code", testRedirectURI, testVerifier, testUserAgent, testIP, "")
+	if err != apperror.ErrInvalidGrant {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+func TestExchangeCodeRejectsExpiredCode(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	addCode(db, client, "code", "")
+	code := db.codes[hashCode("code")]
+	code.ExpiresAt = time.Now().Add(-time.Second)
+	db.codes[hashCode("code")] = code
+	_, err := as.ExchangeCode(tenant, client, "code", testRedirectURI, testVerifier, testUserAgent, testIP, "")
+	if err != apperror.ErrInvalidGrant {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+// exchangeTestCode выдаёт клиенту client токены по коду авторизации
+func exchangeTestCode(t *testing.T, as *AuthSystemManager, tenant models.Tenant, db *fakeDB, client models.Client, scope string) models.Tokens {
+	t.Helper()
+	addCode(db, client, "code", scope)
+	tokens, err := as.ExchangeCode(tenant, client, "code", testRedirectURI, testVerifier, testUserAgent, testIP, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestRefreshGrantRotatesClientSession(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	tokens := exchangeTestCode(t, as, tenant, db, client, "openid profile")
+
+	refreshed, err := as.RefreshGrant(tenant, client, tokens.RefreshToken, testUserAgent, testIP, "openid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.SessionID != tokens.SessionID {
+		t.Fatalf("session = %q, want %q", refreshed.SessionID, tokens.SessionID)
+	}
+	if refreshed.Scope != "openid" {
+		t.Fatalf("scope = %q, want openid", refreshed.Scope)
+	}
+	if err = as.CheckAccessToken(tenant, tokens.AccessToken); err != apperror.ErrUnauthorized {
+		t.Fatalf("previous access token: err = %v, want %v", err, apperror.ErrUnauthorized)
+	}
+	if _, err = as.RefreshGrant(tenant, client, tokens.RefreshToken, testUserAgent, testIP, "", ""); err != apperror.ErrInvalidGrant {
+		t.Fatalf("previous refresh token: err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+func TestRefreshGrantRejectsAnotherClient(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	tokens := exchangeTestCode(t, as, tenant, db, models.Client{ID: "app"}, "")
+
+	_, err := as.RefreshGrant(tenant, models.Client{ID: "other"}, tokens.RefreshToken, testUserAgent, testIP, "", "")
+	if err != apperror.ErrInvalidGrant {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+	if _, ok := db.clientSessions[tokens.SessionID]; !ok {
+		t.Fatal("session of the owner client was revoked")
+	}
+}
+
+func TestRefreshGrantRejectsInvalidGrant(t *testing.T) {
+	client := models.Client{ID: "app"}
+	tests := []struct {
+		name    string
+		token   func(tokens models.Tokens) string
+		agent   string
+		scope   string
+		wantErr error
+	}{
+		{"unknown token", func(models.Tokens) string { return "unknown" }, testUserAgent, "", apperror.ErrInvalidGrant},
+		{"wrong secret", func(tokens models.Tokens) string {
+			return utils.FormatRefreshToken(tokens.SessionID, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+		}, testUserAgent, "", apperror.ErrInvalidGrant},
+		{"another user agent", func(tokens models.Tokens) string { return tokens.RefreshToken }, "other-agent", "", apperror.ErrInvalidGrant},
+		{"wider scope", func(tokens models.Tokens) string { return tokens.RefreshToken }, testUserAgent, "openid email", apperror.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, tenant, db := newTestSystem(t)
+			tokens := exchangeTestCode(t, as, tenant, db, client, "openid")
+			_, err := as.RefreshGrant(tenant, client, tt.token(tokens), tt.agent, testIP, tt.scope, "")
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshGrantRejectsExpiredSession(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	tokens := exchangeTestCode(t, as, tenant, db, client, "")
+	db.clientSessions[tokens.SessionID].session.RefreshedAt = time.Now().Add(-tenant.RefreshTTL - time.Second)
+
+	_, err := as.RefreshGrant(tenant, client, tokens.RefreshToken, testUserAgent, testIP, "", "")
+	if err != apperror.ErrInvalidGrant {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+}
+
+func TestRefreshGrantChangedUserAgentRevokesSession(t *testing.T) {
+	as, tenant, db := newTestSystem(t)
+	client := models.Client{ID: "app"}
+	tokens := exchangeTestCode(t, as, tenant, db, client, "")
+
+	if _, err := as.RefreshGrant(tenant, client, tokens.RefreshToken, "other-agent", testIP, "", ""); err != apperror.ErrInvalidGrant {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrInvalidGrant)
+	}
+	if _, ok := db.clientSessions[tokens.SessionID]; ok {
+		t.Fatal("session isn't revoked")
+	}
+	if err := as.CheckAccessToken(tenant, tokens.AccessToken); err != apperror.ErrUnauthorized {
+		t.Fatalf("err = %v, want %v", err, apperror.ErrUnauthorized)
+	}
+}
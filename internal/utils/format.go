@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/jwe"
+	"github.com/sater-151/AuthSystem/internal/pkg/paseto"
+)
+
+// Форматы access токена. Формат выпуска задаётся в конфигурации, проверяются токены любого формата,
+// для которого у тенанта есть ключи, поэтому при смене формата ранее выпущенные токены остаются действительными.
+// FormatJWE подписанный JWT, вложенный в JWE
+const (
+	FormatJWT          = "jwt"
+	FormatJWE          = "jwe"
+	FormatPasetoLocal  = paseto.VersionLocal
+	FormatPasetoPublic = paseto.VersionPublic
+)
+
+// TokenFormat кодирует claims access токена и восстанавливает их из токена с проверкой подписи или MAC.
+// Проверка claims выполняется после декодирования одинаково для всех форматов
+type TokenFormat interface {
+	Encode(tenant models.Tenant, claims *AccessClaims) (string, error)
+	Decode(tenant models.Tenant, token string, claims *AccessClaims) error
+}
+
+var formats = map[string]TokenFormat{
+	FormatJWT:          jwtFormat{},
+	FormatJWE:          jweFormat{},
+	FormatPasetoLocal:  pasetoLocalFormat{},
+	FormatPasetoPublic: pasetoPublicFormat{},
+}
+
+func ValidFormat(name string) bool {
+	_, ok := formats[name]
+	return ok
+}
+
+// encodeAccess выпускает access токен в формате тенанта, по умолчанию JWT
+func encodeAccess(tenant models.Tenant, claims *AccessClaims) (string, error) {
+	format, ok := formats[tenant.Format]
+	if !ok {
+		format = jwtFormat{}
+	}
+	return format.Encode(tenant, claims)
+}
+
+// decodeAccess определяет формат по заголовку токена PASETO или по числу частей JWE, остальные токены считаются JWT
+func decodeAccess(tenant models.Tenant, token string, claims *AccessClaims) error {
+	for _, name := range []string{FormatPasetoLocal, FormatPasetoPublic} {
+		if strings.HasPrefix(token, name+".") {
+			return formats[name].Decode(tenant, token, claims)
+		}
+	}
+	if jwe.IsJWE(token) {
+		return jweFormat{}.Decode(tenant, token, claims)
+	}
+	return jwtFormat{}.Decode(tenant, token, claims)
+}
+
+type jwtFormat struct{}
+
+func (jwtFormat) Encode(tenant models.Tenant, claims *AccessClaims) (string, error) {
+	return sign(tenant, claims)
+}
+
+func (jwtFormat) Decode(tenant models.Tenant, token string, claims *AccessClaims) error {
+	_, err := jwt.ParseWithClaims(token, claims, keyFunc(tenant), jwt.WithoutClaimsValidation())
+	return err
+}
+
+// jweFormat подписывает claims как JWT и шифрует подписанный токен (RFC 7519 11.2), поэтому
+// claims, включая userAgent, не читаются без ключа шифрования, а подпись проверяется после расшифровки
+type jweFormat struct{}
+
+// contentTypeJWT cty JWE с вложенным JWT (RFC 7519 5.2)
+const contentTypeJWT = "JWT"
+
+func (jweFormat) Encode(tenant models.Tenant, claims *AccessClaims) (string, error) {
+	if tenant.Encryption == nil {
+		return "", apperror.ErrUnknownKey
+	}
+	signed, err := sign(tenant, claims)
+	if err != nil {
+		return "", err
+	}
+	return tenant.Encryption.Encrypt([]byte(signed), contentTypeJWT)
+}
+
+func (jweFormat) Decode(tenant models.Tenant, token string, claims *AccessClaims) error {
+	if tenant.Encryption == nil {
+		return apperror.ErrUnknownKey
+	}
+	signed, contentType, err := tenant.Encryption.Decrypt(token)
+	if err != nil {
+		return err
+	}
+	// вложенный токен проверяется как обычный JWT, расшифровка не заменяет проверку подписи
+	if !strings.EqualFold(contentType, contentTypeJWT) {
+		return jwe.ErrInvalidToken
+	}
+	return jwtFormat{}.Decode(tenant, string(signed), claims)
+}
+
+type pasetoLocalFormat struct{}
+
+func (pasetoLocalFormat) Encode(tenant models.Tenant, claims *AccessClaims) (string, error) {
+	if tenant.Paseto == nil || tenant.Paseto.Local == nil {
+		return "", apperror.ErrUnknownKey
+	}
+	payload, err := pasetoPayload(claims)
+	if err != nil {
+		return "", err
+	}
+	return paseto.Encrypt(tenant.Paseto.Local, payload, nil, nil)
+}
+
+func (pasetoLocalFormat) Decode(tenant models.Tenant, token string, claims *AccessClaims) error {
+	if tenant.Paseto == nil || tenant.Paseto.Local == nil {
+		return apperror.ErrUnknownKey
+	}
+	payload, _, err := paseto.Decrypt(tenant.Paseto.Local, token, nil)
+	if err != nil {
+		return err
+	}
+	return pasetoClaims(payload, claims)
+}
+
+type pasetoPublicFormat struct{}
+
+func (pasetoPublicFormat) Encode(tenant models.Tenant, claims *AccessClaims) (string, error) {
+	if tenant.Paseto == nil || tenant.Paseto.Secret == nil {
+		return "", apperror.ErrUnknownKey
+	}
+	payload, err := pasetoPayload(claims)
+	if err != nil {
+		return "", err
+	}
+	return paseto.Sign(tenant.Paseto.Secret, payload, nil, nil), nil
+}
+
+func (pasetoPublicFormat) Decode(tenant models.Tenant, token string, claims *AccessClaims) error {
+	if tenant.Paseto == nil || tenant.Paseto.Public == nil {
+		return apperror.ErrUnknownKey
+	}
+	payload, _, err := paseto.Verify(tenant.Paseto.Public, token, nil)
+	if err != nil {
+		return err
+	}
+	return pasetoClaims(payload, claims)
+}
+
+// timeClaims claims PASETO, которые передаются строками RFC 3339, а не числами как в JWT
+var timeClaims = []string{"exp", "nbf", "iat"}
+
+// pasetoPayload переводит claims в представление PASETO: время в RFC 3339, единственный aud строкой
+func pasetoPayload(claims *AccessClaims) ([]byte, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]any{}
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	for _, name := range timeClaims {
+		if seconds, ok := payload[name].(float64); ok {
+			payload[name] = time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)
+		}
+	}
+	if aud, ok := payload["aud"].([]any); ok && len(aud) == 1 {
+		payload["aud"] = aud[0]
+	}
+	return json.Marshal(payload)
+}
+
+// pasetoClaims восстанавливает claims из данных PASETO, обратное pasetoPayload
+func pasetoClaims(payload []byte, claims *AccessClaims) error {
+	fields := map[string]any{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return err
+	}
+	for _, name := range timeClaims {
+		value, ok := fields[name].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return jwt.ErrTokenMalformed
+		}
+		fields[name] = t.Unix()
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, claims)
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
+	"github.com/sater-151/AuthSystem/internal/pkg/jwe"
+	"github.com/sater-151/AuthSystem/internal/pkg/keys"
+	"github.com/sater-151/AuthSystem/internal/pkg/paseto"
+)
+
+const testGUID = "090bb747-d6d3-4067-a1da-2b83726eb24d"
+
+// newTestTenant создаёт тенант с ключами всех форматов
+func newTestTenant(t *testing.T, format string) models.Tenant {
+	t.Helper()
+	public, secret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := make([]byte, 32)
+	if _, err = rand.Read(local); err != nil {
+		t.Fatal(err)
+	}
+	encryption, err := jwe.LoadRing("k1:0001020304050607080910111213141516171819202122232425262728293031", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := keys.Generate(keys.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey.ID = ""
+	return models.Tenant{
+		ID:         models.DefaultTenantID,
+		Keys:       keys.NewRing(signingKey),
+		AccessTTL:  time.Minute,
+		Issuer:     "auth-test",
+		Audience:   "authsystem",
+		Format:     format,
+		Paseto:     &paseto.Keys{Local: local, Secret: secret, Public: public},
+		Encryption: encryption,
+	}
+}
+
+func TestAccessTokenFormats(t *testing.T) {
+	for _, format := range []string{FormatJWT, FormatJWE, FormatPasetoLocal, FormatPasetoPublic} {
+		t.Run(format, func(t *testing.T) {
+			tenant := newTestTenant(t, format)
+			aToken, issued, err := NewAccessToken(tenant, "test-agent", testGUID, "session", "openid profile", "jkt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := ParseAccessToken(tenant, aToken, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID() != testGUID || claims.ID != issued.ID || claims.UserAgent != "test-agent" || claims.Scope != "openid profile" || claims.SessionID != "session" || claims.JKT() != "jkt" {
+				t.Fatalf("claims = %+v", claims)
+			}
+			if !claims.ExpiresAt.Time.Equal(issued.ExpiresAt.Time) || claims.Issuer != "auth-test" {
+				t.Fatalf("registered claims = %+v, want %+v", claims.RegisteredClaims, issued.RegisteredClaims)
+			}
+
+			// с теми же настройками, но другими ключами токен не проверяется
+			if _, err = ParseAccessToken(newTestTenant(t, format), aToken, false); err == nil {
+				t.Fatal("token is accepted with other keys")
+			}
+		})
+	}
+}
+
+func TestAccessTokenFormatKeys(t *testing.T) {
+	tests := []struct {
+		format string
+		drop   func(tenant *models.Tenant)
+	}{
+		{FormatJWE, func(tenant *models.Tenant) { tenant.Encryption = nil }},
+		{FormatPasetoLocal, func(tenant *models.Tenant) { tenant.Paseto.Local = nil }},
+		{FormatPasetoPublic, func(tenant *models.Tenant) { tenant.Paseto.Secret, tenant.Paseto.Public = nil, nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			tenant := newTestTenant(t, tt.format)
+			aToken, _, err := NewAccessToken(tenant, "", testGUID, "", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.drop(&tenant)
+			if _, err = ParseAccessToken(tenant, aToken, false); !errors.Is(err, apperror.ErrUnknownKey) {
+				t.Fatalf("parse without key: err = %v, want %v", err, apperror.ErrUnknownKey)
+			}
+			if _, _, err = NewAccessToken(tenant, "", testGUID, "", "", ""); !errors.Is(err, apperror.ErrUnknownKey) {
+				t.Fatalf("issue without key: err = %v, want %v", err, apperror.ErrUnknownKey)
+			}
+		})
+	}
+}
+
+func TestJWERequiresSignature(t *testing.T) {
+	tenant := newTestTenant(t, FormatJWE)
+	other := newTestTenant(t, FormatJWE)
+	other.Encryption = tenant.Encryption
+	// зашифрованный общим ключом токен, подписанный чужим ключом, не проходит проверку подписи
+	aToken, _, err := NewAccessToken(other, "", testGUID, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAccessToken(tenant, aToken, false); err == nil {
+		t.Fatal("nested token signed with other key is accepted")
+	}
+}
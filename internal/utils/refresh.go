@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// RefreshTokenPrefix префикс refresh токена второй версии: rt_v2_<id сессии>_<секрет>.
+// Токен не связан с access токеном, в базе хранится только SHA-256 секрета. Токены без префикса
+// выпущены в первой версии и проверяются по bcrypt до истечения сессий
+const RefreshTokenPrefix = "rt_v2_"
+
+const refreshSecretSize = 32
+
+// sessionIDPattern идентификатор сессии (uuid) проверяется до запроса к базе
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// NewRefreshToken создаёт случайный секрет refresh токена и его хеш для хранения в сессии
+func NewRefreshToken() (secret string, hash string, err error) {
+	b := make([]byte, refreshSecretSize)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashRefreshSecret(secret), nil
+}
+
+// FormatRefreshToken собирает refresh токен из идентификатора сессии и секрета
+func FormatRefreshToken(sessionID string, secret string) string {
+	return RefreshTokenPrefix + sessionID + "_" + secret
+}
+
+// ParseRefreshToken возвращает идентификатор сессии и хеш секрета refresh токена второй версии.
+// ok ложно для токена неверного формата
+func ParseRefreshToken(rToken string) (sessionID string, hash string, ok bool) {
+	rest, ok := strings.CutPrefix(rToken, RefreshTokenPrefix)
+	if !ok {
+		return "", "", false
+	}
+	// идентификатор сессии (uuid) не содержит "_", секрет в base64url может его содержать
+	sessionID, secret, ok := strings.Cut(rest, "_")
+	if !ok || !sessionIDPattern.MatchString(sessionID) || len(secret) != base64.RawURLEncoding.EncodedLen(refreshSecretSize) {
+		return "", "", false
+	}
+	return sessionID, HashRefreshSecret(secret), true
+}
+
+// HashRefreshSecret SHA-256 секрета. Секрет случайный, поэтому медленный хеш не нужен
+func HashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
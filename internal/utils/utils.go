@@ -2,18 +2,72 @@ package utils
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
-	"fmt"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sater-151/AuthSystem/internal/apperror"
+	"github.com/sater-151/AuthSystem/internal/models"
 )
 
 const str = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
 
+const GrantClientCredentials = "client_credentials"
+
+// AccessClaims claims access токена. guid читается только из токенов, выпущенных до перехода на sub. У токена клиента sub равен client_id, а gty равен client_credentials
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Tenant    string `json:"tenant,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Actor     *Actor `json:"act,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	GrantType string `json:"gty,omitempty"`
+	// Confirmation привязка токена к ключу DPoP (RFC 9449 6)
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	GUID         string        `json:"guid,omitempty"`
+	// SessionID идентификатор сессии OAuth клиента, в которой выпущен токен. У токенов сессии входа через cookie пустой
+	SessionID string `json:"sid,omitempty"`
+}
+
+// Confirmation claim cnf, jkt отпечаток открытого ключа DPoP (RFC 7638)
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// Actor claim act (RFC 8693), указывает, кто действует от имени пользователя.
+// При повторном обмене предыдущий участник цепочки вкладывается в act
+type Actor struct {
+	Subject string `json:"sub"`
+	Actor   *Actor `json:"act,omitempty"`
+}
+
+func (c *AccessClaims) UserID() string {
+	if c.Subject != "" {
+		return c.Subject
+	}
+	return c.GUID
+}
+
+// IsClient сообщает, что токен выпущен клиенту от его имени, а не пользователю
+func (c *AccessClaims) IsClient() bool {
+	return c.GrantType == GrantClientCredentials
+}
+
+// JKT возвращает отпечаток ключа DPoP, к которому привязан токен, или пустую строку для bearer токена
+func (c *AccessClaims) JKT() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+	return c.Confirmation.JKT
+}
+
+func confirmation(jkt string) *Confirmation {
+	if jkt == "" {
+		return nil
+	}
+	return &Confirmation{JKT: jkt}
+}
+
 func CreateLink() (string, error) {
 	tokenLink := make([]byte, 32)
 	_, err := rand.Read(tokenLink)
@@ -26,58 +80,230 @@ func CreateLink() (string, error) {
 	return string(tokenLink), nil
 }
 
-func NewTokens(userAgent string, guid string) (aToken string, rToken string, err error) {
-	tokenLink, err := CreateLink()
+// NewAccessToken выпускает access токен сессии пользователя с правами scope. jti сохраняется в сессии, по нему
+// проверяется, что токен последний выпущенный в сессии, и токен отзывается, поэтому claims возвращаются вызывающему.
+// Непустой jkt привязывает токен к ключу DPoP, непустой sessionID указывает сессию OAuth клиента
+func NewAccessToken(tenant models.Tenant, userAgent string, guid string, sessionID string, scope string, jkt string) (aToken string, claims *AccessClaims, err error) {
+	jti, err := CreateLink()
 	if err != nil {
-		return aToken, rToken, err
+		return "", nil, err
 	}
+	claims = &AccessClaims{
+		RegisteredClaims: registeredClaims(tenant, guid, jti, tenant.AccessTTL),
+		Tenant:           tenant.ID,
+		UserAgent:        userAgent,
+		Scope:            scope,
+		Confirmation:     confirmation(jkt),
+		SessionID:        sessionID,
+	}
+	aToken, err = encodeAccess(tenant, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return aToken, claims, nil
+}
 
-	// create access token
-	atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
+// GetGUIDFromJWT возвращает guid пользователя, токен клиента пользователя не содержит
+func GetGUIDFromJWT(tenant models.Tenant, aToken string) (string, error) {
+	claims, err := ParseAccessToken(tenant, aToken, true)
 	if err != nil {
-		return aToken, rToken, err
+		return "", err
 	}
-	claims := &jwt.MapClaims{
-		"exp":        time.Now().Add(time.Second * time.Duration(atTimeExp)).Unix(),
-		"userAgent":  userAgent,
-		"guid":       guid,
-		"linkString": tokenLink,
+	if claims.IsClient() {
+		return "", apperror.ErrUnauthorized
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	aToken, err = accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return claims.UserID(), nil
+}
+
+// ParseAccessToken проверяет подпись и claims access токена в любом поддерживаемом формате. При allowExpired истёкший токен
+// не считается ошибкой, остальные claims в этом случае проверяются на момент его истечения
+func ParseAccessToken(tenant models.Tenant, aToken string, allowExpired bool) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	err := decodeAccess(tenant, aToken, claims)
 	if err != nil {
-		return aToken, rToken, err
+		return nil, err
 	}
 
-	// create refresh token
-	rtSH := sha256.Sum256([]byte(tokenLink))
-	rToken = fmt.Sprintf("%x%v", rtSH, aToken[len(aToken)-6:])
-	return aToken, rToken, nil
+	now := time.Now()
+	if allowExpired && claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Add(tenant.Leeway)) {
+		now = claims.ExpiresAt.Add(-time.Second)
+	}
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(tenant.Leeway),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if tenant.Issuer != "" {
+		options = append(options, jwt.WithIssuer(tenant.Issuer))
+	}
+	if tenant.Audience != "" {
+		options = append(options, jwt.WithAudience(tenant.Audience))
+	}
+	if err = jwt.NewValidator(options...).Validate(claims); err != nil {
+		return nil, err
+	}
+
+	if claims.UserID() == "" {
+		return nil, apperror.ErrUnauthorized
+	}
+	if err = checkTenant(tenant, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
 
-func GetGUIDFromJWT(aToken string) (string, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(aToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-	if err != nil && err.Error() != "token has invalid claims: token is expired" {
+// NewImpersonationToken создаёт access токен пользователя guid без refresh токена.
+// Сотрудник, от имени которого выпущен токен, указывается в claim act (RFC 8693)
+func NewImpersonationToken(tenant models.Tenant, ttl time.Duration, actor string, guid string, userAgent string, scope string) (string, error) {
+	jti, err := CreateLink()
+	if err != nil {
 		return "", err
 	}
-	return claims["guid"].(string), nil
+	claims := &AccessClaims{
+		RegisteredClaims: registeredClaims(tenant, guid, jti, ttl),
+		Tenant:           tenant.ID,
+		UserAgent:        userAgent,
+		Actor:            &Actor{Subject: actor},
+		Scope:            scope,
+	}
+	return encodeAccess(tenant, claims)
 }
 
-func CheckLinkTokens(aToken string, rToken string) error {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(aToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+// NewClientToken создаёт access токен клиента без refresh токена и без привязки к User-Agent.
+// Непустой jkt привязывает токен к ключу DPoP
+func NewClientToken(tenant models.Tenant, ttl time.Duration, clientID string, scope string, jkt string) (string, error) {
+	jti, err := CreateLink()
 	if err != nil {
-		return err
+		return "", err
+	}
+	claims := &AccessClaims{
+		RegisteredClaims: registeredClaims(tenant, clientID, jti, ttl),
+		Tenant:           tenant.ID,
+		ClientID:         clientID,
+		Scope:            scope,
+		GrantType:        GrantClientCredentials,
+		Confirmation:     confirmation(jkt),
+	}
+	return encodeAccess(tenant, claims)
+}
+
+// NewExchangedToken создаёт access токен пользователя guid для сервиса audience по обмену токена (RFC 8693).
+// Вызывающий клиент указывается в act, refresh токен не выдаётся
+func NewExchangedToken(tenant models.Tenant, guid string, audience string, scope string, clientID string, actor *Actor, expiresAt time.Time) (string, error) {
+	jti, err := CreateLink()
+	if err != nil {
+		return "", err
+	}
+	claims := &AccessClaims{
+		RegisteredClaims: registeredClaims(tenant, guid, jti, time.Until(expiresAt)),
+		Tenant:           tenant.ID,
+		Actor:            &Actor{Subject: clientID, Actor: actor},
+		ClientID:         clientID,
+		Scope:            scope,
+	}
+	claims.Audience = jwt.ClaimStrings{audience}
+	return encodeAccess(tenant, claims)
+}
+
+// IDClaims claims ID токена OpenID Connect. aud содержит client_id клиента, получившего токен
+type IDClaims struct {
+	jwt.RegisteredClaims
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+}
+
+// NewIDToken создаёт ID токен пользователя guid для клиента clientID
+func NewIDToken(tenant models.Tenant, guid string, clientID string, nonce string, authTime time.Time, acr string, amr []string) (string, error) {
+	now := time.Now()
+	claims := &IDClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tenant.Issuer,
+			Subject:   guid,
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(now.Add(tenant.AccessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce: nonce,
+		ACR:   acr,
+		AMR:   amr,
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+	return sign(tenant, claims)
+}
+
+// GetActorFromJWT возвращает claims токена, сотрудник указан в claim act только у токена имперсонации.
+// Токен имперсонации не обновляется, поэтому для него истёкший срок действия является ошибкой
+func GetActorFromJWT(tenant models.Tenant, aToken string) (*AccessClaims, error) {
+	claims, err := ParseAccessToken(tenant, aToken, true)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Actor == nil {
+		return claims, nil
+	}
+	if claims.Actor.Subject == "" {
+		return nil, apperror.ErrUnauthorized
+	}
+	if _, err = ParseAccessToken(tenant, aToken, false); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func registeredClaims(tenant models.Tenant, subject string, jti string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    tenant.Issuer,
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        jti,
+	}
+	if tenant.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{tenant.Audience}
+	}
+	return claims
+}
+
+// sign подписывает токен активным ключом тенанта и указывает его kid в заголовке
+func sign(tenant models.Tenant, claims jwt.Claims) (string, error) {
+	key := tenant.Keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.SignKey)
+}
+
+// keyFunc выбирает ключ проверки по kid и не допускает подмену алгоритма
+func keyFunc(tenant models.Tenant) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := tenant.Keys.Lookup(kid)
+		if !ok {
+			return nil, apperror.ErrUnknownKey
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.VerifyKey, nil
+	}
+}
+
+// checkTenant сверяет тенант из токена с тенантом запроса. Токены без тенанта относятся к тенанту по умолчанию
+func checkTenant(tenant models.Tenant, claims *AccessClaims) error {
+	tenantID := claims.Tenant
+	if tenantID == "" {
+		tenantID = models.DefaultTenantID
 	}
-	rToken = rToken[:len(rToken)-6]
-	linkString := claims["linkString"].(string)
-	if fmt.Sprintf("%x", sha256.Sum256([]byte(linkString))) != rToken {
-		return apperror.ErrUnauthorized
+	if tenantID != tenant.ID {
+		return apperror.ErrTenantMismatch
 	}
 	return nil
 }
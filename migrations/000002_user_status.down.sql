@@ -0,0 +1,2 @@
+ALTER TABLE users_auth DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS status;
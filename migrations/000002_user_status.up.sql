@@ -0,0 +1,2 @@
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+ALTER TABLE users_auth DROP COLUMN IF EXISTS refreshed_at;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS mfa_enabled;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS roles;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS display_name;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS email;
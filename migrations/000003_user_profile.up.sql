@@ -0,0 +1,5 @@
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS email TEXT;
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS display_name TEXT;
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS mfa_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+ALTER TABLE users_auth DROP CONSTRAINT IF EXISTS users_auth_tenant_user_key;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users_auth ADD CONSTRAINT users_auth_user_id_key UNIQUE (user_id);
+DROP TABLE IF EXISTS tenants;
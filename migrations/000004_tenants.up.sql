@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS tenants(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    host TEXT UNIQUE,
+    jwt_secret TEXT,
+    at_expires INTEGER,
+    rt_expires INTEGER
+);
+INSERT INTO tenants (id, name) VALUES ('default', 'Default tenant') ON CONFLICT DO NOTHING;
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE users_auth DROP CONSTRAINT IF EXISTS users_auth_user_id_key;
+ALTER TABLE users_auth ADD CONSTRAINT users_auth_tenant_user_key UNIQUE (tenant_id, user_id);
@@ -0,0 +1,2 @@
+UPDATE users_auth SET roles = array_remove(roles, 'admin') WHERE user_id='2df8716b-d385-4b7e-aae9-4618996c438a';
+DROP TABLE IF EXISTS impersonation_audit;
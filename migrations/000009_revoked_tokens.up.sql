@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens(
+    jti TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS REVOKED_TOKENS_EXPIRES_INDEX ON revoked_tokens(expires_at);
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS access_jti TEXT;
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS access_expires_at TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oauth_consents;
+DROP TABLE IF EXISTS oauth_codes;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS first_party;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS redirect_uris;
@@ -0,0 +1,3 @@
+DELETE FROM oauth_clients WHERE client_id='orders-job';
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS scopes;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS client_credentials;
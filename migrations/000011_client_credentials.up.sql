@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS client_credentials BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+INSERT INTO oauth_clients (client_id, name, secret_hash, client_credentials, scopes) VALUES ('orders-job', 'Test orders background job', crypt('orders-job-secret', gen_salt('bf')), true, '{orders:read,orders:write}') ON CONFLICT DO NOTHING;
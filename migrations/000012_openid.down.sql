@@ -0,0 +1,3 @@
+ALTER TABLE oauth_codes DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth_codes DROP COLUMN IF EXISTS nonce;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS authenticated_at;
@@ -0,0 +1,3 @@
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS authenticated_at TIMESTAMPTZ;
+ALTER TABLE oauth_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS oauth_device_codes(
+    device_code_hash TEXT PRIMARY KEY,
+    user_code TEXT NOT NULL,
+    tenant_id TEXT NOT NULL REFERENCES tenants(id),
+    client_id TEXT NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    user_id uuid,
+    status TEXT NOT NULL DEFAULT 'pending',
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, user_code)
+);
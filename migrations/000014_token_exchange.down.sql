@@ -0,0 +1,2 @@
+DELETE FROM oauth_clients WHERE client_id='orders-api';
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS exchange_audiences;
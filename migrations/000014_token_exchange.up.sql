@@ -0,0 +1,2 @@
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS exchange_audiences TEXT[] NOT NULL DEFAULT '{}';
+INSERT INTO oauth_clients (client_id, name, secret_hash, scopes, exchange_audiences) VALUES ('orders-api', 'Test orders service', crypt('orders-api-secret', gen_salt('bf')), '{payments:read,payments:write}', '{payments-api}') ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dpop_proofs;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS dpop_jkt;
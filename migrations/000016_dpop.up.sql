@@ -0,0 +1,6 @@
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS dpop_jkt TEXT;
+CREATE TABLE IF NOT EXISTS dpop_proofs(
+    proof_hash TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS DPOP_PROOFS_EXPIRES_INDEX ON dpop_proofs(expires_at);
@@ -0,0 +1 @@
+-- роль admin тестовому пользователю не возвращается, роли назначаются через cmd/roles
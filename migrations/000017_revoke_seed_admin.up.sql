@@ -0,0 +1,2 @@
+-- 000005 назначала admin тестовому пользователю тенанта default, роли назначаются через cmd/roles
+UPDATE users_auth SET roles = array_remove(roles, 'admin') WHERE tenant_id='default' AND user_id='2df8716b-d385-4b7e-aae9-4618996c438a';
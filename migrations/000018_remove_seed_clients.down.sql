@@ -0,0 +1 @@
+-- тестовые клиенты с известными секретами не восстанавливаются, для разработки используется dev/seed.sql
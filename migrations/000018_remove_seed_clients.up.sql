@@ -0,0 +1,3 @@
+DELETE FROM oauth_clients WHERE client_id='resource-server';
+DELETE FROM oauth_clients WHERE client_id='orders-job';
+DELETE FROM oauth_clients WHERE client_id='orders-api';
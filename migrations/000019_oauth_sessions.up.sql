@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS oauth_sessions(
+    id uuid DEFAULT uuid_generate_v4 (),
+    tenant_id TEXT NOT NULL REFERENCES tenants(id),
+    client_id TEXT NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id uuid NOT NULL,
+    refresh_t TEXT,
+    user_agent TEXT,
+    user_ip TEXT,
+    scope TEXT,
+    dpop_jkt TEXT,
+    access_jti TEXT,
+    access_expires_at TIMESTAMPTZ,
+    authenticated_at TIMESTAMPTZ,
+    refreshed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS OAUTH_SESSIONS_USER_INDEX ON oauth_sessions(tenant_id, user_id);
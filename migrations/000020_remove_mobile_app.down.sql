@@ -0,0 +1 @@
+-- тестовый клиент mobile-app не восстанавливается, для разработки используется dev/seed.sql
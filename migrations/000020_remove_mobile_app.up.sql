@@ -0,0 +1,2 @@
+-- доверенный публичный клиент с тестовыми redirect URI получал коды без согласия пользователя, для разработки используется dev/seed.sql
+DELETE FROM oauth_clients WHERE client_id='mobile-app';